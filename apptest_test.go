@@ -0,0 +1,462 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v4"
+	"github.com/mattermost/mattermost-plugin-apps/apps"
+	"github.com/mattermost/mattermost-server/v6/model"
+	"golang.org/x/oauth2"
+)
+
+const (
+	testAppSecret       = "test-app-secret"
+	testBotUserID       = "botuserid00000000000000000"
+	testBotAccessToken  = "bot-access-token"
+	testUserAccessToken = "user-access-token"
+	appsPluginAPIPath   = "/plugins/com.mattermost.apps/api/v1"
+)
+
+// TestMain silences the handlers' request logging unless the tests run verbosely
+func TestMain(m *testing.M) {
+	flag.Parse()
+	if !testing.Verbose() {
+		log.SetOutput(io.Discard)
+	}
+	os.Exit(m.Run())
+}
+
+// fakeMattermostServer stands in for the Mattermost REST API and the Apps plugin API, recording what the app
+// does through them
+type fakeMattermostServer struct {
+	*httptest.Server
+
+	mutex         sync.Mutex
+	Posts         []*model.Post
+	Subscriptions []apps.Subscription
+	// SubscriptionTokens holds the access token that made each of the Subscriptions
+	SubscriptionTokens []string
+	ChannelMembers     map[string][]string
+	KV                 map[string]json.RawMessage
+	KVWrites           []string
+	OAuth2App          *apps.OAuth2App
+	OAuth2User         json.RawMessage
+	Users              []*model.User
+	Channels           []*model.Channel
+	// Failures makes requests to "METHOD path" fail with the status
+	Failures map[string]int
+}
+
+func newFakeMattermostServer(t *testing.T) *fakeMattermostServer {
+	t.Helper()
+	fake := &fakeMattermostServer{
+		ChannelMembers: make(map[string][]string),
+		KV:             make(map[string]json.RawMessage),
+		Failures:       make(map[string]int),
+	}
+	fake.Server = httptest.NewServer(http.HandlerFunc(fake.serveHTTP))
+	t.Cleanup(fake.Server.Close)
+	return fake
+}
+
+func writeFakeJSON(w http.ResponseWriter, statusCode int, value interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(statusCode)
+	_ = json.NewEncoder(w).Encode(value)
+}
+
+func (f *fakeMattermostServer) serveHTTP(w http.ResponseWriter, r *http.Request) {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	if status, ok := f.Failures[r.Method+" "+r.URL.Path]; ok {
+		writeFakeJSON(w, status, model.NewAppError("fake", "fake.failure", nil, "", status))
+		return
+	}
+	if r.URL.Path == "/oauth2/token" {
+		// the token endpoint of the OAuth2 provider authenticates with client credentials instead
+		writeFakeJSON(w, http.StatusOK, map[string]interface{}{
+			"access_token": "provider-access-token",
+			"token_type":   "Bearer",
+			"expires_in":   3600,
+		})
+		return
+	}
+	// appclient authenticates with the OAuth "Token" scheme and the plugin client with "Bearer"
+	authorization := strings.ToUpper(r.Header.Get(model.HeaderAuth))
+	if !strings.HasPrefix(authorization, strings.ToUpper(model.HeaderToken)+" ") && !strings.HasPrefix(authorization, model.HeaderBearer+" ") {
+		writeFakeJSON(w, http.StatusUnauthorized, model.NewAppError("fake", "fake.unauthorized", nil, "", http.StatusUnauthorized))
+		return
+	}
+	body, _ := io.ReadAll(r.Body)
+	segments := strings.Split(strings.Trim(r.URL.Path, "/"), "/")
+	switch {
+	case strings.HasPrefix(r.URL.Path, appsPluginAPIPath+"/kv/"):
+		f.serveKV(w, r, strings.TrimPrefix(r.URL.Path, appsPluginAPIPath+"/kv/"), body)
+	case r.URL.Path == appsPluginAPIPath+"/subscribe" && r.Method == http.MethodPost:
+		subscription := apps.Subscription{}
+		_ = json.Unmarshal(body, &subscription)
+		f.Subscriptions = append(f.Subscriptions, subscription)
+		f.SubscriptionTokens = append(f.SubscriptionTokens, accessToken(r))
+		writeFakeJSON(w, http.StatusOK, map[string]string{})
+	case r.URL.Path == appsPluginAPIPath+"/unsubscribe" && r.Method == http.MethodPost:
+		subscription := apps.Subscription{}
+		_ = json.Unmarshal(body, &subscription)
+		for i, existing := range f.Subscriptions {
+			if existing.Event == subscription.Event && f.SubscriptionTokens[i] == accessToken(r) {
+				f.Subscriptions = append(f.Subscriptions[:i], f.Subscriptions[i+1:]...)
+				f.SubscriptionTokens = append(f.SubscriptionTokens[:i], f.SubscriptionTokens[i+1:]...)
+				break
+			}
+		}
+		writeFakeJSON(w, http.StatusOK, map[string]string{})
+	case r.URL.Path == appsPluginAPIPath+"/oauth2/app" && r.Method == http.MethodPost:
+		oauth2App := apps.OAuth2App{}
+		_ = json.Unmarshal(body, &oauth2App)
+		f.OAuth2App = &oauth2App
+		writeFakeJSON(w, http.StatusOK, map[string]string{})
+	case r.URL.Path == appsPluginAPIPath+"/oauth2/user" && r.Method == http.MethodPost:
+		f.OAuth2User = body
+		writeFakeJSON(w, http.StatusOK, map[string]string{})
+	case r.URL.Path == "/api/v4/posts" && r.Method == http.MethodPost:
+		post := &model.Post{}
+		_ = json.Unmarshal(body, post)
+		post.Id = model.NewId()
+		post.CreateAt = model.GetMillis()
+		f.Posts = append(f.Posts, post)
+		writeFakeJSON(w, http.StatusCreated, post)
+	case len(segments) == 4 && segments[2] == "posts" && r.Method == http.MethodPut:
+		post := &model.Post{}
+		_ = json.Unmarshal(body, post)
+		for i, existing := range f.Posts {
+			if existing.Id == segments[3] {
+				f.Posts[i] = post
+				writeFakeJSON(w, http.StatusOK, post)
+				return
+			}
+		}
+		writeFakeJSON(w, http.StatusNotFound, model.NewAppError("fake", "fake.post_not_found", nil, "", http.StatusNotFound))
+	case r.URL.Path == "/api/v4/channels/direct" && r.Method == http.MethodPost:
+		userIDs := make([]string, 0, 2)
+		_ = json.Unmarshal(body, &userIDs)
+		if len(userIDs) != 2 {
+			writeFakeJSON(w, http.StatusBadRequest, model.NewAppError("fake", "fake.bad_request", nil, "", http.StatusBadRequest))
+			return
+		}
+		writeFakeJSON(w, http.StatusCreated, &model.Channel{
+			Id:   model.GetDMNameFromIds(userIDs[0], userIDs[1]),
+			Type: model.ChannelTypeDirect,
+		})
+	case len(segments) == 5 && segments[2] == "channels" && segments[4] == "members" && r.Method == http.MethodPost:
+		member := map[string]string{}
+		_ = json.Unmarshal(body, &member)
+		channelID := segments[3]
+		if !containsString(f.ChannelMembers[channelID], member["user_id"]) {
+			f.ChannelMembers[channelID] = append(f.ChannelMembers[channelID], member["user_id"])
+		}
+		writeFakeJSON(w, http.StatusCreated, &model.ChannelMember{ChannelId: channelID, UserId: member["user_id"]})
+	case r.URL.Path == "/api/v4/users/autocomplete":
+		users := make([]*model.User, 0)
+		for _, user := range f.Users {
+			if strings.HasPrefix(user.Username, r.URL.Query().Get("name")) {
+				users = append(users, user)
+			}
+		}
+		writeFakeJSON(w, http.StatusOK, &model.UserAutocomplete{Users: users})
+	case len(segments) == 6 && segments[2] == "teams" && segments[4] == "channels" && segments[5] == "autocomplete":
+		channels := make([]*model.Channel, 0)
+		for _, channel := range f.Channels {
+			if channel.TeamId == segments[3] && strings.HasPrefix(channel.Name, r.URL.Query().Get("name")) {
+				channels = append(channels, channel)
+			}
+		}
+		writeFakeJSON(w, http.StatusOK, channels)
+	default:
+		writeFakeJSON(w, http.StatusNotFound, model.NewAppError("fake", "fake.not_found", nil, r.Method+" "+r.URL.Path, http.StatusNotFound))
+	}
+}
+
+// accessToken returns the token that authenticates a request
+func accessToken(r *http.Request) string {
+	fields := strings.Fields(r.Header.Get(model.HeaderAuth))
+	if len(fields) != 2 {
+		return ""
+	}
+	return fields[1]
+}
+
+func (f *fakeMattermostServer) serveKV(w http.ResponseWriter, r *http.Request, key string, body []byte) {
+	switch r.Method {
+	case http.MethodGet:
+		value, ok := f.KV[key]
+		if !ok {
+			value = json.RawMessage("null")
+		}
+		w.Header().Set("Content-Type", "application/json")
+		_, _ = w.Write(value)
+	case http.MethodPost:
+		changed := !bytes.Equal(f.KV[key], body)
+		f.KV[key] = json.RawMessage(body)
+		f.KVWrites = append(f.KVWrites, key)
+		writeFakeJSON(w, http.StatusOK, map[string]bool{"changed": changed})
+	case http.MethodDelete:
+		delete(f.KV, key)
+		f.KVWrites = append(f.KVWrites, key)
+		writeFakeJSON(w, http.StatusOK, map[string]string{})
+	default:
+		writeFakeJSON(w, http.StatusMethodNotAllowed, map[string]string{})
+	}
+}
+
+// kvGet decodes the stored value of a KV key into ref and reports whether the key exists
+func (f *fakeMattermostServer) kvGet(t *testing.T, prefix string, id string, ref interface{}) bool {
+	t.Helper()
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	value, ok := f.KV[path.Join(prefix, id)]
+	if !ok {
+		return false
+	}
+	if err := json.Unmarshal(value, ref); err != nil {
+		t.Fatalf("error decoding KV value %s/%s: %s", prefix, id, err.Error())
+	}
+	return true
+}
+
+// kvSet stores a KV value as if the app had written it
+func (f *fakeMattermostServer) kvSet(t *testing.T, prefix string, id string, value interface{}) {
+	t.Helper()
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	data, err := json.Marshal(value)
+	if err != nil {
+		t.Fatalf("error encoding KV value: %s", err.Error())
+	}
+	f.KV[path.Join(prefix, id)] = data
+}
+
+func (f *fakeMattermostServer) posts() []*model.Post {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	return append([]*model.Post(nil), f.Posts...)
+}
+
+// appTest runs the app's HTTP handler against a fake Mattermost server
+type appTest struct {
+	t          *testing.T
+	mattermost *fakeMattermostServer
+	app        *httptest.Server
+	user       *model.User
+	team       *model.Team
+	channel    *model.Channel
+}
+
+// newAppTest starts the app with a signing secret and fresh in-memory state
+func newAppTest(t *testing.T) *appTest {
+	t.Helper()
+	previousSecret, previousScheduler := appSecret, jobScheduler
+	appSecret = testAppSecret
+	jobScheduler = newScheduler()
+	t.Cleanup(func() {
+		appSecret, jobScheduler = previousSecret, previousScheduler
+	})
+	at := &appTest{
+		t:          t,
+		mattermost: newFakeMattermostServer(t),
+		app:        httptest.NewServer(newServerHandler(defaultServerConfig)),
+		user: &model.User{
+			Id:       model.NewId(),
+			Username: "alice",
+			Roles:    model.SystemUserRoleId + " " + model.SystemAdminRoleId,
+			Timezone: model.StringMap{"useAutomaticTimezone": "false", "manualTimezone": "UTC"},
+		},
+		team: &model.Team{
+			Id:   model.NewId(),
+			Name: "team",
+		},
+	}
+	at.channel = &model.Channel{
+		Id:     model.NewId(),
+		TeamId: at.team.Id,
+		Name:   "town-square",
+		Type:   model.ChannelTypeOpen,
+	}
+	t.Cleanup(at.app.Close)
+	// the jobs that the first call starts loading are loaded before the fake server closes, so that loading does
+	// not outlive the test
+	t.Cleanup(func() {
+		waitForSchedulerLoad(t)
+	})
+	return at
+}
+
+// context returns the fully expanded context of a call made by a system administrator in a team channel
+func (at *appTest) context() apps.Context {
+	return apps.Context{
+		ExpandedContext: apps.ExpandedContext{
+			MattermostSiteURL:     at.mattermost.URL,
+			AppPath:               "/plugins/com.mattermost.apps/apps/" + string(appManifest.AppID),
+			BotUserID:             testBotUserID,
+			BotAccessToken:        testBotAccessToken,
+			ActingUser:            at.user,
+			ActingUserAccessToken: testUserAccessToken,
+			Channel:               at.channel,
+			Team:                  at.team,
+			App: &apps.App{
+				Manifest:    appManifest,
+				BotUserID:   testBotUserID,
+				BotUsername: "hello-world-bot",
+			},
+		},
+	}
+}
+
+// signCall returns the authorization header value that the Apps plugin sends with a call
+func (at *appTest) signCall(secret string) string {
+	at.t.Helper()
+	claims := apps.JWTClaims{
+		ActingUserID: at.user.Id,
+	}
+	claims.ExpiresAt = time.Now().Add(15 * time.Minute).Unix()
+	token, err := jwt.NewWithClaims(jwt.SigningMethodHS256, claims).SignedString([]byte(secret))
+	if err != nil {
+		at.t.Fatalf("error signing call: %s", err.Error())
+	}
+	return "Bearer " + token
+}
+
+// post sends a raw request to the app and returns its status and body
+func (at *appTest) post(path string, body []byte, header http.Header) (int, []byte) {
+	at.t.Helper()
+	req, err := http.NewRequest(http.MethodPost, at.app.URL+path, bytes.NewReader(body))
+	if err != nil {
+		at.t.Fatalf("error creating request: %s", err.Error())
+	}
+	for name, values := range header {
+		req.Header[name] = values
+	}
+	resp, err := at.app.Client().Do(req)
+	if err != nil {
+		at.t.Fatalf("error calling %s: %s", path, err.Error())
+	}
+	defer resp.Body.Close()
+	responseBody, err := io.ReadAll(resp.Body)
+	if err != nil {
+		at.t.Fatalf("error reading response of %s: %s", path, err.Error())
+	}
+	return resp.StatusCode, responseBody
+}
+
+// call sends a signed CallRequest to the app as the Apps plugin would and decodes the response
+func (at *appTest) call(callRequest apps.CallRequest) (int, apps.CallResponse) {
+	at.t.Helper()
+	if callRequest.Context.MattermostSiteURL == "" {
+		callRequest.Context = at.context()
+	}
+	body, err := json.Marshal(callRequest)
+	if err != nil {
+		at.t.Fatalf("error encoding call request: %s", err.Error())
+	}
+	statusCode, responseBody := at.post(callRequest.Path, body, http.Header{
+		"Content-Type":          []string{"application/json"},
+		apps.OutgoingAuthHeader: []string{at.signCall(testAppSecret)},
+	})
+	callResponse := apps.CallResponse{}
+	if err = json.Unmarshal(responseBody, &callResponse); err != nil {
+		at.t.Fatalf("error decoding response of %s (status %d): %s: %s", callRequest.Path, statusCode, err.Error(), responseBody)
+	}
+	return statusCode, callResponse
+}
+
+// callPath sends a call with the default context and the values
+func (at *appTest) callPath(path string, values map[string]interface{}) (int, apps.CallResponse) {
+	at.t.Helper()
+	return at.call(apps.CallRequest{
+		Call:   *apps.NewCall(path),
+		Values: values,
+	})
+}
+
+// mustCall sends a call and fails the test unless it succeeds with the response type
+func (at *appTest) mustCall(path string, values map[string]interface{}, responseType apps.CallResponseType) apps.CallResponse {
+	at.t.Helper()
+	statusCode, callResponse := at.callPath(path, values)
+	if callResponse.Type == "" {
+		// the Apps plugin treats a response without a type as ok
+		callResponse.Type = apps.CallResponseTypeOK
+	}
+	if statusCode != http.StatusOK || callResponse.Type != responseType {
+		at.t.Fatalf("call %s returned status %d and %s response %q, want %s", path, statusCode, callResponse.Type, callResponse.Text, responseType)
+	}
+	return callResponse
+}
+
+// mustFailCall sends a call and fails the test unless it is answered with status 200 by an error response whose
+// text contains want
+func (at *appTest) mustFailCall(callRequest apps.CallRequest, want string) apps.CallResponse {
+	at.t.Helper()
+	statusCode, callResponse := at.call(callRequest)
+	if statusCode != http.StatusOK || callResponse.Type != apps.CallResponseTypeError || !strings.Contains(callResponse.Text, want) {
+		at.t.Fatalf("call %s returned status %d and %s response %q, want an error containing %q", callRequest.Path, statusCode, callResponse.Type, callResponse.Text, want)
+	}
+	return callResponse
+}
+
+// mustFail sends a call with the default context and the values, and fails the test unless it is answered with an
+// error response whose text contains want
+func (at *appTest) mustFail(path string, values map[string]interface{}, want string) apps.CallResponse {
+	at.t.Helper()
+	return at.mustFailCall(apps.CallRequest{
+		Call:   *apps.NewCall(path),
+		Values: values,
+	}, want)
+}
+
+// oauth2Context returns the expanded OAuth2 context of an app configured against the fake server
+func (at *appTest) oauth2Context(token *oauth2.Token) apps.OAuth2Context {
+	oauth2Context := apps.OAuth2Context{
+		OAuth2App: apps.OAuth2App{
+			ClientID:     "client-id",
+			ClientSecret: "client-secret",
+			Data: oauth2ProviderData{
+				AuthURL:  at.mattermost.URL + "/oauth2/authorize",
+				TokenURL: at.mattermost.URL + "/oauth2/token",
+			},
+		},
+		ConnectURL:  fmt.Sprintf("%s/plugins/com.mattermost.apps/apps/%s/oauth2/remote/connect", at.mattermost.URL, appManifest.AppID),
+		CompleteURL: fmt.Sprintf("%s/plugins/com.mattermost.apps/apps/%s/oauth2/remote/complete", at.mattermost.URL, appManifest.AppID),
+	}
+	if token != nil {
+		oauth2Context.User = oauth2User{Token: token}
+	}
+	return oauth2Context
+}
+
+// waitForSchedulerLoad waits until the scheduler is no longer loading the persisted jobs, which after a call
+// means that it has loaded them, so that a job removed by a test cannot be loaded again afterwards
+func waitForSchedulerLoad(t *testing.T) {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for time.Now().Before(deadline) {
+		jobScheduler.mutex.Lock()
+		loading := jobScheduler.loading
+		jobScheduler.mutex.Unlock()
+		if !loading {
+			return
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	t.Fatalf("the scheduler did not load its jobs")
+}
@@ -0,0 +1,65 @@
+package main
+
+import (
+	"io/fs"
+	"path"
+	"regexp"
+	"strings"
+
+	"github.com/mattermost/mattermost-plugin-apps/apps"
+	"github.com/mattermost/mattermost-plugin-apps/apps/appclient"
+	appspath "github.com/mattermost/mattermost-plugin-apps/apps/path"
+)
+
+var (
+	// markdownInlineTargetPattern matches the target of an inline Markdown image or link, such as
+	// ![image](icon.png "title"): the opening, the target and the rest up to the closing parenthesis
+	markdownInlineTargetPattern = regexp.MustCompile(`(!?\[[^\]]*\]\(\s*)([^)\s]+)([^)]*\))`)
+	// markdownReferenceTargetPattern matches the target of a Markdown reference definition, such as
+	// [icon]: /static/icon.png
+	markdownReferenceTargetPattern = regexp.MustCompile(`(?m)^(\s{0,3}\[[^\]]+\]:\s*)(\S+)`)
+)
+
+// assetURL returns the public URL of a file in static/, which the Apps plugin serves beneath the path of the
+// installation that made the call
+func assetURL(appContext apps.Context, name string) string {
+	appPath := appContext.AppPath
+	if appPath == "" {
+		appPath = path.Join("/plugins", appclient.AppsPluginName, appspath.Apps, string(appManifest.AppID))
+	}
+	return appContext.MattermostSiteURL + appPath + appspath.Static + "/" + strings.TrimPrefix(name, "/")
+}
+
+// staticAssetName returns the name of the embedded static file that a Markdown target such as icon.png or
+// /static/icon.png refers to; URLs and targets that name no embedded file are not assets
+func staticAssetName(target string) (string, bool) {
+	if strings.Contains(target, ":") || strings.HasPrefix(target, "//") || strings.HasPrefix(target, "#") {
+		return "", false
+	}
+	name := strings.TrimPrefix(strings.TrimPrefix(target, "/"), appspath.StaticFolder+"/")
+	info, err := fs.Stat(staticFS, name)
+	if err != nil || info.IsDir() {
+		return "", false
+	}
+	return name, true
+}
+
+// renderMarkdown rewrites the images and links in app-authored Markdown that refer to static assets so that
+// they resolve in Mattermost; without a site URL the Markdown is returned unchanged
+func renderMarkdown(appContext apps.Context, markdown string) string {
+	if appContext.MattermostSiteURL == "" {
+		return markdown
+	}
+	rewrite := func(pattern *regexp.Regexp) func(string) string {
+		return func(match string) string {
+			groups := pattern.FindStringSubmatch(match)
+			name, ok := staticAssetName(groups[2])
+			if !ok {
+				return match
+			}
+			return groups[1] + assetURL(appContext, name) + strings.Join(groups[3:], "")
+		}
+	}
+	markdown = markdownInlineTargetPattern.ReplaceAllStringFunc(markdown, rewrite(markdownInlineTargetPattern))
+	return markdownReferenceTargetPattern.ReplaceAllStringFunc(markdown, rewrite(markdownReferenceTargetPattern))
+}
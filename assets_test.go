@@ -0,0 +1,57 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/mattermost/mattermost-plugin-apps/apps"
+)
+
+func TestAssetURL(t *testing.T) {
+	appContext := apps.Context{
+		ExpandedContext: apps.ExpandedContext{
+			MattermostSiteURL: "https://mattermost.example.com",
+			AppPath:           "/plugins/com.mattermost.apps/apps/hello-world",
+		},
+	}
+	want := "https://mattermost.example.com/plugins/com.mattermost.apps/apps/hello-world/static/icon.png"
+	if got := assetURL(appContext, "icon.png"); got != want {
+		t.Errorf("got %s, want %s", got, want)
+	}
+	// the app path is derived from the app ID when the call does not include it
+	appContext.AppPath = ""
+	if got := assetURL(appContext, "/icon.png"); got != want {
+		t.Errorf("without an app path got %s, want %s", got, want)
+	}
+}
+
+func TestRenderMarkdownRewritesAssetReferences(t *testing.T) {
+	appContext := apps.Context{
+		ExpandedContext: apps.ExpandedContext{
+			MattermostSiteURL: "https://mm.example.com",
+			AppPath:           "/plugins/com.mattermost.apps/apps/hello-world",
+		},
+	}
+	icon := "https://mm.example.com/plugins/com.mattermost.apps/apps/hello-world/static/icon.png"
+	tests := []struct {
+		markdown string
+		want     string
+	}{
+		{"![image](icon.png)", "![image](" + icon + ")"},
+		{"![image](/static/icon.png)", "![image](" + icon + ")"},
+		{"![image]( static/icon.png \"The icon\")", "![image]( " + icon + " \"The icon\")"},
+		{"[download](icon.png) and ![head](icon-head.png)", "[download](" + icon + ") and ![head](https://mm.example.com/plugins/com.mattermost.apps/apps/hello-world/static/icon-head.png)"},
+		{"[icon]: /static/icon.png\n![image][icon]", "[icon]: " + icon + "\n![image][icon]"},
+		{"![image](missing.png)", "![image](missing.png)"},
+		{"![image](https://example.com/icon.png)", "![image](https://example.com/icon.png)"},
+		{"[section](#icon.png)", "[section](#icon.png)"},
+		{"icon.png in plain text", "icon.png in plain text"},
+	}
+	for _, test := range tests {
+		if got := renderMarkdown(appContext, test.markdown); got != test.want {
+			t.Errorf("renderMarkdown(%q) = %q, want %q", test.markdown, got, test.want)
+		}
+	}
+	if got := renderMarkdown(apps.Context{}, "![image](icon.png)"); got != "![image](icon.png)" {
+		t.Errorf("without a site URL got %q", got)
+	}
+}
@@ -0,0 +1,58 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"strings"
+
+	"github.com/golang-jwt/jwt/v4"
+	"github.com/mattermost/mattermost-plugin-apps/apps"
+)
+
+// appSecret is the secret shared with the Apps plugin, from APP_SECRET. When it is set the manifest asks the
+// plugin to sign every call with a JWT, and calls without a valid one are rejected.
+var appSecret string
+
+// setupCallAuthentication turns on call signing when APP_SECRET is set. The secret must also be given to the
+// Apps plugin when the app is installed, or every call is rejected.
+func setupCallAuthentication() {
+	appSecret = os.Getenv("APP_SECRET")
+	appManifest.Deploy.HTTP.UseJWT = appSecret != ""
+	if appSecret != "" {
+		log.Printf("setupCallAuthentication(): calls must be signed with the app secret\n")
+	}
+}
+
+// requireJWT rejects a call that is not signed with the app secret, if there is one
+func requireJWT(handler http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if appSecret != "" {
+			err := verifyCallJWT(r.Header.Get(apps.OutgoingAuthHeader))
+			if err != nil {
+				log.Printf("requireJWT(): %s %s: %s\n", r.Method, r.URL.Path, err.Error())
+				sendStatusErrorResponse(w, http.StatusForbidden, errors.New("the call is not signed by the Apps plugin"))
+				return
+			}
+		}
+		handler(w, r)
+	}
+}
+
+// verifyCallJWT checks the bearer token of a call's authorization header
+func verifyCallJWT(header string) error {
+	token := strings.TrimPrefix(header, "Bearer ")
+	if token == "" || token == header {
+		return errors.New("missing bearer token")
+	}
+	claims := apps.JWTClaims{}
+	_, err := jwt.ParseWithClaims(token, &claims, func(token *jwt.Token) (interface{}, error) {
+		if _, ok := token.Method.(*jwt.SigningMethodHMAC); !ok {
+			return nil, fmt.Errorf("unexpected signing method %v", token.Header["alg"])
+		}
+		return []byte(appSecret), nil
+	})
+	return err
+}
@@ -0,0 +1,117 @@
+// oauth2-stub is a minimal OAuth2 authorization server used to exercise the
+// app's connect/disconnect flow locally. Every authorization request is
+// approved immediately and every issued code can be exchanged exactly once.
+package main
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"sync"
+	"time"
+)
+
+var (
+	clientID     = "stub-client-id"
+	clientSecret = "stub-client-secret"
+
+	codesMutex = sync.Mutex{}
+	codes      = make(map[string]string)
+)
+
+func randomString() string {
+	buf := make([]byte, 16)
+	_, _ = rand.Read(buf)
+	return hex.EncodeToString(buf)
+}
+
+func authorize(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	if query.Get("client_id") != clientID {
+		http.Error(w, "unknown client_id", http.StatusBadRequest)
+		return
+	}
+	redirectURL, err := url.Parse(query.Get("redirect_uri"))
+	if err != nil || redirectURL.String() == "" {
+		http.Error(w, "invalid redirect_uri", http.StatusBadRequest)
+		return
+	}
+	code := randomString()
+	codesMutex.Lock()
+	codes[code] = query.Get("redirect_uri")
+	codesMutex.Unlock()
+	redirectQuery := redirectURL.Query()
+	redirectQuery.Set("code", code)
+	redirectQuery.Set("state", query.Get("state"))
+	redirectURL.RawQuery = redirectQuery.Encode()
+	log.Printf("authorize(): issued code %s\n", code)
+	http.Redirect(w, r, redirectURL.String(), http.StatusFound)
+}
+
+func token(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	err := r.ParseForm()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	id, secret, ok := r.BasicAuth()
+	if !ok {
+		id = r.PostForm.Get("client_id")
+		secret = r.PostForm.Get("client_secret")
+	}
+	if id != clientID || secret != clientSecret {
+		http.Error(w, "invalid client credentials", http.StatusUnauthorized)
+		return
+	}
+	code := r.PostForm.Get("code")
+	codesMutex.Lock()
+	_, ok = codes[code]
+	delete(codes, code)
+	codesMutex.Unlock()
+	if !ok {
+		http.Error(w, "invalid code", http.StatusBadRequest)
+		return
+	}
+	log.Printf("token(): exchanged code %s\n", code)
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(map[string]interface{}{
+		"access_token":  randomString(),
+		"refresh_token": randomString(),
+		"token_type":    "Bearer",
+		"expires_in":    3600,
+	})
+}
+
+func main() {
+	serverAddress := "localhost:4001"
+	envAddress, ok := os.LookupEnv("SERVER_ADDRESS")
+	if ok && envAddress != "" {
+		serverAddress = envAddress
+	}
+	envClientID, ok := os.LookupEnv("CLIENT_ID")
+	if ok && envClientID != "" {
+		clientID = envClientID
+	}
+	envClientSecret, ok := os.LookupEnv("CLIENT_SECRET")
+	if ok && envClientSecret != "" {
+		clientSecret = envClientSecret
+	}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/authorize", authorize)
+	mux.HandleFunc("/token", token)
+	server := http.Server{
+		Addr:              serverAddress,
+		Handler:           mux,
+		ReadHeaderTimeout: time.Duration(5) * time.Second,
+	}
+	log.Printf("Listening on %s\n", serverAddress)
+	_ = server.ListenAndServe()
+}
@@ -0,0 +1,211 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"math"
+	"net/http"
+	"sort"
+	"strings"
+
+	"github.com/mattermost/mattermost-plugin-apps/apps"
+)
+
+var helpBinding = apps.Binding{
+	Location:    "help",
+	Label:       "help",
+	Description: "Show the commands of this app and their arguments",
+	Form: &apps.Form{
+		Fields: []apps.Field{
+			{
+				Name:                 "command",
+				Type:                 apps.FieldTypeText,
+				TextSubtype:          apps.TextFieldSubtypeInput,
+				Description:          "Only show the commands beneath this one, such as weather",
+				AutocompletePosition: 1,
+			},
+		},
+		Submit: apps.NewCall("/help"),
+	},
+}
+
+// commandBindings prepares a tree of command bindings so that their arguments work the same way everywhere, and
+// adds a help sub-command generated from the tree. Every argument gets a flag name and an autocomplete hint, and
+// every binding without a hint gets one that lists its sub-commands, or its positional arguments in order
+// followed by its flags.
+func commandBindings(bindings []apps.Binding) []apps.Binding {
+	withHelp := append(append(make([]apps.Binding, 0, len(bindings)+1), bindings...), helpBinding)
+	return prepareCommandBindings(withHelp)
+}
+
+func prepareCommandBindings(bindings []apps.Binding) []apps.Binding {
+	prepared := make([]apps.Binding, 0, len(bindings))
+	for _, binding := range bindings {
+		if len(binding.Bindings) > 0 {
+			binding.Bindings = prepareCommandBindings(binding.Bindings)
+		}
+		if binding.Form != nil {
+			// forms can be shared with other bindings, so the arguments are prepared on a copy
+			binding.Form = binding.Form.PartialCopy()
+			for i := range binding.Form.Fields {
+				prepareCommandArg(&binding.Form.Fields[i])
+			}
+		}
+		if binding.Hint == "" {
+			binding.Hint = commandHint(binding)
+		}
+		prepared = append(prepared, binding)
+	}
+	return prepared
+}
+
+// prepareCommandArg names the flag of an argument after its field, and hints at its value with the options of a
+// static select or with its name
+func prepareCommandArg(field *apps.Field) {
+	if field.Label == "" {
+		field.Label = field.Name
+	}
+	if field.AutocompleteHint != "" {
+		return
+	}
+	if field.Type == apps.FieldTypeStaticSelect && len(field.SelectStaticOptions) > 0 {
+		values := make([]string, 0, len(field.SelectStaticOptions))
+		for _, option := range field.SelectStaticOptions {
+			values = append(values, option.Value)
+		}
+		field.AutocompleteHint = strings.Join(values, "|")
+		return
+	}
+	field.AutocompleteHint = field.Name
+}
+
+// commandArgs returns the positional arguments of a command in order, and its flags
+func commandArgs(binding apps.Binding) (positional []apps.Field, flags []apps.Field) {
+	if binding.Form == nil {
+		return nil, nil
+	}
+	for _, field := range binding.Form.Fields {
+		if field.Type == apps.FieldTypeMarkdown || field.ReadOnly {
+			continue
+		}
+		if field.AutocompletePosition != 0 {
+			positional = append(positional, field)
+		} else {
+			flags = append(flags, field)
+		}
+	}
+	sort.SliceStable(positional, func(i, j int) bool {
+		return argPosition(positional[i]) < argPosition(positional[j])
+	})
+	return positional, flags
+}
+
+// argPosition returns the position of a positional argument; the last argument has position -1
+func argPosition(field apps.Field) int {
+	if field.AutocompletePosition == -1 {
+		return math.MaxInt32
+	}
+	return field.AutocompletePosition
+}
+
+// commandHint returns the hint of a binding: its sub-commands, or its arguments
+func commandHint(binding apps.Binding) string {
+	if len(binding.Bindings) > 0 {
+		labels := make([]string, 0, len(binding.Bindings))
+		for _, subBinding := range binding.Bindings {
+			labels = append(labels, subBinding.Label)
+		}
+		return fmt.Sprintf("[%s]", strings.Join(labels, "|"))
+	}
+	positional, flags := commandArgs(binding)
+	hints := make([]string, 0, len(positional)+len(flags))
+	for _, field := range positional {
+		hints = append(hints, fmt.Sprintf("[%s]", field.AutocompleteHint))
+	}
+	for _, field := range flags {
+		hints = append(hints, fmt.Sprintf("[--%s]", field.Label))
+	}
+	return strings.Join(hints, " ")
+}
+
+// commandArgList returns a Markdown list of the arguments of a command and what they are for
+func commandArgList(binding apps.Binding, indent string) string {
+	positional, flags := commandArgs(binding)
+	list := ""
+	for _, field := range append(positional, flags...) {
+		name := field.Name
+		if field.AutocompletePosition == 0 {
+			name = "--" + field.Label
+		}
+		description := field.Description
+		if field.IsRequired {
+			description += " (required)"
+		}
+		list += fmt.Sprintf("%s- `%s`: %s\n", indent, name, strings.TrimSpace(description))
+	}
+	return list
+}
+
+// commandList returns a Markdown list of every command binding beneath the supplied bindings, optionally with
+// the arguments of each
+func commandList(bindings []apps.Binding, prefix string, withArgs bool) string {
+	list := ""
+	for _, binding := range bindings {
+		command := fmt.Sprintf("%s %s", prefix, binding.Label)
+		if len(binding.Bindings) > 0 {
+			list += commandList(binding.Bindings, command, withArgs)
+			continue
+		}
+		if binding.Hint != "" {
+			command = fmt.Sprintf("%s %s", command, binding.Hint)
+		}
+		list += fmt.Sprintf("- `%s`: %s\n", command, binding.Description)
+		if withArgs {
+			list += commandArgList(binding, "  ")
+		}
+	}
+	return list
+}
+
+// appCommandBindings returns the sub-commands of the app's slash command
+func appCommandBindings() []apps.Binding {
+	for _, binding := range appBindings {
+		if binding.Location == apps.LocationCommand {
+			return binding.Bindings
+		}
+	}
+	return nil
+}
+
+func commandsHelp(w http.ResponseWriter, r *http.Request) {
+	callRequest, err := getCallRequest(r)
+	if err != nil {
+		log.Printf("commandsHelp(): %s\n", err.Error())
+		sendErrorResponse(w, err)
+		return
+	}
+	trigger := fmt.Sprintf("/%s", appManifest.AppID)
+	prefix := trigger
+	bindings := appCommandBindings()
+	for _, name := range strings.Fields(callRequest.GetValue("command", "")) {
+		var found *apps.Binding
+		for i := range bindings {
+			if bindings[i].Label == name {
+				found = &bindings[i]
+				break
+			}
+		}
+		if found == nil {
+			err = newNotFoundError("there is no command `%s %s`; run `%s help` to list the commands", prefix, name, trigger)
+			sendErrorResponse(w, err)
+			return
+		}
+		if len(found.Bindings) == 0 {
+			bindings = []apps.Binding{*found}
+			break
+		}
+		prefix = fmt.Sprintf("%s %s", prefix, name)
+		bindings = found.Bindings
+	}
+	sendCallResponse(w, apps.NewTextResponse("## Commands\n%s", commandList(bindings, prefix, true)))
+}
@@ -0,0 +1,125 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// cronField is the set of values that one field of a cron expression matches
+type cronField struct {
+	values     map[int]bool
+	restricted bool
+}
+
+// cronSchedule is a parsed five-field cron expression: minute, hour, day of month, month and day of week
+type cronSchedule struct {
+	minute     cronField
+	hour       cronField
+	dayOfMonth cronField
+	month      cronField
+	dayOfWeek  cronField
+}
+
+// parseCronField parses one comma-separated cron field such as "*", "1-5", "*/15" or "0,30"
+func parseCronField(text string, min int, max int) (cronField, error) {
+	field := cronField{
+		values:     make(map[int]bool),
+		restricted: text != "*",
+	}
+	for _, part := range strings.Split(text, ",") {
+		rangeText := part
+		step := 1
+		if strings.Contains(part, "/") {
+			pieces := strings.SplitN(part, "/", 2)
+			rangeText = pieces[0]
+			parsedStep, err := strconv.Atoi(pieces[1])
+			if err != nil || parsedStep < 1 {
+				return field, fmt.Errorf("invalid step in %q", part)
+			}
+			step = parsedStep
+		}
+		start, end := min, max
+		switch {
+		case rangeText == "*":
+		case strings.Contains(rangeText, "-"):
+			pieces := strings.SplitN(rangeText, "-", 2)
+			parsedStart, err := strconv.Atoi(pieces[0])
+			if err != nil {
+				return field, fmt.Errorf("invalid range in %q", part)
+			}
+			parsedEnd, err := strconv.Atoi(pieces[1])
+			if err != nil {
+				return field, fmt.Errorf("invalid range in %q", part)
+			}
+			start, end = parsedStart, parsedEnd
+		default:
+			value, err := strconv.Atoi(rangeText)
+			if err != nil {
+				return field, fmt.Errorf("invalid value %q", part)
+			}
+			start = value
+			end = value
+			if step > 1 {
+				end = max
+			}
+		}
+		if start < min || end > max || start > end {
+			return field, fmt.Errorf("%q is outside the range %d-%d", part, min, max)
+		}
+		for value := start; value <= end; value += step {
+			field.values[value] = true
+		}
+	}
+	return field, nil
+}
+
+// parseCronSchedule parses a standard five-field cron expression
+func parseCronSchedule(expression string) (*cronSchedule, error) {
+	fields := strings.Fields(expression)
+	if len(fields) != 5 {
+		return nil, fmt.Errorf("expected 5 fields in cron expression %q but found %d", expression, len(fields))
+	}
+	var err error
+	schedule := new(cronSchedule)
+	schedule.minute, err = parseCronField(fields[0], 0, 59)
+	if err != nil {
+		return nil, fmt.Errorf("minute: %w", err)
+	}
+	schedule.hour, err = parseCronField(fields[1], 0, 23)
+	if err != nil {
+		return nil, fmt.Errorf("hour: %w", err)
+	}
+	schedule.dayOfMonth, err = parseCronField(fields[2], 1, 31)
+	if err != nil {
+		return nil, fmt.Errorf("day of month: %w", err)
+	}
+	schedule.month, err = parseCronField(fields[3], 1, 12)
+	if err != nil {
+		return nil, fmt.Errorf("month: %w", err)
+	}
+	// both 0 and 7 mean Sunday
+	schedule.dayOfWeek, err = parseCronField(fields[4], 0, 7)
+	if err != nil {
+		return nil, fmt.Errorf("day of week: %w", err)
+	}
+	if schedule.dayOfWeek.values[7] {
+		schedule.dayOfWeek.values[0] = true
+	}
+	return schedule, nil
+}
+
+// matches reports whether the schedule fires during the minute containing t
+func (s *cronSchedule) matches(t time.Time) bool {
+	if !s.minute.values[t.Minute()] || !s.hour.values[t.Hour()] || !s.month.values[int(t.Month())] {
+		return false
+	}
+	dayOfMonth := s.dayOfMonth.values[t.Day()]
+	dayOfWeek := s.dayOfWeek.values[int(t.Weekday())]
+	// as in cron, when both day fields are restricted either one may match
+	if s.dayOfMonth.restricted && s.dayOfWeek.restricted {
+		return dayOfMonth || dayOfWeek
+	}
+	return dayOfMonth && dayOfWeek
+}
@@ -0,0 +1,208 @@
+package main
+
+import (
+	"errors"
+	"reflect"
+	"sort"
+	"testing"
+	"time"
+
+	"github.com/mattermost/mattermost-plugin-apps/apps"
+)
+
+func TestParseCronField(t *testing.T) {
+	tests := []struct {
+		text string
+		min  int
+		max  int
+		// want is the sorted values of the field, or nil if the text is invalid
+		want []int
+	}{
+		{"*", 0, 6, []int{0, 1, 2, 3, 4, 5, 6}},
+		{"3", 0, 6, []int{3}},
+		{"1-4", 0, 6, []int{1, 2, 3, 4}},
+		{"0,3,5", 0, 6, []int{0, 3, 5}},
+		{"*/15", 0, 59, []int{0, 15, 30, 45}},
+		{"10-30/10", 0, 59, []int{10, 20, 30}},
+		{"5/20", 0, 59, []int{5, 25, 45}},
+		{"1-2,4-5", 0, 6, []int{1, 2, 4, 5}},
+		{"1,1", 0, 6, []int{1}},
+		{"", 0, 6, nil},
+		{"x", 0, 6, nil},
+		{"7", 0, 6, nil},
+		{"0", 1, 12, nil},
+		{"4-2", 0, 6, nil},
+		{"1-", 0, 6, nil},
+		{"-1", 0, 6, nil},
+		{"*/0", 0, 59, nil},
+		{"*/x", 0, 59, nil},
+		{"1,,2", 0, 6, nil},
+	}
+	for _, test := range tests {
+		field, err := parseCronField(test.text, test.min, test.max)
+		if test.want == nil {
+			if err == nil {
+				t.Errorf("parseCronField(%q, %d, %d) returned no error", test.text, test.min, test.max)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("parseCronField(%q, %d, %d) returned error %s", test.text, test.min, test.max, err.Error())
+			continue
+		}
+		values := make([]int, 0, len(field.values))
+		for value := range field.values {
+			values = append(values, value)
+		}
+		sort.Ints(values)
+		if !reflect.DeepEqual(values, test.want) {
+			t.Errorf("parseCronField(%q, %d, %d) matches %v, want %v", test.text, test.min, test.max, values, test.want)
+		}
+		if field.restricted != (test.text != "*") {
+			t.Errorf("parseCronField(%q, %d, %d) has restricted %t", test.text, test.min, test.max, field.restricted)
+		}
+	}
+}
+
+func TestParseCronScheduleRejectsInvalidExpressions(t *testing.T) {
+	for _, expression := range []string{
+		"",
+		"* * * *",
+		"* * * * * *",
+		"60 * * * *",
+		"* 24 * * *",
+		"* * 0 * *",
+		"* * 32 * *",
+		"* * * 13 *",
+		"* * * * 8",
+		"* * * * mon",
+	} {
+		if _, err := parseCronSchedule(expression); err == nil {
+			t.Errorf("parseCronSchedule(%q) returned no error", expression)
+		}
+	}
+}
+
+func TestCronScheduleMatches(t *testing.T) {
+	// 1 March 2022 is a Tuesday and 6 March 2022 a Sunday
+	tuesday := time.Date(2022, time.March, 1, 8, 30, 0, 0, time.UTC)
+	sunday := time.Date(2022, time.March, 6, 8, 30, 0, 0, time.UTC)
+	tests := []struct {
+		expression string
+		time       time.Time
+		want       bool
+	}{
+		{"30 8 * * *", tuesday, true},
+		{"30 8 * * *", tuesday.Add(time.Minute), false},
+		{"30 8 * * *", tuesday.Add(time.Hour), false},
+		{"*/15 8-9 * * *", tuesday, true},
+		{"*/20 8-9 * * *", tuesday, false},
+		{"30 8 * 3 *", tuesday, true},
+		{"30 8 * 1,2 *", tuesday, false},
+		{"30 8 * * 1-5", tuesday, true},
+		{"30 8 * * 1-5", sunday, false},
+		{"30 8 * * 0", sunday, true},
+		{"30 8 * * 7", sunday, true},
+		{"30 8 * * 6,7", sunday, true},
+		{"30 8 1 * *", tuesday, true},
+		{"30 8 1 * *", sunday, false},
+		// when both day fields are restricted either one may match
+		{"30 8 1 * 0", tuesday, true},
+		{"30 8 1 * 0", sunday, true},
+		{"30 8 15 * 3", tuesday, false},
+		// a day field of * does not widen the other
+		{"30 8 15 * *", tuesday, false},
+		{"30 8 * * 3", tuesday, false},
+	}
+	for _, test := range tests {
+		schedule, err := parseCronSchedule(test.expression)
+		if err != nil {
+			t.Errorf("parseCronSchedule(%q) returned error %s", test.expression, err.Error())
+			continue
+		}
+		if got := schedule.matches(test.time); got != test.want {
+			t.Errorf("%q matches %s: got %t, want %t", test.expression, test.time.Format(time.RFC1123), got, test.want)
+		}
+	}
+}
+
+func TestSchedulerKeepsTheFirstServersCredentials(t *testing.T) {
+	s := newScheduler()
+	// the scheduler has loaded its jobs, so that setContext starts no load
+	s.loaded = true
+	first := apps.Context{ExpandedContext: apps.ExpandedContext{
+		MattermostSiteURL: "https://first.example.com",
+		BotUserID:         "bot1",
+		BotAccessToken:    "token1",
+	}}
+	s.setContext(first)
+	other := first
+	other.MattermostSiteURL = "https://other.example.com"
+	other.BotAccessToken = "token2"
+	s.setContext(other)
+	if s.siteURL != first.MattermostSiteURL || s.botAccessToken != "token1" {
+		t.Errorf("the scheduler took the credentials of another server: %s %s", s.siteURL, s.botAccessToken)
+	}
+	refreshed := first
+	refreshed.BotAccessToken = "token3"
+	s.setContext(refreshed)
+	if s.botAccessToken != "token3" {
+		t.Errorf("the scheduler did not refresh the credentials of its server")
+	}
+}
+
+func TestSetChannelScheduleOption(t *testing.T) {
+	fake := newFakeMattermostClient(testBotUserID)
+	first := scheduledJob{ID: "first", ChannelID: "channel", Location: "Toronto"}
+	second := scheduledJob{ID: "second", ChannelID: "channel", Location: "Paris"}
+	for _, job := range []scheduledJob{first, second, first} {
+		err := setChannelScheduleOption(fake, job, true)
+		if err != nil {
+			t.Fatalf("error adding %s: %s", job.ID, err.Error())
+		}
+	}
+	err := setChannelScheduleOption(fake, second, false)
+	if err != nil {
+		t.Fatalf("error removing %s: %s", second.ID, err.Error())
+	}
+	options := make([]apps.SelectOption, 0)
+	err = fake.KVGet(scheduleOptionsKVPrefix, "channel", &options)
+	if err != nil || !reflect.DeepEqual(options, []apps.SelectOption{{Label: "Toronto", Value: "first"}}) {
+		t.Errorf("got options %v and error %v, want only Toronto", options, err)
+	}
+}
+
+func TestSchedulerRemind(t *testing.T) {
+	fake := newFakeMattermostClient(testBotUserID)
+	s := newScheduler()
+	job := scheduledJob{ID: "reminder", UserID: "user", Message: "remember the milk"}
+	err := addToKVIndex(fake, reminderKVPrefix, job.ID)
+	if err == nil {
+		_, err = fake.KVSet(reminderKVPrefix, job.ID, job)
+	}
+	if err != nil {
+		t.Fatalf("error storing the reminder: %s", err.Error())
+	}
+
+	fake.Errors["DM"] = errors.New("unavailable")
+	s.remind(fake, job)
+	if requeued, ok := s.jobs[job.ID]; !ok || requeued.Attempts != 1 {
+		t.Errorf("got jobs %v, want the reminder re-queued after one attempt", s.jobs)
+	}
+	if _, ok := fake.KV[reminderKVPrefix][job.ID]; !ok {
+		t.Errorf("a failed reminder was deleted")
+	}
+
+	delete(fake.Errors, "DM")
+	s.remind(fake, s.jobs[job.ID])
+	if len(fake.Posts) != 1 || fake.Posts[0].Message != job.Message {
+		t.Errorf("got posts %v, want the reminder", fake.Posts)
+	}
+	if _, ok := fake.KV[reminderKVPrefix][job.ID]; ok {
+		t.Errorf("a sent reminder was kept")
+	}
+	index, _ := getKVIndex(fake, reminderKVPrefix)
+	if len(index) != 0 {
+		t.Errorf("got reminder index %v, want it empty", index)
+	}
+}
@@ -0,0 +1,208 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/mattermost/mattermost-plugin-apps/apps"
+)
+
+// valuesTag is the struct tag that names the form value decoded into a field
+const valuesTag = "value"
+
+var selectOptionType = reflect.TypeOf(apps.SelectOption{})
+
+// valuesDecodeError maps a field name to the reason its value could not be decoded
+type valuesDecodeError map[string]string
+
+func (e valuesDecodeError) Error() string {
+	names := make([]string, 0, len(e))
+	for name := range e {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	messages := make([]string, 0, len(names))
+	for _, name := range names {
+		messages = append(messages, fmt.Sprintf("%s: %s", name, e[name]))
+	}
+	return fmt.Sprintf("invalid values: %s", strings.Join(messages, "; "))
+}
+
+// decodeValues maps submitted form values into the struct pointed to by target. Each exported field tagged
+// with `value:"name"` receives the value of that name. Supported field types are string, bool, the integer
+// and float kinds, apps.SelectOption, slices of those for multiselects, and pointers to any of them to tell
+// a missing value apart from an empty one. Text values become select options with the same label and value;
+// select, user and channel values decode into a string as their value. Any failure is reported as a
+// valuesDecodeError.
+func decodeValues(values map[string]interface{}, target interface{}) error {
+	targetValue := reflect.ValueOf(target)
+	if targetValue.Kind() != reflect.Ptr || targetValue.Elem().Kind() != reflect.Struct {
+		return errors.New("decodeValues(): target must be a pointer to a struct")
+	}
+	structValue := targetValue.Elem()
+	structType := structValue.Type()
+	decodeErrors := make(valuesDecodeError)
+	for i := 0; i < structType.NumField(); i++ {
+		field := structType.Field(i)
+		name, ok := field.Tag.Lookup(valuesTag)
+		if !ok || name == "" || field.PkgPath != "" {
+			continue
+		}
+		value, ok := values[name]
+		if !ok || value == nil {
+			continue
+		}
+		err := decodeValue(value, structValue.Field(i))
+		if err != nil {
+			decodeErrors[name] = err.Error()
+		}
+	}
+	if len(decodeErrors) > 0 {
+		return decodeErrors
+	}
+	return nil
+}
+
+func decodeValue(value interface{}, target reflect.Value) error {
+	if target.Kind() == reflect.Ptr {
+		decoded := reflect.New(target.Type().Elem())
+		err := decodeValue(value, decoded.Elem())
+		if err != nil {
+			return err
+		}
+		target.Set(decoded)
+		return nil
+	}
+	if target.Type() == selectOptionType {
+		option, err := decodeSelectOption(value)
+		if err != nil {
+			return err
+		}
+		target.Set(reflect.ValueOf(option))
+		return nil
+	}
+	if target.Kind() == reflect.Slice {
+		items, ok := value.([]interface{})
+		if !ok {
+			// a single selection decodes into a one item slice
+			items = []interface{}{value}
+		}
+		decoded := reflect.MakeSlice(target.Type(), len(items), len(items))
+		for i, item := range items {
+			err := decodeValue(item, decoded.Index(i))
+			if err != nil {
+				return err
+			}
+		}
+		target.Set(decoded)
+		return nil
+	}
+	// select, user and channel pickers submit an option; scalar targets receive its value
+	if option, ok := value.(map[string]interface{}); ok {
+		optionValue, ok := option["value"]
+		if !ok {
+			return errors.New("expected a selected option")
+		}
+		switch optionValue.(type) {
+		case map[string]interface{}, []interface{}:
+			return errors.New("expected a selected option")
+		}
+		value = optionValue
+	}
+	switch target.Kind() {
+	case reflect.String:
+		switch typedValue := value.(type) {
+		case string:
+			target.SetString(typedValue)
+		case float64:
+			target.SetString(strconv.FormatFloat(typedValue, 'f', -1, 64))
+		case bool:
+			target.SetString(strconv.FormatBool(typedValue))
+		default:
+			return fmt.Errorf("expected text but got %T", value)
+		}
+	case reflect.Bool:
+		switch typedValue := value.(type) {
+		case bool:
+			target.SetBool(typedValue)
+		case string:
+			parsed, err := strconv.ParseBool(typedValue)
+			if err != nil {
+				return fmt.Errorf("expected true or false but got %q", typedValue)
+			}
+			target.SetBool(parsed)
+		default:
+			return fmt.Errorf("expected true or false but got %T", value)
+		}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		number, err := decodeNumber(value)
+		if err != nil {
+			return err
+		}
+		if number != float64(int64(number)) || target.OverflowInt(int64(number)) {
+			return fmt.Errorf("expected a whole number but got %v", number)
+		}
+		target.SetInt(int64(number))
+	case reflect.Float32, reflect.Float64:
+		number, err := decodeNumber(value)
+		if err != nil {
+			return err
+		}
+		target.SetFloat(number)
+	default:
+		return fmt.Errorf("unsupported field type %s", target.Type())
+	}
+	return nil
+}
+
+func decodeNumber(value interface{}) (float64, error) {
+	switch typedValue := value.(type) {
+	case float64:
+		return typedValue, nil
+	case string:
+		number, err := strconv.ParseFloat(strings.TrimSpace(typedValue), 64)
+		if err != nil {
+			return 0, fmt.Errorf("expected a number but got %q", typedValue)
+		}
+		return number, nil
+	default:
+		return 0, fmt.Errorf("expected a number but got %T", value)
+	}
+}
+
+func decodeSelectOption(value interface{}) (apps.SelectOption, error) {
+	switch typedValue := value.(type) {
+	case string:
+		return apps.SelectOption{
+			Label: typedValue,
+			Value: typedValue,
+		}, nil
+	case map[string]interface{}:
+		option := apps.SelectOption{}
+		optionValue, ok := typedValue["value"].(string)
+		if !ok {
+			return option, errors.New("expected a selected option")
+		}
+		option.Value = optionValue
+		option.Label, _ = typedValue["label"].(string)
+		option.IconData, _ = typedValue["icon_data"].(string)
+		return option, nil
+	default:
+		return apps.SelectOption{}, fmt.Errorf("expected a selected option but got %T", value)
+	}
+}
+
+// sendDecodeErrorResponse reports a decodeValues failure as field-level errors where possible
+func sendDecodeErrorResponse(w http.ResponseWriter, err error) {
+	var decodeErrors valuesDecodeError
+	if errors.As(err, &decodeErrors) {
+		sendCallResponse(w, newFieldErrorsResponse(decodeErrors))
+		return
+	}
+	sendErrorResponse(w, err)
+}
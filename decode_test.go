@@ -0,0 +1,145 @@
+package main
+
+import (
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/mattermost/mattermost-plugin-apps/apps"
+)
+
+type decodeTarget struct {
+	Text     string              `value:"text"`
+	Flag     bool                `value:"flag"`
+	Count    int                 `value:"count"`
+	Small    int8                `value:"small"`
+	Ratio    float64             `value:"ratio"`
+	Option   apps.SelectOption   `value:"option"`
+	Options  []apps.SelectOption `value:"options"`
+	IDs      []string            `value:"ids"`
+	Optional *string             `value:"optional"`
+	Skipped  string
+	hidden   string `value:"hidden"`
+}
+
+func TestDecodeValues(t *testing.T) {
+	optional := ""
+	tests := []struct {
+		name   string
+		values map[string]interface{}
+		want   decodeTarget
+		// wantErrors maps a field name to a part of its error, or is nil if the values decode
+		wantErrors map[string]string
+	}{
+		{
+			name:   "missing values",
+			values: map[string]interface{}{},
+		},
+		{
+			name:   "null values",
+			values: map[string]interface{}{"text": nil, "optional": nil, "count": nil},
+		},
+		{
+			name:   "text",
+			values: map[string]interface{}{"text": "hello", "optional": "", "Skipped": "x", "hidden": "x"},
+			want:   decodeTarget{Text: "hello", Optional: &optional},
+		},
+		{
+			name:   "numbers and bools as text",
+			values: map[string]interface{}{"text": float64(3), "flag": "true", "count": " 12 ", "ratio": "0.5"},
+			want:   decodeTarget{Text: "3", Flag: true, Count: 12, Ratio: 0.5},
+		},
+		{
+			name:   "numbers and bools",
+			values: map[string]interface{}{"flag": true, "count": float64(-4), "small": float64(127), "ratio": 1.25},
+			want:   decodeTarget{Flag: true, Count: -4, Small: 127, Ratio: 1.25},
+		},
+		{
+			name: "select options",
+			values: map[string]interface{}{
+				"text":   map[string]interface{}{"label": "User", "value": "user-id"},
+				"option": map[string]interface{}{"label": "Button", "value": "button", "icon_data": "icon"},
+				"options": []interface{}{
+					map[string]interface{}{"label": "One", "value": "1"},
+					"two",
+				},
+				"ids": map[string]interface{}{"label": "Channel", "value": "channel-id"},
+			},
+			want: decodeTarget{
+				Text:   "user-id",
+				Option: apps.SelectOption{Label: "Button", Value: "button", IconData: "icon"},
+				Options: []apps.SelectOption{
+					{Label: "One", Value: "1"},
+					{Label: "two", Value: "two"},
+				},
+				IDs: []string{"channel-id"},
+			},
+		},
+		{
+			name: "mistyped values",
+			values: map[string]interface{}{
+				"text":     []interface{}{"a"},
+				"flag":     "maybe",
+				"count":    1.5,
+				"small":    float64(128),
+				"ratio":    "lots",
+				"option":   true,
+				"options":  []interface{}{map[string]interface{}{"label": "One"}},
+				"ids":      []interface{}{false, map[string]interface{}{}},
+				"optional": map[string]interface{}{"value": map[string]interface{}{"value": "x"}},
+			},
+			wantErrors: map[string]string{
+				"text":     "expected text but got []interface {}",
+				"flag":     `expected true or false but got "maybe"`,
+				"count":    "expected a whole number but got 1.5",
+				"small":    "expected a whole number but got 128",
+				"ratio":    `expected a number but got "lots"`,
+				"option":   "expected a selected option but got bool",
+				"options":  "expected a selected option",
+				"ids":      "expected a selected option",
+				"optional": "expected a selected option",
+			},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			target := decodeTarget{}
+			err := decodeValues(test.values, &target)
+			if test.wantErrors == nil {
+				if err != nil {
+					t.Fatalf("unexpected error %s", err.Error())
+				}
+				if !reflect.DeepEqual(target, test.want) {
+					t.Errorf("decoded %+v, want %+v", target, test.want)
+				}
+				return
+			}
+			var decodeErrors valuesDecodeError
+			if !errors.As(err, &decodeErrors) {
+				t.Fatalf("got error %v, want a valuesDecodeError", err)
+			}
+			if len(decodeErrors) != len(test.wantErrors) {
+				t.Errorf("got errors %v, want %v", decodeErrors, test.wantErrors)
+			}
+			for name, want := range test.wantErrors {
+				if !strings.Contains(decodeErrors[name], want) {
+					t.Errorf("%s: got error %q, want %q", name, decodeErrors[name], want)
+				}
+			}
+		})
+	}
+}
+
+func TestDecodeValuesRejectsInvalidTargets(t *testing.T) {
+	unsupported := struct {
+		Values map[string]string `value:"values"`
+	}{}
+	err := decodeValues(map[string]interface{}{"values": "x"}, &unsupported)
+	if err == nil || !strings.Contains(err.Error(), "unsupported field type") {
+		t.Errorf("got error %v for an unsupported field type", err)
+	}
+	if err := decodeValues(nil, decodeTarget{}); err == nil {
+		t.Errorf("decoding into a struct value returned no error")
+	}
+}
@@ -0,0 +1,95 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+)
+
+// errorKind classifies an error by who can fix it, which decides the text shown to the user
+type errorKind int
+
+const (
+	// errorKindInternal is a failure of the app itself; the details are only logged
+	errorKindInternal errorKind = iota
+	// errorKindUserInput is a problem with what the user entered; the message is shown as is
+	errorKindUserInput
+	// errorKindForbidden is a request the user is not allowed to make; the message is shown as is
+	errorKindForbidden
+	// errorKindNotFound is a request for something that does not exist; the message is shown as is
+	errorKindNotFound
+	// errorKindUpstream is a failed request to Mattermost or another service; the details are only logged
+	errorKindUpstream
+)
+
+// errorKindNames name the kinds in the log
+var errorKindNames = map[errorKind]string{
+	errorKindInternal:  "internal",
+	errorKindUserInput: "user input",
+	errorKindForbidden: "forbidden",
+	errorKindNotFound:  "not found",
+	errorKindUpstream:  "upstream",
+}
+
+// appError is an error with a kind and a message that is safe to show to the user
+type appError struct {
+	kind    errorKind
+	message string
+	cause   error
+}
+
+func (e *appError) Error() string {
+	if e.cause == nil {
+		return e.message
+	}
+	return fmt.Sprintf("%s: %s", e.message, e.cause.Error())
+}
+
+func (e *appError) Unwrap() error {
+	return e.cause
+}
+
+// newUserInputError returns an error for input that the user can correct
+func newUserInputError(format string, args ...interface{}) error {
+	return &appError{kind: errorKindUserInput, message: fmt.Sprintf(format, args...)}
+}
+
+// newForbiddenError returns an error for a request the user is not allowed to make
+func newForbiddenError(format string, args ...interface{}) error {
+	return &appError{kind: errorKindForbidden, message: fmt.Sprintf(format, args...)}
+}
+
+// newNotFoundError returns an error for a request for something that does not exist
+func newNotFoundError(format string, args ...interface{}) error {
+	return &appError{kind: errorKindNotFound, message: fmt.Sprintf(format, args...)}
+}
+
+// newUpstreamError wraps the failure of an outbound request; the message says what was being done and is shown
+// to the user without the cause
+func newUpstreamError(cause error, format string, args ...interface{}) error {
+	return &appError{kind: errorKindUpstream, message: fmt.Sprintf(format, args...), cause: cause}
+}
+
+// newInternalError wraps a failure of the app itself; only the message is shown to the user
+func newInternalError(cause error, format string, args ...interface{}) error {
+	return &appError{kind: errorKindInternal, message: fmt.Sprintf(format, args...), cause: cause}
+}
+
+// classifyError returns the kind of an error and the text to show the user, which leaves out the details of
+// internal and upstream errors. An error without a kind is treated as internal.
+func classifyError(err error) (errorKind, string) {
+	switch {
+	case errors.Is(err, errRequestTooLarge):
+		return errorKindUserInput, "the request is too large to be processed"
+	case errors.Is(err, context.DeadlineExceeded):
+		return errorKindUpstream, "the request took too long to complete; please try again later"
+	}
+	var typedErr *appError
+	if !errors.As(err, &typedErr) {
+		return errorKindInternal, "an internal error occurred"
+	}
+	if typedErr.kind == errorKindUpstream {
+		return typedErr.kind, fmt.Sprintf("%s: the request to the server failed", typedErr.message)
+	}
+	return typedErr.kind, typedErr.message
+}
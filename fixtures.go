@@ -0,0 +1,230 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/mattermost/mattermost-plugin-apps/apps"
+	"github.com/mattermost/mattermost-server/v6/model"
+)
+
+const (
+	// fixtureRedacted replaces credentials and personal data in recorded fixtures
+	fixtureRedacted = "redacted"
+	// fixtureSiteURL replaces the Mattermost site URL in recorded fixtures
+	fixtureSiteURL = "https://mattermost.example.com"
+)
+
+var (
+	// fixtureDir is the directory that recordMiddleware writes call fixtures to; recording is off when it is empty
+	fixtureDir string
+
+	// fixtureRedactedValues are the call values that carry secrets or free text, which is personal data
+	fixtureRedactedValues = []string{"client_secret", "code", "state", "message", "comment", "template"}
+
+	// fixtureRedactedParams are the URL query parameters that carry secrets
+	fixtureRedactedParams = []string{"secret", "state", "code"}
+
+	// fixtureParamPattern matches the value of a URL query parameter named in fixtureRedactedParams
+	fixtureParamPattern = regexp.MustCompile(`([?&](?:` + strings.Join(fixtureRedactedParams, "|") + `)=)[^&#\s)"]+`)
+
+	// fixtureKeptHeaders are the webhook request headers kept in fixtures; the values of any others, which may
+	// carry signatures or tokens, are redacted
+	fixtureKeptHeaders = []string{"Accept", "Accept-Encoding", "Content-Length", "Content-Type", "User-Agent"}
+
+	fixtureNamePattern = regexp.MustCompile(`[^a-z0-9]+`)
+)
+
+// callFixture is a recorded call: the sanitized request and the response that the app sent
+type callFixture struct {
+	Request    apps.CallRequest `json:"request"`
+	StatusCode int              `json:"status_code"`
+	Response   json.RawMessage  `json:"response"`
+}
+
+// setupRecording turns on fixture recording when RECORD_FIXTURES names a directory
+func setupRecording() error {
+	dir := os.Getenv("RECORD_FIXTURES")
+	if dir == "" {
+		return nil
+	}
+	err := os.MkdirAll(dir, 0o755)
+	if err != nil {
+		return fmt.Errorf("error creating fixture directory: %w", err)
+	}
+	fixtureDir = dir
+	log.Printf("setupRecording(): recording calls to %s\n", dir)
+	return nil
+}
+
+// bodyRecorder keeps a copy of the response body
+type bodyRecorder struct {
+	*statusRecorder
+	body bytes.Buffer
+}
+
+func (br *bodyRecorder) Write(data []byte) (int, error) {
+	br.body.Write(data)
+	return br.statusRecorder.Write(data)
+}
+
+// recordMiddleware writes every call and its response to the fixture directory, if recording is on
+func recordMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if fixtureDir == "" || r.Method != http.MethodPost {
+			next.ServeHTTP(w, r)
+			return
+		}
+		requestBody, err := io.ReadAll(r.Body)
+		if err != nil {
+			sendErrorResponse(w, err)
+			return
+		}
+		r.Body = io.NopCloser(bytes.NewReader(requestBody))
+		recorder := &bodyRecorder{statusRecorder: &statusRecorder{ResponseWriter: w}}
+		next.ServeHTTP(recorder, r)
+		err = writeCallFixture(r, requestBody, recorder.statusCode, recorder.body.Bytes())
+		if err != nil {
+			log.Printf("recordMiddleware(): trace %s: error recording %s: %s\n", traceID(r.Context()), r.URL.Path, err.Error())
+		}
+	})
+}
+
+// writeCallFixture sanitizes the call and writes it with its response to a file named after the call path
+func writeCallFixture(r *http.Request, requestBody []byte, statusCode int, responseBody []byte) error {
+	fixture := callFixture{
+		StatusCode: statusCode,
+	}
+	err := json.Unmarshal(requestBody, &fixture.Request)
+	if err != nil {
+		return fmt.Errorf("the call request is not valid JSON: %w", err)
+	}
+	fixture.Response, err = sanitizeCallResponse(responseBody, fixture.Request.Context.MattermostSiteURL)
+	if err != nil {
+		return err
+	}
+	sanitizeCallRequest(&fixture.Request)
+	encodedFixture, err := json.MarshalIndent(fixture, "", "  ")
+	if err != nil {
+		return err
+	}
+	name := fmt.Sprintf(
+		"%s-%s-%s.json",
+		strings.Trim(fixtureNamePattern.ReplaceAllString(strings.ToLower(r.URL.Path), "-"), "-"),
+		time.Now().UTC().Format("20060102T150405"),
+		traceID(r.Context()),
+	)
+	return os.WriteFile(filepath.Join(fixtureDir, name), append(encodedFixture, '\n'), 0o644)
+}
+
+// sanitizeCallRequest removes the credentials and personal data from a call so that it can be committed
+func sanitizeCallRequest(callRequest *apps.CallRequest) {
+	appContext := &callRequest.Context
+	if appContext.MattermostSiteURL != "" {
+		appContext.MattermostSiteURL = fixtureSiteURL
+	}
+	if appContext.BotAccessToken != "" {
+		appContext.BotAccessToken = fixtureRedacted
+	}
+	if appContext.ActingUserAccessToken != "" {
+		appContext.ActingUserAccessToken = fixtureRedacted
+	}
+	if appContext.ActingUser != nil {
+		appContext.ActingUser = sanitizeUser(appContext.ActingUser)
+	}
+	if appContext.User != nil {
+		appContext.User = sanitizeUser(appContext.User)
+	}
+	if appContext.OAuth2.ClientSecret != "" {
+		appContext.OAuth2.ClientSecret = fixtureRedacted
+	}
+	if appContext.OAuth2.User != nil {
+		appContext.OAuth2.User = fixtureRedacted
+	}
+	// the text of posts is personal data; a replay acts on the same posts with redacted messages
+	if appContext.Post != nil && appContext.Post.Message != "" {
+		appContext.Post.Message = fixtureRedacted
+	}
+	if appContext.RootPost != nil && appContext.RootPost.Message != "" {
+		appContext.RootPost.Message = fixtureRedacted
+	}
+	for _, name := range fixtureRedactedValues {
+		if _, ok := callRequest.Values[name]; ok {
+			callRequest.Values[name] = fixtureRedacted
+		}
+	}
+	if headers, ok := callRequest.Values["headers"].(map[string]interface{}); ok {
+		for name := range headers {
+			if !containsString(fixtureKeptHeaders, http.CanonicalHeaderKey(name)) {
+				headers[name] = fixtureRedacted
+			}
+		}
+	}
+	// a webhook payload may hold anything, so it is replaced by an empty one
+	if _, ok := callRequest.Values["data"]; ok {
+		callRequest.Values["data"] = map[string]interface{}{}
+	}
+	if rawQuery, ok := callRequest.Values["rawQuery"].(string); ok {
+		query, err := url.ParseQuery(rawQuery)
+		if err == nil {
+			for _, name := range fixtureRedactedParams {
+				if query.Get(name) != "" {
+					query.Set(name, fixtureRedacted)
+				}
+			}
+			callRequest.Values["rawQuery"] = query.Encode()
+		}
+	}
+}
+
+// sanitizeCallResponse replaces the site URL in a response and redacts the secrets in the URLs it holds, such
+// as the secret of a webhook URL and the state of an OAuth2 connect URL
+func sanitizeCallResponse(responseBody []byte, siteURL string) (json.RawMessage, error) {
+	var response interface{}
+	err := json.Unmarshal(responseBody, &response)
+	if err != nil {
+		return nil, fmt.Errorf("the response is not valid JSON: %w", err)
+	}
+	return json.Marshal(sanitizeResponseValue(response, siteURL))
+}
+
+// sanitizeResponseValue sanitizes every string in a decoded JSON value
+func sanitizeResponseValue(value interface{}, siteURL string) interface{} {
+	switch typed := value.(type) {
+	case string:
+		if siteURL != "" {
+			typed = strings.ReplaceAll(typed, siteURL, fixtureSiteURL)
+		}
+		return fixtureParamPattern.ReplaceAllString(typed, "${1}"+fixtureRedacted)
+	case []interface{}:
+		for i, item := range typed {
+			typed[i] = sanitizeResponseValue(item, siteURL)
+		}
+	case map[string]interface{}:
+		for key, item := range typed {
+			typed[key] = sanitizeResponseValue(item, siteURL)
+		}
+	}
+	return value
+}
+
+// sanitizeUser keeps only the fields of a user that handlers act on
+func sanitizeUser(user *model.User) *model.User {
+	return &model.User{
+		Id:       user.Id,
+		Username: user.Username,
+		Roles:    user.Roles,
+		Locale:   user.Locale,
+		Timezone: user.Timezone,
+	}
+}
@@ -0,0 +1,111 @@
+package main
+
+import (
+	"github.com/mattermost/mattermost-plugin-apps/apps"
+)
+
+// fieldCondition reports whether a dependency applies to the current form values
+type fieldCondition func(values map[string]interface{}) bool
+
+// fieldDependency changes one field of a refreshable form based on the values of other fields
+type fieldDependency struct {
+	// Field is the name of the field that the dependency changes
+	Field string
+	// DependsOn names the fields whose changes re-evaluate the form; they are marked SelectRefresh
+	DependsOn []string
+	// When limits the dependency to the values it matches; a nil When always applies
+	When fieldCondition
+	// Hide removes the field from the form
+	Hide bool
+	// Disable makes the field read-only
+	Disable bool
+	// Options, if set, replaces the field's static select options
+	Options func(values map[string]interface{}) []apps.SelectOption
+	// Prefill, if set, supplies the field's value when the user has not entered one
+	Prefill func(values map[string]interface{}) interface{}
+}
+
+// refreshableForm is a form whose fields are recomputed from the submitted values on every source call
+type refreshableForm struct {
+	Form         apps.Form
+	Dependencies []fieldDependency
+}
+
+// valueSet returns a condition that matches when the named field has a value
+func valueSet(name string) fieldCondition {
+	return func(values map[string]interface{}) bool {
+		return len(submittedValues(values[name])) > 0
+	}
+}
+
+// valueNotSet returns a condition that matches when the named field has no value
+func valueNotSet(name string) fieldCondition {
+	return func(values map[string]interface{}) bool {
+		return len(submittedValues(values[name])) == 0
+	}
+}
+
+// evaluate returns a copy of the form with the submitted values carried over and every applicable dependency
+// applied. A nil map evaluates the form as it is first shown.
+func (rf *refreshableForm) evaluate(values map[string]interface{}) *apps.Form {
+	if values == nil {
+		values = make(map[string]interface{})
+	}
+	refreshed := make(map[string]bool)
+	for _, dependency := range rf.Dependencies {
+		for _, name := range dependency.DependsOn {
+			refreshed[name] = true
+		}
+	}
+	formClone := rf.Form.PartialCopy()
+	fields := make([]apps.Field, 0, len(formClone.Fields))
+	for _, field := range formClone.Fields {
+		if value, ok := values[field.Name]; ok && value != nil {
+			field.Value = value
+		}
+		if refreshed[field.Name] {
+			field.SelectRefresh = true
+		}
+		hidden := false
+		for _, dependency := range rf.Dependencies {
+			if dependency.Field != field.Name || (dependency.When != nil && !dependency.When(values)) {
+				continue
+			}
+			hidden = hidden || dependency.Hide
+			if dependency.Disable {
+				field.ReadOnly = true
+			}
+			if dependency.Options != nil {
+				field.SelectStaticOptions = dependency.Options(values)
+				// a value that is no longer offered is cleared
+				if !optionsContain(field.SelectStaticOptions, submittedValues(field.Value)) {
+					field.Value = nil
+				}
+			}
+			if dependency.Prefill != nil && len(submittedValues(field.Value)) == 0 {
+				field.Value = dependency.Prefill(values)
+			}
+		}
+		if !hidden {
+			fields = append(fields, field)
+		}
+	}
+	formClone.Fields = fields
+	return formClone
+}
+
+func optionsContain(options []apps.SelectOption, values []string) bool {
+	for _, value := range values {
+		found := false
+		for _, option := range options {
+			if option.Value == value {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,146 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/mattermost/mattermost-plugin-apps/apps"
+)
+
+func TestFieldConditions(t *testing.T) {
+	tests := []struct {
+		name   string
+		values map[string]interface{}
+		set    bool
+		notSet bool
+	}{
+		{"missing", map[string]interface{}{}, false, true},
+		{"nil", map[string]interface{}{"user": nil}, false, true},
+		{"empty text", map[string]interface{}{"user": ""}, false, true},
+		{"text", map[string]interface{}{"user": "bob"}, true, false},
+		{"option", map[string]interface{}{"user": map[string]interface{}{"label": "bob", "value": "id"}}, true, false},
+		{"empty multiselect", map[string]interface{}{"user": []interface{}{}}, false, true},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if got := valueSet("user")(test.values); got != test.set {
+				t.Errorf("valueSet got %t, want %t", got, test.set)
+			}
+			if got := valueNotSet("user")(test.values); got != test.notSet {
+				t.Errorf("valueNotSet got %t, want %t", got, test.notSet)
+			}
+		})
+	}
+}
+
+func TestRefreshableFormEvaluate(t *testing.T) {
+	kindOptions := []apps.SelectOption{{Label: "A", Value: "a"}, {Label: "B", Value: "b"}}
+	form := apps.Form{
+		Fields: []apps.Field{
+			{Type: apps.FieldTypeStaticSelect, Name: "kind", SelectStaticOptions: kindOptions},
+			{Type: apps.FieldTypeText, Name: "name"},
+			{Type: apps.FieldTypeStaticSelect, Name: "size", SelectStaticOptions: []apps.SelectOption{{Label: "S", Value: "s"}}},
+		},
+	}
+	kindIs := func(kind string) fieldCondition {
+		return func(values map[string]interface{}) bool {
+			option, _ := values["kind"].(map[string]interface{})
+			return option["value"] == kind
+		}
+	}
+	sizes := func(values map[string]interface{}) []apps.SelectOption {
+		return []apps.SelectOption{{Label: "L", Value: "l"}}
+	}
+	optionA := map[string]interface{}{"label": "A", "value": "a"}
+	optionB := map[string]interface{}{"label": "B", "value": "b"}
+	optionS := map[string]interface{}{"label": "S", "value": "s"}
+	optionL := map[string]interface{}{"label": "L", "value": "l"}
+	// field summarizes an evaluated field as name, value, read-only, refresh and option values
+	type field struct {
+		Name     string
+		Value    interface{}
+		ReadOnly bool
+		Refresh  bool
+		Options  []string
+	}
+	tests := []struct {
+		name         string
+		dependencies []fieldDependency
+		values       map[string]interface{}
+		want         []field
+	}{
+		{
+			"no dependencies",
+			nil,
+			map[string]interface{}{"name": "x"},
+			[]field{{"kind", nil, false, false, []string{"a", "b"}}, {"name", "x", false, false, nil}, {"size", nil, false, false, []string{"s"}}},
+		},
+		{
+			"hide when a value is set",
+			[]fieldDependency{{Field: "name", DependsOn: []string{"kind"}, When: valueSet("kind"), Hide: true}},
+			map[string]interface{}{"kind": optionA},
+			[]field{{"kind", optionA, false, true, []string{"a", "b"}}, {"size", nil, false, false, []string{"s"}}},
+		},
+		{
+			"shown when the condition does not match",
+			[]fieldDependency{{Field: "name", DependsOn: []string{"kind"}, When: valueSet("kind"), Hide: true}},
+			nil,
+			[]field{{"kind", nil, false, true, []string{"a", "b"}}, {"name", nil, false, false, nil}, {"size", nil, false, false, []string{"s"}}},
+		},
+		{
+			"disable when a value is not set",
+			[]fieldDependency{{Field: "size", DependsOn: []string{"kind"}, When: valueNotSet("kind"), Disable: true}},
+			nil,
+			[]field{{"kind", nil, false, true, []string{"a", "b"}}, {"name", nil, false, false, nil}, {"size", nil, true, false, []string{"s"}}},
+		},
+		{
+			"custom condition matching one value",
+			[]fieldDependency{{Field: "size", DependsOn: []string{"kind"}, When: kindIs("b"), Disable: true}},
+			map[string]interface{}{"kind": optionA},
+			[]field{{"kind", optionA, false, true, []string{"a", "b"}}, {"name", nil, false, false, nil}, {"size", nil, false, false, []string{"s"}}},
+		},
+		{
+			"options refresh keeps an offered value",
+			[]fieldDependency{{Field: "size", DependsOn: []string{"kind"}, When: kindIs("b"), Options: sizes}},
+			map[string]interface{}{"kind": optionB, "size": optionL},
+			[]field{{"kind", optionB, false, true, []string{"a", "b"}}, {"name", nil, false, false, nil}, {"size", optionL, false, false, []string{"l"}}},
+		},
+		{
+			"options refresh clears a value no longer offered",
+			[]fieldDependency{{Field: "size", DependsOn: []string{"kind"}, When: kindIs("b"), Options: sizes}},
+			map[string]interface{}{"kind": optionB, "size": optionS},
+			[]field{{"kind", optionB, false, true, []string{"a", "b"}}, {"name", nil, false, false, nil}, {"size", nil, false, false, []string{"l"}}},
+		},
+		{
+			"prefill an empty value",
+			[]fieldDependency{{Field: "name", DependsOn: []string{"kind"}, When: valueSet("kind"), Prefill: func(map[string]interface{}) interface{} { return "prefilled" }}},
+			map[string]interface{}{"kind": optionA},
+			[]field{{"kind", optionA, false, true, []string{"a", "b"}}, {"name", "prefilled", false, false, nil}, {"size", nil, false, false, []string{"s"}}},
+		},
+		{
+			"prefill keeps an entered value",
+			[]fieldDependency{{Field: "name", DependsOn: []string{"kind"}, When: valueSet("kind"), Prefill: func(map[string]interface{}) interface{} { return "prefilled" }}},
+			map[string]interface{}{"kind": optionA, "name": "entered"},
+			[]field{{"kind", optionA, false, true, []string{"a", "b"}}, {"name", "entered", false, false, nil}, {"size", nil, false, false, []string{"s"}}},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			rf := refreshableForm{Form: form, Dependencies: test.dependencies}
+			got := make([]field, 0)
+			for _, evaluated := range rf.evaluate(test.values).Fields {
+				var options []string
+				for _, option := range evaluated.SelectStaticOptions {
+					options = append(options, option.Value)
+				}
+				got = append(got, field{evaluated.Name, evaluated.Value, evaluated.ReadOnly, evaluated.SelectRefresh, options})
+			}
+			if !reflect.DeepEqual(got, test.want) {
+				t.Errorf("got fields %+v, want %+v", got, test.want)
+			}
+		})
+	}
+	if !reflect.DeepEqual(form.Fields[2].SelectStaticOptions, []apps.SelectOption{{Label: "S", Value: "s"}}) {
+		t.Errorf("evaluating changed the form: %+v", form.Fields[2])
+	}
+}
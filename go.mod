@@ -2,7 +2,13 @@ module github.com/neflyte/mm-apps-starter-go
 
 go 1.17
 
-require github.com/mattermost/mattermost-plugin-apps v1.1.1-0.20221004154504-78beae6cedca
+require (
+	github.com/golang-jwt/jwt/v4 v4.4.3
+	github.com/gorilla/mux v1.8.0
+	github.com/mattermost/mattermost-plugin-apps v1.1.1-0.20221004154504-78beae6cedca
+	github.com/mattermost/mattermost-server/v6 v6.6.0
+	golang.org/x/oauth2 v0.0.0-20220622183110-fd043fe589d2
+)
 
 require (
 	cloud.google.com/go v0.102.1 // indirect
@@ -27,7 +33,6 @@ require (
 	github.com/google/uuid v1.3.0 // indirect
 	github.com/googleapis/enterprise-certificate-proxy v0.1.0 // indirect
 	github.com/googleapis/gax-go/v2 v2.4.0 // indirect
-	github.com/gorilla/mux v1.8.0 // indirect
 	github.com/gorilla/websocket v1.5.0 // indirect
 	github.com/graph-gophers/graphql-go v1.4.0 // indirect
 	github.com/hashicorp/errwrap v1.1.0 // indirect
@@ -48,7 +53,6 @@ require (
 	github.com/mattermost/ldap v0.0.0-20201202150706-ee0e6284187d // indirect
 	github.com/mattermost/logr/v2 v2.0.15 // indirect
 	github.com/mattermost/mattermost-plugin-api v0.0.22-0.20211210183909-beb4761e4bd3 // indirect
-	github.com/mattermost/mattermost-server/v6 v6.6.0 // indirect
 	github.com/mattn/go-colorable v0.1.12 // indirect
 	github.com/mattn/go-isatty v0.0.14 // indirect
 	github.com/minio/md5-simd v1.1.2 // indirect
@@ -81,7 +85,6 @@ require (
 	go.uber.org/zap v1.17.0 // indirect
 	golang.org/x/crypto v0.0.0-20220525230936-793ad666bf5e // indirect
 	golang.org/x/net v0.0.0-20220624214902-1bab6f366d9e // indirect
-	golang.org/x/oauth2 v0.0.0-20220622183110-fd043fe589d2 // indirect
 	golang.org/x/sys v0.0.0-20220624220833-87e55d714810 // indirect
 	golang.org/x/text v0.3.7 // indirect
 	golang.org/x/xerrors v0.0.0-20220609144429-65e65417b02f // indirect
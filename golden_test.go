@@ -0,0 +1,288 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io/fs"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/gorilla/mux"
+	"github.com/mattermost/mattermost-plugin-apps/apps"
+	"github.com/mattermost/mattermost-plugin-apps/utils/httputils"
+)
+
+// goldenDir holds the JSON snapshots compared by assertGolden
+const goldenDir = "testdata/golden"
+
+var updateGolden = flag.Bool("update", false, "rewrite the golden files in "+goldenDir+" instead of comparing with them")
+
+// assertGolden compares the indented JSON encoding of value with the golden file of the name
+func assertGolden(t *testing.T, name string, value interface{}) {
+	t.Helper()
+	encoded, err := json.MarshalIndent(value, "", "  ")
+	if err != nil {
+		t.Fatalf("error encoding %s: %s", name, err.Error())
+	}
+	encoded = append(encoded, '\n')
+	goldenPath := filepath.Join(goldenDir, name+".json")
+	if *updateGolden {
+		err = os.MkdirAll(goldenDir, 0o755)
+		if err == nil {
+			err = os.WriteFile(goldenPath, encoded, 0o644)
+		}
+		if err != nil {
+			t.Fatalf("error writing golden file %s: %s", goldenPath, err.Error())
+		}
+		return
+	}
+	golden, err := os.ReadFile(goldenPath)
+	if err != nil {
+		t.Fatalf("error reading golden file %s (run the tests with -update to create it): %s", goldenPath, err.Error())
+	}
+	if !bytes.Equal(golden, encoded) {
+		t.Errorf("%s does not match %s (run the tests with -update if the change is intended):\n%s", name, goldenPath, encoded)
+	}
+}
+
+func TestGoldenManifestAndBindings(t *testing.T) {
+	assertGolden(t, "manifest", appManifest)
+	assertGolden(t, "bindings", appBindings)
+}
+
+func TestGoldenForms(t *testing.T) {
+	forms := map[string]apps.Form{
+		"form-send":             sendForm,
+		"form-dynamic":          dynamicForm,
+		"form-configure-oauth2": configureOAuth2Form,
+	}
+	for name, form := range forms {
+		assertGolden(t, name, form)
+	}
+}
+
+func TestGoldenResponses(t *testing.T) {
+	tests := []struct {
+		name   string
+		path   string
+		values map[string]interface{}
+	}{
+		{"response-send", "/send", nil},
+		{"response-send-form-source", "/send-form-source", map[string]interface{}{"message": "hello", "option": map[string]interface{}{"label": "Quote", "value": "quote"}}},
+		{"response-send-dynamic-form", "/send-dynamic-form", nil},
+		{"response-weather-day", "/weather/day", nil},
+		{"response-weather-week", "/weather/week", nil},
+		{"response-onboarding", "/onboarding", nil},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			at := newAppTest(t)
+			statusCode, callResponse := at.callPath(test.path, test.values)
+			if statusCode != http.StatusOK {
+				t.Fatalf("call %s returned status %d: %q", test.path, statusCode, callResponse.Text)
+			}
+			// asset URLs include the address of the fake server, which changes between runs
+			encoded, err := json.Marshal(callResponse)
+			if err != nil {
+				t.Fatalf("error encoding response: %s", err.Error())
+			}
+			assertGolden(t, test.name, json.RawMessage(strings.ReplaceAll(string(encoded), at.mattermost.URL, fixtureSiteURL)))
+		})
+	}
+}
+
+// appValidator checks that the calls and icons referenced by the manifest, bindings and forms are served
+type appValidator struct {
+	router   *mux.Router
+	problems []string
+}
+
+func newAppValidator(router *httputils.Handler) *appValidator {
+	return &appValidator{
+		router: router.Router,
+	}
+}
+
+func (v *appValidator) problem(where string, format string, args ...interface{}) {
+	v.problems = append(v.problems, fmt.Sprintf("%s: %s", where, fmt.Sprintf(format, args...)))
+}
+
+// routes reports whether the router has a handler for the method and path; unmatched requests fall through to
+// the NotFound and MethodNotAllowed handlers with a MatchErr
+func (v *appValidator) routes(method string, path string) bool {
+	match := mux.RouteMatch{}
+	return v.router.Match(httptest.NewRequest(method, path, nil), &match) && match.MatchErr == nil
+}
+
+func (v *appValidator) call(where string, call *apps.Call) {
+	if call == nil {
+		return
+	}
+	if !strings.HasPrefix(call.Path, "/") {
+		v.problem(where, "call path %q is not absolute", call.Path)
+		return
+	}
+	if !v.routes(http.MethodPost, call.Path) {
+		v.problem(where, "call path %s has no handler", call.Path)
+	}
+}
+
+func (v *appValidator) icon(where string, icon string) {
+	if icon == "" || strings.HasPrefix(icon, "http://") || strings.HasPrefix(icon, "https://") {
+		return
+	}
+	if _, err := fs.Stat(staticFS, icon); err != nil {
+		v.problem(where, "icon %s is not embedded from static/", icon)
+	}
+	recorder := httptest.NewRecorder()
+	v.router.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, staticPath+"/"+icon, nil))
+	if recorder.Code != http.StatusOK {
+		v.problem(where, "icon %s is not served", icon)
+	}
+}
+
+func (v *appValidator) form(where string, form *apps.Form) {
+	if form == nil {
+		return
+	}
+	v.icon(where, form.Icon)
+	v.call(where+" submit", form.Submit)
+	v.call(where+" source", form.Source)
+	if form.Submit == nil && form.Source == nil {
+		v.problem(where, "form has neither a submit nor a source call")
+	}
+	for _, field := range form.Fields {
+		v.call(fmt.Sprintf("%s field %s lookup", where, field.Name), field.SelectDynamicLookup)
+	}
+}
+
+func (v *appValidator) bindings(where string, bindings []apps.Binding) {
+	for _, binding := range bindings {
+		bindingWhere := where + "/" + string(binding.Location)
+		v.icon(bindingWhere, binding.Icon)
+		v.call(bindingWhere, binding.Submit)
+		v.form(bindingWhere+" form", binding.Form)
+		v.bindings(bindingWhere, binding.Bindings)
+	}
+}
+
+// commands checks that the positional arguments of every command are numbered from 1 without gaps, or -1 for
+// the last, that no required positional argument follows an optional one, and that every argument has a flag
+// name and an autocomplete hint
+func (v *appValidator) commands(where string, bindings []apps.Binding) {
+	for _, binding := range bindings {
+		bindingWhere := where + "/" + string(binding.Location)
+		v.commands(bindingWhere, binding.Bindings)
+		if binding.Form == nil {
+			continue
+		}
+		positional, _ := commandArgs(binding)
+		for i, field := range positional {
+			want := i + 1
+			if field.AutocompletePosition == -1 && i == len(positional)-1 {
+				want = -1
+			}
+			if field.AutocompletePosition != want {
+				v.problem(bindingWhere, "argument %s has position %d, want %d", field.Name, field.AutocompletePosition, want)
+			}
+			if field.IsRequired && i > 0 && !positional[i-1].IsRequired {
+				v.problem(bindingWhere, "required argument %s follows an optional one", field.Name)
+			}
+		}
+		for _, field := range binding.Form.Fields {
+			if field.Label == "" || field.AutocompleteHint == "" {
+				v.problem(bindingWhere, "argument %s has no flag name or hint", field.Name)
+			}
+		}
+		if binding.Hint == "" && len(binding.Form.Fields) > 0 {
+			v.problem(bindingWhere, "command has arguments but no hint")
+		}
+	}
+}
+
+func (v *appValidator) manifest(manifest apps.Manifest) {
+	v.icon("manifest", manifest.Icon)
+	v.call("manifest on_install", manifest.OnInstall)
+	v.call("manifest on_uninstall", manifest.OnUninstall)
+	v.call("manifest on_version_changed", manifest.OnVersionChanged)
+	v.call("manifest get_oauth2_connect_url", manifest.GetOAuth2ConnectURL)
+	v.call("manifest on_oauth2_complete", manifest.OnOAuth2Complete)
+	if manifest.OnRemoteWebhook != nil {
+		// the Apps plugin appends the path of the incoming webhook to the call path
+		webhookCall := *manifest.OnRemoteWebhook
+		webhookCall.Path += "/example"
+		v.call("manifest on_remote_webhook", &webhookCall)
+	}
+	if manifest.Bindings != nil {
+		v.call("manifest bindings", manifest.Bindings)
+	} else {
+		v.call("manifest bindings", &apps.DefaultBindings)
+	}
+}
+
+func TestManifestAndBindingsReferenceServedCallsAndIcons(t *testing.T) {
+	v := newAppValidator(newRouter())
+	v.manifest(appManifest)
+	v.bindings("", appBindings)
+	v.commands("command", appCommandBindings())
+	v.form("send form", &sendForm)
+	v.form("dynamic form", &dynamicForm)
+	// forms that are only reachable through a call response
+	at := newAppTest(t)
+	for _, path := range []string{"/send", "/send-dynamic-form", "/onboarding"} {
+		_, callResponse := at.callPath(path, nil)
+		v.form(path+" response form", callResponse.Form)
+	}
+	for _, problem := range v.problems {
+		t.Error(problem)
+	}
+}
+
+func TestAppValidatorReportsProblems(t *testing.T) {
+	v := newAppValidator(newRouter())
+	v.bindings("", []apps.Binding{
+		{
+			Location: "broken",
+			Icon:     "missing.png",
+			Submit:   apps.NewCall("/missing"),
+		},
+		{
+			Location: "static",
+			Submit:   apps.NewCall("/manifest.json"),
+		},
+	})
+	// the missing icon is reported both as not embedded and as not served
+	if len(v.problems) != 4 {
+		t.Errorf("got problems %q, want 4", v.problems)
+	}
+}
+
+func TestAppValidatorReportsInconsistentCommandArguments(t *testing.T) {
+	v := newAppValidator(newRouter())
+	v.commands("", []apps.Binding{
+		{
+			Location: "broken",
+			Form: &apps.Form{
+				Fields: []apps.Field{
+					{Name: "first", AutocompletePosition: 1},
+					{Name: "third", AutocompletePosition: 3, IsRequired: true},
+				},
+			},
+		},
+	})
+	// the gap, the required argument after an optional one, two arguments without a flag name and the missing hint
+	if len(v.problems) != 5 {
+		t.Errorf("got problems %q, want 5", v.problems)
+	}
+	v = newAppValidator(newRouter())
+	v.commands("", commandBindings(nil))
+	if len(v.problems) != 0 {
+		t.Errorf("prepared bindings have problems %q", v.problems)
+	}
+}
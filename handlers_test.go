@@ -0,0 +1,893 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"regexp"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/mattermost/mattermost-plugin-apps/apps"
+	"github.com/mattermost/mattermost-server/v6/model"
+	"golang.org/x/oauth2"
+)
+
+func TestCallsMustBeSignedByTheAppsPlugin(t *testing.T) {
+	at := newAppTest(t)
+	body, err := json.Marshal(apps.CallRequest{
+		Call:    *apps.NewCall("/info"),
+		Context: at.context(),
+	})
+	if err != nil {
+		t.Fatalf("error encoding call request: %s", err.Error())
+	}
+	tests := []struct {
+		name          string
+		authorization string
+		want          int
+	}{
+		{"unsigned", "", http.StatusForbidden},
+		{"wrong secret", at.signCall("some-other-secret"), http.StatusForbidden},
+		{"not a token", "Bearer not-a-token", http.StatusForbidden},
+		{"signed", at.signCall(testAppSecret), http.StatusOK},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			header := http.Header{"Content-Type": []string{"application/json"}}
+			if test.authorization != "" {
+				header.Set(apps.OutgoingAuthHeader, test.authorization)
+			}
+			statusCode, responseBody := at.post("/info", body, header)
+			if statusCode != test.want {
+				t.Errorf("got status %d, want %d: %s", statusCode, test.want, responseBody)
+			}
+		})
+	}
+}
+
+func TestCallResponses(t *testing.T) {
+	tests := []struct {
+		path   string
+		values map[string]interface{}
+		want   apps.CallResponseType
+	}{
+		{"/bindings", nil, apps.CallResponseTypeOK},
+		{"/send", nil, apps.CallResponseTypeForm},
+		{"/send-form-source", map[string]interface{}{"message": "hello"}, apps.CallResponseTypeForm},
+		{"/send-dynamic-form", nil, apps.CallResponseTypeForm},
+		{"/dynamic-form-submit", map[string]interface{}{
+			"option": map[string]interface{}{"label": "Option One", "value": "option_1"},
+		}, apps.CallResponseTypeOK},
+		{"/weather/day", nil, apps.CallResponseTypeOK},
+		{"/weather/week", nil, apps.CallResponseTypeOK},
+		{"/weather", nil, apps.CallResponseTypeError},
+		{"/event", nil, apps.CallResponseTypeOK},
+		{"/uninstalled", nil, apps.CallResponseTypeOK},
+		{"/info", nil, apps.CallResponseTypeOK},
+		{"/set-roast-preference", nil, apps.CallResponseTypeOK},
+		{"/onboarding", nil, apps.CallResponseTypeForm},
+		{"/dynamic-form-lookup", nil, apps.CallResponseTypeOK},
+		{"/dynamic-form-lookup/schedule", nil, apps.CallResponseTypeOK},
+		{"/modal-submit", map[string]interface{}{
+			"message": "hello",
+			"user":    map[string]interface{}{"label": "alice", "value": model.NewId()},
+		}, apps.CallResponseTypeOK},
+		{"/modal-submit", nil, apps.CallResponseTypeError},
+		{"/sub", nil, apps.CallResponseTypeError},
+		{"/connect", nil, apps.CallResponseTypeError},
+		{"/disconnect", nil, apps.CallResponseTypeOK},
+		{"/no-such-path", nil, apps.CallResponseTypeError},
+	}
+	for _, test := range tests {
+		t.Run(test.path, func(t *testing.T) {
+			at := newAppTest(t)
+			_, callResponse := at.callPath(test.path, test.values)
+			if callResponse.Type == "" {
+				callResponse.Type = apps.CallResponseTypeOK
+			}
+			if callResponse.Type != test.want {
+				t.Errorf("got %s response %q, want %s", callResponse.Type, callResponse.Text, test.want)
+			}
+		})
+	}
+}
+
+func TestSubscribeAndUnsubscribe(t *testing.T) {
+	at := newAppTest(t)
+	channelID := model.NewId()
+	at.mustCall("/sub", map[string]interface{}{
+		"eventname": "bot_joined_channel",
+		"channelid": channelID,
+	}, apps.CallResponseTypeOK)
+	if len(at.mattermost.Subscriptions) != 1 || at.mattermost.Subscriptions[0].Event.ChannelID != channelID {
+		t.Fatalf("unexpected subscriptions %v", at.mattermost.Subscriptions)
+	}
+	if !containsString(at.mattermost.ChannelMembers[channelID], testBotUserID) {
+		t.Errorf("bot was not added to the channel")
+	}
+	// the subscription is stored, so it outlives the process
+	stored := eventSubscription{}
+	if !at.mattermost.kvGet(t, subscriptionKVPrefix, "bot_joined_channel", &stored) || stored.OwnerID != at.user.Id ||
+		stored.Subscription == nil || stored.Subscription.Event.ChannelID != channelID {
+		t.Fatalf("unexpected stored subscription %+v", stored)
+	}
+	at.mustFail("/sub", map[string]interface{}{"eventname": "bot_joined_channel"}, "a subscription for this event already exists")
+	at.mustCall("/unsub", map[string]interface{}{"eventname": "bot_joined_channel"}, apps.CallResponseTypeOK)
+	if len(at.mattermost.Subscriptions) != 0 {
+		t.Errorf("unexpected subscriptions %v", at.mattermost.Subscriptions)
+	}
+	if at.mattermost.kvGet(t, subscriptionKVPrefix, "bot_joined_channel", &stored) {
+		t.Errorf("the stored subscription was not deleted")
+	}
+}
+
+func TestConcurrentSubscribesMakeOneSubscription(t *testing.T) {
+	at := newAppTest(t)
+	var wg sync.WaitGroup
+	for i := 0; i < 5; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			at.callPath("/sub", map[string]interface{}{"eventname": "channel_created"})
+		}()
+	}
+	wg.Wait()
+	if len(at.mattermost.Subscriptions) != 1 {
+		t.Errorf("got %d subscriptions, want 1", len(at.mattermost.Subscriptions))
+	}
+}
+
+func TestSubscribeReportsServerFailures(t *testing.T) {
+	at := newAppTest(t)
+	at.mattermost.Failures[http.MethodPost+" "+appsPluginAPIPath+"/subscribe"] = http.StatusForbidden
+	callResponse := at.mustFail("/sub", map[string]interface{}{"eventname": "channel_created"}, "error subscribing to event: the request to the server failed")
+	if !regexp.MustCompile(`^error subscribing to event: the request to the server failed \[trace ID [0-9a-f]{32}\]$`).MatchString(callResponse.Text) {
+		t.Errorf("upstream error %q does not hide its details behind the trace ID", callResponse.Text)
+	}
+}
+
+func TestSubscribeAsActingUser(t *testing.T) {
+	at := newAppTest(t)
+	at.mustCall("/sub", map[string]interface{}{"eventname": string(subjectSelfMentioned)}, apps.CallResponseTypeOK)
+	at.mustCall("/sub", map[string]interface{}{"eventname": "channel_created", "as_user": true}, apps.CallResponseTypeOK)
+	at.mustCall("/sub", map[string]interface{}{"eventname": "channel_created"}, apps.CallResponseTypeOK)
+	want := []string{testUserAccessToken, testUserAccessToken, testBotAccessToken}
+	if len(at.mattermost.SubscriptionTokens) != len(want) {
+		t.Fatalf("unexpected subscriptions %v", at.mattermost.Subscriptions)
+	}
+	for i, token := range want {
+		if at.mattermost.SubscriptionTokens[i] != token {
+			t.Errorf("subscription %s was made with %s, want %s", at.mattermost.Subscriptions[i].Subject, at.mattermost.SubscriptionTokens[i], token)
+		}
+	}
+	at.mustCall("/unsub", map[string]interface{}{"eventname": string(subjectSelfMentioned)}, apps.CallResponseTypeOK)
+	at.mustCall("/unsub", map[string]interface{}{"eventname": "channel_created", "as_user": true}, apps.CallResponseTypeOK)
+	if len(at.mattermost.Subscriptions) != 1 || at.mattermost.SubscriptionTokens[0] != testBotAccessToken {
+		t.Errorf("unexpected subscriptions %v", at.mattermost.Subscriptions)
+	}
+}
+
+func TestSubscribeAsActingUserRequiresTheUserToken(t *testing.T) {
+	at := newAppTest(t)
+	appContext := at.context()
+	appContext.ActingUserAccessToken = ""
+	at.mustFailCall(apps.CallRequest{
+		Call:    *apps.NewCall("/sub"),
+		Context: appContext,
+		Values:  map[string]interface{}{"eventname": string(subjectSelfMentioned)},
+	}, "requires the app to act as you")
+	if len(at.mattermost.Subscriptions) != 0 {
+		t.Errorf("unexpected subscriptions %v", at.mattermost.Subscriptions)
+	}
+}
+
+func TestOnlyTheOwnerCanUnsubscribe(t *testing.T) {
+	at := newAppTest(t)
+	at.mustCall("/sub", map[string]interface{}{"eventname": "channel_created"}, apps.CallResponseTypeOK)
+	at.mustCall("/sub", map[string]interface{}{"eventname": string(subjectSelfMentioned)}, apps.CallResponseTypeOK)
+	owner := at.user
+	at.user = &model.User{Id: model.NewId(), Username: "bob", Roles: model.SystemUserRoleId}
+	at.mustFail("/unsub", map[string]interface{}{"eventname": "channel_created"}, "only the user who created the subscription")
+	at.mustFail("/unsub", map[string]interface{}{"eventname": string(subjectSelfMentioned)}, "no subscription for event")
+	if len(at.mattermost.Subscriptions) != 2 {
+		t.Errorf("unexpected subscriptions %v", at.mattermost.Subscriptions)
+	}
+	at.user = owner
+	at.mustCall("/unsub", map[string]interface{}{"eventname": "channel_created"}, apps.CallResponseTypeOK)
+}
+
+func TestSendFormDeliversTheMessage(t *testing.T) {
+	bob := model.NewId()
+	user := map[string]interface{}{"label": "bob", "value": bob}
+	tests := []struct {
+		style    string
+		delivery string
+		message  string
+		text     string
+	}{
+		{sendStylePlain, sendDeliveryDM, "From @alice: hello", "@bob as a direct message"},
+		{sendStyleQuote, sendDeliveryDM, "@alice says:\n> hello", "@bob as a direct message"},
+		{sendStyleCard, sendDeliveryDM, "", "@bob as a direct message"},
+		{sendStylePlain, sendDeliveryChannel, "@bob From @alice: hello", "for @bob in this channel"},
+		{sendStyleCard, sendDeliveryChannel, "@bob", "for @bob in this channel"},
+	}
+	for _, test := range tests {
+		t.Run(test.style+"-"+test.delivery, func(t *testing.T) {
+			at := newAppTest(t)
+			callResponse := at.mustCall("/modal-submit", map[string]interface{}{
+				"message":  "hello",
+				"user":     user,
+				"option":   map[string]interface{}{"label": test.style, "value": test.style},
+				"delivery": map[string]interface{}{"label": test.delivery, "value": test.delivery},
+			}, apps.CallResponseTypeOK)
+			if !strings.HasSuffix(callResponse.Text, test.text) {
+				t.Errorf("got text %q, want it to end with %q", callResponse.Text, test.text)
+			}
+			posts := at.mattermost.posts()
+			if len(posts) != 1 {
+				t.Fatalf("got %d posts, want 1", len(posts))
+			}
+			wantChannelID := model.GetDMNameFromIds(testBotUserID, bob)
+			if test.delivery == sendDeliveryChannel {
+				wantChannelID = at.channel.Id
+			}
+			if posts[0].ChannelId != wantChannelID || posts[0].Message != test.message {
+				t.Errorf("got message %q in %s, want %q in %s", posts[0].Message, posts[0].ChannelId, test.message, wantChannelID)
+			}
+			attachments := posts[0].Attachments()
+			if (test.style == sendStyleCard) != (len(attachments) == 1) {
+				t.Errorf("unexpected attachments %v", attachments)
+			}
+			if len(attachments) == 1 && (attachments[0].Text != "hello" || attachments[0].AuthorName != "@alice") {
+				t.Errorf("unexpected card %+v", attachments[0])
+			}
+		})
+	}
+}
+
+func TestSendFormNeutralizesMentions(t *testing.T) {
+	tests := []struct {
+		style   string
+		message string
+		card    string
+	}{
+		{sendStylePlain, "@bob From @alice: @\u200bchannel hello", ""},
+		{sendStyleQuote, "@bob @alice says:\n> @\u200bchannel hello", ""},
+		{sendStyleCard, "@bob", "@\u200bchannel hello"},
+	}
+	for _, test := range tests {
+		t.Run(test.style, func(t *testing.T) {
+			at := newAppTest(t)
+			at.mustCall("/modal-submit", map[string]interface{}{
+				"message":  "@channel hello",
+				"user":     map[string]interface{}{"label": "bob", "value": model.NewId()},
+				"option":   map[string]interface{}{"label": test.style, "value": test.style},
+				"delivery": map[string]interface{}{"label": sendDeliveryChannel, "value": sendDeliveryChannel},
+			}, apps.CallResponseTypeOK)
+			posts := at.mattermost.posts()
+			if len(posts) != 1 || posts[0].Message != test.message {
+				t.Fatalf("got posts %v, want the message %q", posts, test.message)
+			}
+			attachments := posts[0].Attachments()
+			if test.card == "" {
+				if len(attachments) != 0 {
+					t.Errorf("unexpected attachments %v", attachments)
+				}
+				return
+			}
+			if len(attachments) != 1 || attachments[0].Text != test.card || attachments[0].Fallback != "@alice: "+test.card {
+				t.Errorf("got attachments %+v, want the text %q", attachments, test.card)
+			}
+		})
+	}
+}
+
+func TestSendFormReportsDeliveryFailures(t *testing.T) {
+	at := newAppTest(t)
+	at.mattermost.Failures[http.MethodPost+" /api/v4/posts"] = http.StatusForbidden
+	at.mustFail("/modal-submit", map[string]interface{}{
+		"message": "hello",
+		"user":    map[string]interface{}{"label": "bob", "value": model.NewId()},
+	}, "error sending the message to @bob")
+}
+
+func TestSendFormRejectsUnknownUsers(t *testing.T) {
+	at := newAppTest(t)
+	_, callResponse := at.callPath("/modal-submit", map[string]interface{}{
+		"message": "hello",
+		"user":    map[string]interface{}{"label": "bob", "value": "bob"},
+	})
+	if callResponse.Type != apps.CallResponseTypeError || len(at.mattermost.posts()) != 0 {
+		t.Errorf("got %s response %q, want a field error", callResponse.Type, callResponse.Text)
+	}
+}
+
+func TestHelpListsCommandsAndArguments(t *testing.T) {
+	at := newAppTest(t)
+	callResponse := at.mustCall("/help", nil, apps.CallResponseTypeOK)
+	for _, want := range []string{
+		"- `/hello-world weather day [location] [--units]`: Show the weather conditions for today",
+		"  - `location`: The location to report the weather for\n",
+		"  - `--units`: The temperature units\n",
+		"- `/hello-world sub [eventname] [teamid] [channelid] [--as_user]`: Subscribe to an event",
+		"  - `eventname`: The name of the event to subscribe to (required)",
+		"- `/hello-world help [command]`",
+	} {
+		if !strings.Contains(callResponse.Text, want) {
+			t.Errorf("help does not include %q:\n%s", want, callResponse.Text)
+		}
+	}
+	callResponse = at.mustCall("/help", map[string]interface{}{"command": "weather"}, apps.CallResponseTypeOK)
+	if !strings.Contains(callResponse.Text, "/hello-world weather schedule") || strings.Contains(callResponse.Text, "/hello-world sub") {
+		t.Errorf("help for weather lists other commands:\n%s", callResponse.Text)
+	}
+	callResponse = at.mustCall("/help", map[string]interface{}{"command": "weather day"}, apps.CallResponseTypeOK)
+	if strings.Count(callResponse.Text, "- `/hello-world") != 1 {
+		t.Errorf("help for weather day lists other commands:\n%s", callResponse.Text)
+	}
+	at.mustFail("/help", map[string]interface{}{"command": "weather nope"}, "no command `/hello-world weather nope`")
+}
+
+func TestWeatherArguments(t *testing.T) {
+	at := newAppTest(t)
+	_, callResponse := at.callPath("/weather/day", map[string]interface{}{
+		"location": "Paris",
+		"units":    map[string]interface{}{"label": "Fahrenheit", "value": weatherUnitsFahrenheit},
+	})
+	if !strings.Contains(callResponse.Text, "| 37 °F   | 10 °F |") {
+		t.Errorf("the units were not applied:\n%s", callResponse.Text)
+	}
+	if strings.Contains(callResponse.Text, "Weather in Paris") || !strings.Contains(callResponse.Text, "sample report for "+weatherDefaultLocation+", not for Paris") {
+		t.Errorf("the sample report is not labelled as sample data:\n%s", callResponse.Text)
+	}
+	_, callResponse = at.callPath("/weather/day", nil)
+	if strings.Contains(callResponse.Text, "sample report") {
+		t.Errorf("the report for its own location is labelled as for another:\n%s", callResponse.Text)
+	}
+	if !strings.Contains(weatherDayResponseData.Text, weatherDefaultLocation) {
+		t.Errorf("the arguments changed the canned report")
+	}
+	at.mustFail("/weather", nil, "unknown argument")
+}
+
+func TestInstallRecordsInfoAndWelcomesTheInstaller(t *testing.T) {
+	at := newAppTest(t)
+	at.mustCall("/installed", nil, apps.CallResponseTypeOK)
+	info := installInfo{}
+	if !at.mattermost.kvGet(t, installInfoKVPrefix, installInfoKVKey, &info) {
+		t.Fatalf("install info was not stored")
+	}
+	if info.InstalledByID != at.user.Id {
+		t.Errorf("install info records installer %q, want %q", info.InstalledByID, at.user.Id)
+	}
+	posts := at.mattermost.posts()
+	if len(posts) != 1 || posts[0].ChannelId != model.GetDMNameFromIds(testBotUserID, at.user.Id) {
+		t.Fatalf("unexpected posts %v", posts)
+	}
+	callResponse := at.mustCall("/info", nil, apps.CallResponseTypeOK)
+	if !strings.Contains(callResponse.Text, "@"+at.user.Username) {
+		t.Errorf("info does not name the installer: %q", callResponse.Text)
+	}
+}
+
+func TestSendMessageAttachmentPostsToTheChannel(t *testing.T) {
+	at := newAppTest(t)
+	at.mustCall("/send-message-attachment", nil, apps.CallResponseTypeOK)
+	posts := at.mattermost.posts()
+	if len(posts) != 1 || posts[0].ChannelId != at.channel.Id {
+		t.Fatalf("unexpected posts %v", posts)
+	}
+	if posts[0].GetProp(apps.PropAppBindings) == nil {
+		t.Errorf("post has no app bindings")
+	}
+}
+
+func TestWebhookLifecycle(t *testing.T) {
+	at := newAppTest(t)
+	at.mustCall("/webhook-create", map[string]interface{}{"template": "{{ .text }}"}, apps.CallResponseTypeOK)
+	index := make([]string, 0)
+	if !at.mattermost.kvGet(t, webhookKVPrefix, kvIndexKey, &index) || len(index) != 1 {
+		t.Fatalf("unexpected webhook index %v", index)
+	}
+	route := webhookRoute{}
+	at.mattermost.kvGet(t, webhookKVPrefix, index[0], &route)
+	if route.ChannelID != at.channel.Id {
+		t.Errorf("webhook posts to %q, want %q", route.ChannelID, at.channel.Id)
+	}
+	if !containsString(at.mattermost.ChannelMembers[at.channel.Id], testBotUserID) {
+		t.Errorf("bot was not added to the channel")
+	}
+	callResponse := at.mustCall("/webhook-list", nil, apps.CallResponseTypeOK)
+	if !strings.Contains(callResponse.Text, route.ID) {
+		t.Errorf("webhook list does not include %s: %q", route.ID, callResponse.Text)
+	}
+
+	at.mustFail(webhookCallPath+"/"+route.ID, map[string]interface{}{
+		"rawQuery": "secret=wrong",
+		"data":     map[string]interface{}{"text": "hello"},
+	}, "webhook secret mismatched")
+	at.mustCall(webhookCallPath+"/"+route.ID, map[string]interface{}{
+		"rawQuery": "secret=" + route.Secret,
+		"data":     map[string]interface{}{"text": "hello"},
+	}, apps.CallResponseTypeOK)
+	posts := at.mattermost.posts()
+	if len(posts) != 1 || posts[0].ChannelId != at.channel.Id || posts[0].Message != "hello" {
+		t.Fatalf("unexpected posts %v", posts)
+	}
+
+	at.mustCall("/webhook-delete", map[string]interface{}{"id": route.ID}, apps.CallResponseTypeOK)
+	if at.mattermost.kvGet(t, webhookKVPrefix, route.ID, &route) {
+		t.Errorf("webhook %s was not deleted", route.ID)
+	}
+	at.mustFail("/webhook-delete", map[string]interface{}{"id": route.ID}, "no webhook with that id")
+}
+
+func TestWebhookCommandsAreRestrictedToSystemAdmins(t *testing.T) {
+	at := newAppTest(t)
+	at.user.Roles = model.SystemUserRoleId
+	for _, path := range []string{"/webhook-create", "/webhook-list", "/webhook-delete"} {
+		at.mustFail(path, map[string]interface{}{"id": model.NewId()}, "restricted to system administrators")
+	}
+	if len(at.mattermost.KVWrites) != 0 {
+		t.Errorf("unexpected KV writes %v", at.mattermost.KVWrites)
+	}
+}
+
+func TestWeatherScheduleLifecycle(t *testing.T) {
+	at := newAppTest(t)
+	at.mustCall("/weather/timezone", map[string]interface{}{"timezone": "Europe/Paris"}, apps.CallResponseTypeOK)
+	at.mustCall("/weather/schedule", map[string]interface{}{
+		"frequency": "weekdays",
+		"time":      "08:30",
+		"location":  "Paris",
+	}, apps.CallResponseTypeOK)
+	index := make([]string, 0)
+	if !at.mattermost.kvGet(t, scheduleKVPrefix, kvIndexKey, &index) || len(index) != 1 {
+		t.Fatalf("unexpected schedule index %v", index)
+	}
+	job := scheduledJob{}
+	at.mattermost.kvGet(t, scheduleKVPrefix, index[0], &job)
+	if job.Expression != "30 8 * * 1-5" || job.Timezone != "Europe/Paris" || job.ChannelID != at.channel.Id {
+		t.Errorf("unexpected job %+v", job)
+	}
+	callResponse := at.mustCall("/weather/schedules", nil, apps.CallResponseTypeOK)
+	if !strings.Contains(callResponse.Text, job.ID) {
+		t.Errorf("schedule list does not include %s: %q", job.ID, callResponse.Text)
+	}
+	lookupSchedules := func(channelID string) []apps.SelectOption {
+		t.Helper()
+		appContext := at.context()
+		appContext.Channel = &model.Channel{Id: channelID}
+		data := struct {
+			Items []apps.SelectOption `json:"items"`
+		}{}
+		statusCode, callResponse := at.call(apps.CallRequest{
+			Call:    *apps.NewCall("/dynamic-form-lookup/schedule"),
+			Context: appContext,
+		})
+		if statusCode != http.StatusOK || callResponse.Type != apps.CallResponseTypeOK {
+			t.Fatalf("the schedule lookup returned status %d and %s response %q", statusCode, callResponse.Type, callResponse.Text)
+		}
+		encoded, _ := json.Marshal(callResponse.Data)
+		_ = json.Unmarshal(encoded, &data)
+		return data.Items
+	}
+	if items := lookupSchedules(at.channel.Id); len(items) != 1 || items[0].Value != job.ID || items[0].Label != "Paris" {
+		t.Errorf("the schedule lookup returned %v, want the schedule of the channel", items)
+	}
+	if items := lookupSchedules(model.NewId()); len(items) != 0 {
+		t.Errorf("the schedule lookup offered %v in another channel", items)
+	}
+	at.mustCall("/weather/pause", map[string]interface{}{"id": job.ID}, apps.CallResponseTypeOK)
+	at.mattermost.kvGet(t, scheduleKVPrefix, job.ID, &job)
+	if !job.Paused {
+		t.Errorf("job %s was not paused", job.ID)
+	}
+	at.mustCall("/weather/resume", map[string]interface{}{"id": job.ID}, apps.CallResponseTypeOK)
+	at.mattermost.kvGet(t, scheduleKVPrefix, job.ID, &job)
+	if job.Paused {
+		t.Errorf("job %s was not resumed", job.ID)
+	}
+	at.mustCall("/weather/unschedule", map[string]interface{}{"id": job.ID}, apps.CallResponseTypeOK)
+	if at.mattermost.kvGet(t, scheduleKVPrefix, job.ID, &job) {
+		t.Errorf("job %s was not deleted", job.ID)
+	}
+	if items := lookupSchedules(at.channel.Id); len(items) != 0 {
+		t.Errorf("the schedule lookup still offers %v", items)
+	}
+}
+
+func TestWeatherScheduleRejectsInvalidTimes(t *testing.T) {
+	at := newAppTest(t)
+	at.mustFail("/weather/schedule", map[string]interface{}{
+		"frequency": "daily",
+		"time":      "25:00",
+		"location":  "Paris",
+	}, "invalid hour")
+}
+
+func TestWeatherScheduleCommandsRequireAChannel(t *testing.T) {
+	at := newAppTest(t)
+	appContext := at.context()
+	appContext.Channel = nil
+	values := map[string]interface{}{
+		"frequency": "daily",
+		"time":      "08:00",
+		"location":  "Paris",
+		"id":        model.NewId(),
+		"timezone":  "UTC",
+	}
+	for _, path := range []string{"/weather/schedule", "/weather/schedules", "/weather/pause", "/weather/resume", "/weather/unschedule", "/weather/timezone"} {
+		at.mustFailCall(apps.CallRequest{
+			Call:    *apps.NewCall(path),
+			Values:  values,
+			Context: appContext,
+		}, "channel not expanded")
+	}
+}
+
+func TestOnboardingWizard(t *testing.T) {
+	at := newAppTest(t)
+	form := at.mustCall("/onboarding", nil, apps.CallResponseTypeForm).Form
+	submit := func(form *apps.Form, values map[string]interface{}, want apps.CallResponseType) apps.CallResponse {
+		t.Helper()
+		if form == nil || form.Submit == nil {
+			t.Fatalf("wizard returned no form to submit")
+		}
+		statusCode, callResponse := at.call(apps.CallRequest{
+			Call:   *form.Submit,
+			Values: values,
+		})
+		if statusCode != http.StatusOK || callResponse.Type != want {
+			t.Fatalf("wizard step returned status %d and %s response %q, want %s", statusCode, callResponse.Type, callResponse.Text, want)
+		}
+		return callResponse
+	}
+	role := map[string]interface{}{"label": "Developer", "value": "developer"}
+	form = submit(form, map[string]interface{}{wizardActionField: wizardActionNext, "name": "Alice", "role": role}, apps.CallResponseTypeForm).Form
+	form = submit(form, map[string]interface{}{wizardActionField: wizardActionNext, "notifications": true}, apps.CallResponseTypeForm).Form
+	callResponse := submit(form, map[string]interface{}{wizardActionField: wizardActionConfirm}, apps.CallResponseTypeOK)
+	if !strings.Contains(callResponse.Text, "Alice") {
+		t.Errorf("unexpected completion text %q", callResponse.Text)
+	}
+	answers := map[string]interface{}{}
+	if !at.mattermost.kvGet(t, onboardingKVPrefix, at.user.Id, &answers) || answers["name"] != "Alice" {
+		t.Errorf("unexpected stored answers %v", answers)
+	}
+}
+
+func TestOnboardingWizardRevalidatesAnswersOnConfirm(t *testing.T) {
+	at := newAppTest(t)
+	confirm := func(answers map[string]interface{}) apps.CallRequest {
+		return apps.CallRequest{
+			Call:   *onboardingWizard.submitCall(wizardState{Step: len(onboardingWizard.Steps), Answers: answers}),
+			Values: map[string]interface{}{wizardActionField: wizardActionConfirm},
+		}
+	}
+	at.mustFailCall(confirm(map[string]interface{}{
+		"role": map[string]interface{}{"label": "Developer", "value": "developer"},
+	}), `the answer to "name" in step 1 is invalid`)
+	at.mustFailCall(confirm(map[string]interface{}{
+		"name": "Alice",
+		"role": map[string]interface{}{"label": "Root", "value": "root"},
+	}), `"root" is not one of the available options`)
+	if len(at.mattermost.KVWrites) != 0 {
+		t.Errorf("invalid answers were stored: %v", at.mattermost.KVWrites)
+	}
+
+	at.call(confirm(map[string]interface{}{
+		"name":  "Alice",
+		"role":  map[string]interface{}{"label": "Developer", "value": "developer"},
+		"admin": true,
+	}))
+	answers := map[string]interface{}{}
+	if !at.mattermost.kvGet(t, onboardingKVPrefix, at.user.Id, &answers) || answers["name"] != "Alice" {
+		t.Fatalf("unexpected stored answers %v", answers)
+	}
+	if _, ok := answers["admin"]; ok {
+		t.Errorf("an answer to an unknown field was stored: %v", answers)
+	}
+}
+
+func TestLookupsUseTheServer(t *testing.T) {
+	at := newAppTest(t)
+	at.mattermost.Users = []*model.User{
+		{Id: model.NewId(), Username: "alice"},
+		{Id: model.NewId(), Username: "bob"},
+	}
+	at.mattermost.Channels = []*model.Channel{
+		{Id: model.NewId(), TeamId: at.team.Id, Name: "general", DisplayName: "General", Type: model.ChannelTypeOpen},
+		{Id: model.NewId(), TeamId: model.NewId(), Name: "general", DisplayName: "Elsewhere", Type: model.ChannelTypeOpen},
+	}
+	tests := []struct {
+		path  string
+		query string
+		want  string
+	}{
+		{"/dynamic-form-lookup/user", "ali", at.mattermost.Users[0].Id},
+		{"/dynamic-form-lookup/channel", "gen", at.mattermost.Channels[0].Id},
+	}
+	for _, test := range tests {
+		statusCode, callResponse := at.call(apps.CallRequest{
+			Call:  *apps.NewCall(test.path),
+			Query: test.query,
+		})
+		if statusCode != http.StatusOK || callResponse.Type != apps.CallResponseTypeOK {
+			t.Fatalf("%s returned status %d and %s response %q", test.path, statusCode, callResponse.Type, callResponse.Text)
+		}
+		data := struct {
+			Items []apps.SelectOption `json:"items"`
+		}{}
+		encoded, _ := json.Marshal(callResponse.Data)
+		_ = json.Unmarshal(encoded, &data)
+		if len(data.Items) != 1 || data.Items[0].Value != test.want {
+			t.Errorf("%s returned %v, want only %s", test.path, data.Items, test.want)
+		}
+	}
+}
+
+func TestOAuth2Flow(t *testing.T) {
+	at := newAppTest(t)
+	at.mustCall("/configure-oauth2", map[string]interface{}{
+		"client_id":     "client-id",
+		"client_secret": "client-secret",
+		"auth_url":      at.mattermost.URL + "/oauth2/authorize",
+		"token_url":     at.mattermost.URL + "/oauth2/token",
+		"scopes":        "read, write",
+	}, apps.CallResponseTypeOK)
+	if at.mattermost.OAuth2App == nil || at.mattermost.OAuth2App.ClientID != "client-id" {
+		t.Fatalf("unexpected OAuth2 app %v", at.mattermost.OAuth2App)
+	}
+
+	request := func(path string, token *oauth2.Token, values map[string]interface{}) apps.CallResponse {
+		t.Helper()
+		appContext := at.context()
+		appContext.OAuth2 = at.oauth2Context(token)
+		statusCode, callResponse := at.call(apps.CallRequest{
+			Call:    *apps.NewCall(path),
+			Context: appContext,
+			Values:  values,
+		})
+		if statusCode != http.StatusOK || callResponse.Type != apps.CallResponseTypeOK {
+			t.Fatalf("%s returned status %d and %s response %q", path, statusCode, callResponse.Type, callResponse.Text)
+		}
+		return callResponse
+	}
+	callResponse := request("/connect", nil, nil)
+	if !strings.Contains(callResponse.Text, "/oauth2/remote/connect") {
+		t.Errorf("connect does not link to the connect URL: %q", callResponse.Text)
+	}
+	callResponse = request("/oauth2/connect", nil, map[string]interface{}{"state": "some-state"})
+	if connectURL, _ := callResponse.Data.(string); !strings.HasPrefix(connectURL, at.mattermost.URL+"/oauth2/authorize?") {
+		t.Errorf("unexpected connect URL %v", callResponse.Data)
+	}
+	request("/oauth2/complete", nil, map[string]interface{}{"code": "some-code"})
+	user := oauth2User{}
+	_ = json.Unmarshal(at.mattermost.OAuth2User, &user)
+	if user.Token == nil || user.Token.AccessToken != "provider-access-token" {
+		t.Fatalf("unexpected stored OAuth2 user %s", at.mattermost.OAuth2User)
+	}
+	callResponse = request("/disconnect", user.Token, nil)
+	if !strings.Contains(callResponse.Text, "disconnected") {
+		t.Errorf("unexpected disconnect text %q", callResponse.Text)
+	}
+}
+
+// postMenuCall sends a call made from the menu of the post
+func (at *appTest) postMenuCall(path string, post *model.Post, values map[string]interface{}) (int, apps.CallResponse) {
+	at.t.Helper()
+	appContext := at.context()
+	appContext.Post = post
+	return at.call(apps.CallRequest{
+		Call:    *apps.NewCall(path),
+		Context: appContext,
+		Values:  values,
+	})
+}
+
+func (at *appTest) newPost(message string) *model.Post {
+	return &model.Post{
+		Id:        model.NewId(),
+		ChannelId: at.channel.Id,
+		UserId:    model.NewId(),
+		Message:   message,
+	}
+}
+
+func TestSaveAndListNotes(t *testing.T) {
+	at := newAppTest(t)
+	callResponse := at.mustCall("/notes", nil, apps.CallResponseTypeOK)
+	if !strings.Contains(callResponse.Text, "no saved notes") {
+		t.Errorf("unexpected empty notes text %q", callResponse.Text)
+	}
+	first, second := at.newPost("first message\nwith a second line"), at.newPost("second message")
+	for _, post := range []*model.Post{first, second} {
+		statusCode, callResponse := at.postMenuCall("/post/save-note", post, nil)
+		if statusCode != http.StatusOK || callResponse.Type != apps.CallResponseTypeOK {
+			t.Fatalf("save-note returned status %d and %s response %q", statusCode, callResponse.Type, callResponse.Text)
+		}
+	}
+	_, callResponse = at.postMenuCall("/post/save-note", first, nil)
+	if callResponse.Type != apps.CallResponseTypeError || !strings.Contains(callResponse.Text, "already in your notes") {
+		t.Errorf("saving a post twice returned %s response %q", callResponse.Type, callResponse.Text)
+	}
+	notes := make([]savedNote, 0)
+	if !at.mattermost.kvGet(t, notesKVPrefix, at.user.Id, &notes) || len(notes) != 2 {
+		t.Fatalf("unexpected stored notes %v", notes)
+	}
+	callResponse = at.mustCall("/notes", nil, apps.CallResponseTypeOK)
+	secondIndex := strings.Index(callResponse.Text, "[second message]("+at.mattermost.URL+"/_redirect/pl/"+second.Id+")")
+	firstIndex := strings.Index(callResponse.Text, "[first message]("+at.mattermost.URL+"/_redirect/pl/"+first.Id+")")
+	if secondIndex < 0 || firstIndex < secondIndex {
+		t.Errorf("notes are not listed newest first with permalinks: %q", callResponse.Text)
+	}
+}
+
+func TestSaveNoteRequiresAPost(t *testing.T) {
+	at := newAppTest(t)
+	at.mustFail("/post/save-note", nil, "menu of a post")
+}
+
+func TestRemindAboutPost(t *testing.T) {
+	at := newAppTest(t)
+	post := at.newPost("remember the milk")
+	statusCode, callResponse := at.postMenuCall("/post/remind", post, map[string]interface{}{"when": "30m"})
+	if statusCode != http.StatusOK || callResponse.Type != apps.CallResponseTypeOK {
+		t.Fatalf("remind returned status %d and %s response %q", statusCode, callResponse.Type, callResponse.Text)
+	}
+	index := make([]string, 0)
+	if !at.mattermost.kvGet(t, reminderKVPrefix, kvIndexKey, &index) || len(index) != 1 {
+		t.Fatalf("unexpected reminder index %v", index)
+	}
+	// reminders are not weather report schedules
+	callResponse = at.mustCall("/weather/schedules", nil, apps.CallResponseTypeOK)
+	if strings.Contains(callResponse.Text, index[0]) {
+		t.Errorf("schedule list includes the reminder: %q", callResponse.Text)
+	}
+	waitForSchedulerLoad(t)
+
+	jobScheduler.tick(time.Now().Add(10 * time.Minute))
+	if len(at.mattermost.posts()) != 0 {
+		t.Fatalf("the reminder was sent early")
+	}
+	jobScheduler.tick(time.Now().Add(31 * time.Minute))
+	posts := at.mattermost.posts()
+	if len(posts) != 1 {
+		t.Fatalf("got %d posts, want the reminder", len(posts))
+	}
+	dmChannelID := model.GetDMNameFromIds(testBotUserID, at.user.Id)
+	if posts[0].ChannelId != dmChannelID || !strings.Contains(posts[0].Message, "> remember the milk") || !strings.Contains(posts[0].Message, "/_redirect/pl/"+post.Id) {
+		t.Errorf("unexpected reminder %+v", posts[0])
+	}
+	if at.mattermost.kvGet(t, reminderKVPrefix, index[0], &scheduledJob{}) {
+		t.Errorf("reminder %s was not deleted after it was sent", index[0])
+	}
+	jobScheduler.tick(time.Now().Add(time.Hour))
+	if len(at.mattermost.posts()) != 1 {
+		t.Errorf("the reminder was sent more than once")
+	}
+}
+
+func TestRemindersAreDroppedAfterRepeatedFailures(t *testing.T) {
+	at := newAppTest(t)
+	at.postMenuCall("/post/remind", at.newPost("remember the milk"), map[string]interface{}{"when": "30m"})
+	index := make([]string, 0)
+	if !at.mattermost.kvGet(t, reminderKVPrefix, kvIndexKey, &index) || len(index) != 1 {
+		t.Fatalf("unexpected reminder index %v", index)
+	}
+	waitForSchedulerLoad(t)
+	at.mattermost.Failures[http.MethodPost+" /api/v4/posts"] = http.StatusServiceUnavailable
+	due := time.Now().Add(31 * time.Minute)
+	for attempt := 1; attempt < reminderMaxAttempts; attempt++ {
+		jobScheduler.tick(due)
+		if !at.mattermost.kvGet(t, reminderKVPrefix, index[0], &scheduledJob{}) {
+			t.Fatalf("the reminder was dropped after %d attempts", attempt)
+		}
+	}
+	jobScheduler.tick(due)
+	if at.mattermost.kvGet(t, reminderKVPrefix, index[0], &scheduledJob{}) {
+		t.Errorf("the reminder was kept after %d attempts", reminderMaxAttempts)
+	}
+	jobScheduler.mutex.Lock()
+	_, scheduled := jobScheduler.jobs[index[0]]
+	jobScheduler.mutex.Unlock()
+	if scheduled {
+		t.Errorf("the reminder is still scheduled")
+	}
+}
+
+func TestRemindAboutPostRejectsInvalidTimes(t *testing.T) {
+	at := newAppTest(t)
+	_, callResponse := at.postMenuCall("/post/remind", at.newPost("hello"), map[string]interface{}{"when": "tomorrow-ish"})
+	if callResponse.Type != apps.CallResponseTypeError || callResponse.Data == nil {
+		t.Errorf("got %s response %q, want a field error", callResponse.Type, callResponse.Text)
+	}
+}
+
+func TestParseReminderTime(t *testing.T) {
+	paris, err := time.LoadLocation("Europe/Paris")
+	if err != nil {
+		t.Skipf("timezone database unavailable: %s", err.Error())
+	}
+	now := time.Date(2022, time.March, 1, 12, 0, 0, 0, time.UTC)
+	tests := []struct {
+		when string
+		want time.Time
+	}{
+		{"90m", now.Add(90 * time.Minute)},
+		{"14:30", time.Date(2022, time.March, 1, 14, 30, 0, 0, paris)},
+		{"09:00", time.Date(2022, time.March, 2, 9, 0, 0, 0, paris)},
+	}
+	for _, test := range tests {
+		got, err := parseReminderTime(test.when, now, paris)
+		if err != nil || !got.Equal(test.want) {
+			t.Errorf("parseReminderTime(%q) = %s, %v, want %s", test.when, got, err, test.want)
+		}
+	}
+	for _, when := range []string{"", "-5m", "25:00", "soon"} {
+		if _, err := parseReminderTime(when, now, paris); err == nil {
+			t.Errorf("parseReminderTime(%q) did not fail", when)
+		}
+	}
+}
+
+func TestSharePost(t *testing.T) {
+	at := newAppTest(t)
+	post := at.newPost("line one\nline two")
+	targetChannelID := model.NewId()
+	statusCode, callResponse := at.postMenuCall("/post/share", post, map[string]interface{}{
+		"channel": map[string]interface{}{"label": "Off-Topic", "value": targetChannelID},
+		"comment": "worth a read",
+	})
+	if statusCode != http.StatusOK || callResponse.Type != apps.CallResponseTypeOK {
+		t.Fatalf("share returned status %d and %s response %q", statusCode, callResponse.Type, callResponse.Text)
+	}
+	if !containsString(at.mattermost.ChannelMembers[targetChannelID], testBotUserID) {
+		t.Errorf("the bot was not added to the channel")
+	}
+	posts := at.mattermost.posts()
+	if len(posts) != 1 || posts[0].ChannelId != targetChannelID {
+		t.Fatalf("unexpected posts %v", posts)
+	}
+	want := "@alice shared [a message](" + at.mattermost.URL + "/_redirect/pl/" + post.Id + "):\n> line one\n> line two\n\nworth a read"
+	if posts[0].Message != want {
+		t.Errorf("got message %q, want %q", posts[0].Message, want)
+	}
+}
+
+func TestSharePostNeutralizesMentions(t *testing.T) {
+	at := newAppTest(t)
+	post := at.newPost("@channel look, @bob.smith wrote to bob@example.com")
+	at.postMenuCall("/post/share", post, map[string]interface{}{
+		"channel": map[string]interface{}{"label": "Off-Topic", "value": model.NewId()},
+		"comment": "cc @here",
+	})
+	posts := at.mattermost.posts()
+	if len(posts) != 1 {
+		t.Fatalf("unexpected posts %v", posts)
+	}
+	want := "> @\u200bchannel look, @\u200bbob.smith wrote to bob@example.com\n\ncc @\u200bhere"
+	if !strings.HasSuffix(posts[0].Message, want) {
+		t.Errorf("got message %q, want it to end with %q", posts[0].Message, want)
+	}
+}
+
+func TestScheduledReportsLinkAssetsAbsolutely(t *testing.T) {
+	at := newAppTest(t)
+	at.mustCall("/weather/schedule", map[string]interface{}{
+		"frequency": "daily",
+		"time":      "08:30",
+		"location":  "Paris",
+	}, apps.CallResponseTypeOK)
+	waitForSchedulerLoad(t)
+	jobScheduler.tick(time.Date(2022, time.March, 1, 8, 30, 0, 0, time.UTC))
+	posts := at.mattermost.posts()
+	if len(posts) != 1 {
+		t.Fatalf("got %d posts, want the report", len(posts))
+	}
+	if !strings.Contains(posts[0].Message, "![image]("+assetURL(at.context(), "icon.png")+")") {
+		t.Errorf("the report does not link the icon absolutely:\n%s", posts[0].Message)
+	}
+}
@@ -0,0 +1,112 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/mattermost/mattermost-plugin-apps/apps"
+)
+
+const (
+	installInfoKVPrefix = "app"
+	installInfoKVKey    = "install-info"
+)
+
+// installInfo records who installed the app, when, and which version
+type installInfo struct {
+	InstalledByID       string    `json:"installed_by_id"`
+	InstalledByUsername string    `json:"installed_by_username"`
+	InstalledAt         time.Time `json:"installed_at"`
+	Version             string    `json:"version"`
+}
+
+// gettingStartedMessage builds the Markdown message that is sent to the installing admin
+func gettingStartedMessage(botUsername string) string {
+	trigger := fmt.Sprintf("/%s", appManifest.AppID)
+	commands := commandList(appCommandBindings(), trigger, false)
+	steps := []string{
+		fmt.Sprintf("1. Add @%s to any channel where it should post messages.", botUsername),
+		fmt.Sprintf("2. Use `%s sub` to subscribe the app to server events.", trigger),
+		fmt.Sprintf("3. Use `%s info` at any time to review this installation.", trigger),
+	}
+	return fmt.Sprintf(
+		"#### Thanks for installing %s!\n\n##### Commands\n%s\n##### Configuration\n%s\n",
+		appManifest.DisplayName,
+		commands,
+		strings.Join(steps, "\n"),
+	)
+}
+
+func appInstalled(w http.ResponseWriter, r *http.Request) {
+	callRequest, err := getCallRequest(r)
+	if err != nil {
+		log.Printf("appInstalled(): %s\n", err.Error())
+		sendErrorResponse(w, err)
+		return
+	}
+	log.Println("app installed")
+	info := installInfo{
+		InstalledAt: time.Now().UTC(),
+		Version:     string(appManifest.Version),
+	}
+	botUsername := string(appManifest.AppID)
+	if callRequest.Context.App != nil {
+		info.Version = string(callRequest.Context.App.Version)
+		if callRequest.Context.App.BotUsername != "" {
+			botUsername = callRequest.Context.App.BotUsername
+		}
+	}
+	if callRequest.Context.ActingUser != nil {
+		info.InstalledByID = callRequest.Context.ActingUser.Id
+		info.InstalledByUsername = callRequest.Context.ActingUser.Username
+	}
+	clt := asBot(r.Context(), callRequest.Context)
+	// a failure to record or announce the install must not fail the install itself
+	_, err = clt.KVSet(installInfoKVPrefix, installInfoKVKey, info)
+	if err != nil {
+		log.Printf("appInstalled(): error storing install info: %s\n", err.Error())
+	}
+	if info.InstalledByID != "" {
+		_, err = clt.DM(info.InstalledByID, "%s", gettingStartedMessage(botUsername))
+		if err != nil {
+			log.Printf("appInstalled(): error sending getting started message: %s\n", err.Error())
+		}
+	}
+	sendCallResponse(w, apps.NewTextResponse("successfully installed app"))
+}
+
+func appInfo(w http.ResponseWriter, r *http.Request) {
+	callRequest, err := getCallRequest(r)
+	if err != nil {
+		log.Printf("appInfo(): %s\n", err.Error())
+		sendErrorResponse(w, err)
+		return
+	}
+	info := installInfo{}
+	clt := asBot(r.Context(), callRequest.Context)
+	err = clt.KVGet(installInfoKVPrefix, installInfoKVKey, &info)
+	if err != nil {
+		err = newUpstreamError(err, "error reading install info")
+		sendErrorResponse(w, err)
+		return
+	}
+	if info.InstalledAt.IsZero() {
+		sendCallResponse(w, apps.NewTextResponse("no install information has been recorded"))
+		return
+	}
+	installedBy := info.InstalledByID
+	if info.InstalledByUsername != "" {
+		installedBy = fmt.Sprintf("@%s", info.InstalledByUsername)
+	}
+	responseText := fmt.Sprintf(
+		"#### %s\n- Version: %s\n- Installed by: %s\n- Installed at: %s\n",
+		appManifest.DisplayName,
+		info.Version,
+		installedBy,
+		info.InstalledAt.Format(time.RFC1123),
+	)
+	sendCallResponse(w, apps.NewTextResponse("%s", responseText))
+}
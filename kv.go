@@ -0,0 +1,63 @@
+package main
+
+import "sync"
+
+// kvIndexKey is the key, within a KV prefix, of the list of IDs stored under that prefix
+const kvIndexKey = "index"
+
+// kvUpdateMutex serializes the read-modify-write updates of KV values that hold a collection, such as an
+// index or a user's notes, so that concurrent calls do not lose each other's entries. The KV store has no
+// compare-and-set, so this only holds within one process: with several replicas of the app, concurrent
+// updates of the same value can still lose entries.
+var kvUpdateMutex sync.Mutex
+
+// getKVIndex returns the list of IDs stored under the supplied KV prefix
+func getKVIndex(clt mattermostClient, prefix string) ([]string, error) {
+	index := make([]string, 0)
+	err := clt.KVGet(prefix, kvIndexKey, &index)
+	if err != nil {
+		return nil, err
+	}
+	return index, nil
+}
+
+// addToKVIndex adds an ID to the index of the supplied KV prefix
+func addToKVIndex(clt mattermostClient, prefix string, id string) error {
+	kvUpdateMutex.Lock()
+	defer kvUpdateMutex.Unlock()
+	index, err := getKVIndex(clt, prefix)
+	if err != nil {
+		return err
+	}
+	for _, indexID := range index {
+		if indexID == id {
+			return nil
+		}
+	}
+	_, err = clt.KVSet(prefix, kvIndexKey, append(index, id))
+	return err
+}
+
+// removeFromKVIndex removes an ID from the index of the supplied KV prefix and reports whether it was present
+func removeFromKVIndex(clt mattermostClient, prefix string, id string) (bool, error) {
+	kvUpdateMutex.Lock()
+	defer kvUpdateMutex.Unlock()
+	index, err := getKVIndex(clt, prefix)
+	if err != nil {
+		return false, err
+	}
+	newIndex := make([]string, 0, len(index))
+	for _, indexID := range index {
+		if indexID != id {
+			newIndex = append(newIndex, indexID)
+		}
+	}
+	if len(newIndex) == len(index) {
+		return false, nil
+	}
+	_, err = clt.KVSet(prefix, kvIndexKey, newIndex)
+	if err != nil {
+		return false, err
+	}
+	return true, nil
+}
@@ -0,0 +1,40 @@
+package main
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+)
+
+func TestKVIndex(t *testing.T) {
+	fake := newFakeMattermostClient(testBotUserID)
+	for _, id := range []string{"a", "b", "a"} {
+		err := addToKVIndex(fake, "prefix", id)
+		if err != nil {
+			t.Fatalf("error adding %s: %s", id, err.Error())
+		}
+	}
+	index, err := getKVIndex(fake, "prefix")
+	if err != nil || !reflect.DeepEqual(index, []string{"a", "b"}) {
+		t.Errorf("got index %v and error %v, want [a b]", index, err)
+	}
+	removed, err := removeFromKVIndex(fake, "prefix", "a")
+	if err != nil || !removed {
+		t.Errorf("got %t and error %v removing a, want true", removed, err)
+	}
+	removed, err = removeFromKVIndex(fake, "prefix", "a")
+	if err != nil || removed {
+		t.Errorf("got %t and error %v removing a again, want false", removed, err)
+	}
+	index, _ = getKVIndex(fake, "prefix")
+	if !reflect.DeepEqual(index, []string{"b"}) {
+		t.Errorf("got index %v, want [b]", index)
+	}
+	fake.Errors["KVGet"] = errors.New("unavailable")
+	if addToKVIndex(fake, "prefix", "c") == nil {
+		t.Errorf("a failed read was not reported")
+	}
+	if _, err = removeFromKVIndex(fake, "prefix", "b"); err == nil {
+		t.Errorf("a failed read was not reported")
+	}
+}
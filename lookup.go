@@ -0,0 +1,201 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"log"
+	"net/http"
+	"sort"
+	"strings"
+
+	"github.com/mattermost/mattermost-plugin-apps/apps"
+	"github.com/mattermost/mattermost-plugin-apps/utils/httputils"
+	"github.com/mattermost/mattermost-server/v6/model"
+)
+
+// lookupDefaultMaxItems caps the number of options returned by a lookup that does not set MaxItems
+const lookupDefaultMaxItems = 20
+
+// lookupSource produces the candidate options of a dynamic select; sources that search remotely may use the
+// query to narrow the candidates, and every candidate is filtered and ranked by the lookup afterwards
+type lookupSource interface {
+	options(ctx context.Context, callRequest *apps.CallRequest, query string, limit int) ([]apps.SelectOption, error)
+}
+
+// staticLookupSource offers a fixed list of options
+type staticLookupSource []apps.SelectOption
+
+func (s staticLookupSource) options(_ context.Context, _ *apps.CallRequest, _ string, _ int) ([]apps.SelectOption, error) {
+	return s, nil
+}
+
+// channelKVLookupSource offers the options stored under the current channel's ID in a KV prefix, such as
+// the schedules of the channel, so that a lookup reads a single key and never offers another channel's items
+type channelKVLookupSource struct {
+	Prefix string
+}
+
+func (s channelKVLookupSource) options(ctx context.Context, callRequest *apps.CallRequest, _ string, _ int) ([]apps.SelectOption, error) {
+	if callRequest.Context.Channel == nil {
+		return nil, errors.New("channel not expanded")
+	}
+	options := make([]apps.SelectOption, 0)
+	err := asBot(ctx, callRequest.Context).KVGet(s.Prefix, callRequest.Context.Channel.Id, &options)
+	if err != nil {
+		return nil, err
+	}
+	return options, nil
+}
+
+// usersLookupSource offers the Mattermost users matching the query
+type usersLookupSource struct{}
+
+func (s usersLookupSource) options(ctx context.Context, callRequest *apps.CallRequest, query string, limit int) ([]apps.SelectOption, error) {
+	users, err := lookupClient(ctx, callRequest.Context).AutocompleteUsers(query, limit)
+	if err != nil {
+		return nil, err
+	}
+	options := make([]apps.SelectOption, 0, len(users))
+	for _, user := range users {
+		options = append(options, apps.SelectOption{
+			Label: user.Username,
+			Value: user.Id,
+		})
+	}
+	return options, nil
+}
+
+// channelsLookupSource offers the channels of the current team matching the query
+type channelsLookupSource struct{}
+
+func (s channelsLookupSource) options(ctx context.Context, callRequest *apps.CallRequest, query string, _ int) ([]apps.SelectOption, error) {
+	if callRequest.Context.Team == nil {
+		return nil, errors.New("team not expanded")
+	}
+	channels, err := lookupClient(ctx, callRequest.Context).AutocompleteChannelsForTeam(callRequest.Context.Team.Id, query)
+	if err != nil {
+		return nil, err
+	}
+	options := make([]apps.SelectOption, 0, len(channels))
+	for _, channel := range channels {
+		if channel.Type == model.ChannelTypeDirect || channel.Type == model.ChannelTypeGroup {
+			continue
+		}
+		options = append(options, apps.SelectOption{
+			Label: channel.DisplayName,
+			Value: channel.Id,
+		})
+	}
+	return options, nil
+}
+
+// lookupSourceFunc lets a function, such as a handler's callback, serve as the source of a dynamic select
+type lookupSourceFunc func(ctx context.Context, callRequest *apps.CallRequest, query string, limit int) ([]apps.SelectOption, error)
+
+func (f lookupSourceFunc) options(ctx context.Context, callRequest *apps.CallRequest, query string, limit int) ([]apps.SelectOption, error) {
+	return f(ctx, callRequest, query, limit)
+}
+
+// lookupClient searches as the acting user when the call expands their token, so that results respect
+// their permissions, and as the bot otherwise
+func lookupClient(ctx context.Context, appContext apps.Context) mattermostClient {
+	if appContext.ActingUserAccessToken != "" {
+		return asActingUser(ctx, appContext)
+	}
+	return asBot(ctx, appContext)
+}
+
+// dynamicLookup serves the lookup call of a dynamic select from a data source
+type dynamicLookup struct {
+	Path     string
+	Source   lookupSource
+	MaxItems int
+	Expand   *apps.Expand
+}
+
+// register adds the lookup's route to the mux
+func (l *dynamicLookup) register(mux *httputils.Handler) {
+	handleCall(mux, l.Path, l.lookup)
+}
+
+// call returns the call to use as a field's SelectDynamicLookup
+func (l *dynamicLookup) call() *apps.Call {
+	call := apps.NewCall(l.Path)
+	if l.Expand != nil {
+		call = call.WithExpand(*l.Expand)
+	}
+	return call
+}
+
+func (l *dynamicLookup) lookup(w http.ResponseWriter, r *http.Request) {
+	callRequest, err := getCallRequest(r)
+	if err != nil {
+		log.Printf("dynamicLookup.lookup(): %s\n", err.Error())
+		sendErrorResponse(w, err)
+		return
+	}
+	maxItems := l.MaxItems
+	if maxItems <= 0 {
+		maxItems = lookupDefaultMaxItems
+	}
+	query := strings.TrimSpace(callRequest.Query)
+	options, err := l.Source.options(r.Context(), callRequest, query, maxItems)
+	if err != nil {
+		err = newUpstreamError(err, "error looking up options")
+		sendErrorResponse(w, err)
+		return
+	}
+	sendCallResponse(w, apps.NewLookupResponse(rankOptions(options, query, maxItems)))
+}
+
+// optionRank scores how well an option matches the query; lower is better and -1 means no match
+func optionRank(option apps.SelectOption, query string) int {
+	label := strings.ToLower(option.Label)
+	value := strings.ToLower(option.Value)
+	switch {
+	case label == query || value == query:
+		return 0
+	case strings.HasPrefix(label, query) || strings.HasPrefix(value, query):
+		return 1
+	case strings.Contains(label, " "+query):
+		return 2
+	case strings.Contains(label, query) || strings.Contains(value, query):
+		return 3
+	default:
+		return -1
+	}
+}
+
+// rankOptions filters the options by the query, orders them by how well they match and caps their number
+func rankOptions(options []apps.SelectOption, query string, maxItems int) []apps.SelectOption {
+	query = strings.ToLower(query)
+	type rankedOption struct {
+		option apps.SelectOption
+		rank   int
+	}
+	ranked := make([]rankedOption, 0, len(options))
+	for _, option := range options {
+		rank := 0
+		if query != "" {
+			rank = optionRank(option, query)
+		}
+		if rank >= 0 {
+			ranked = append(ranked, rankedOption{option, rank})
+		}
+	}
+	// an empty query keeps the source's order
+	sort.SliceStable(ranked, func(i, j int) bool {
+		if ranked[i].rank != ranked[j].rank {
+			return ranked[i].rank < ranked[j].rank
+		}
+		return query != "" && strings.ToLower(ranked[i].option.Label) < strings.ToLower(ranked[j].option.Label)
+	})
+	if len(ranked) > maxItems {
+		ranked = ranked[:maxItems]
+	}
+	result := make([]apps.SelectOption, 0, len(ranked))
+	for _, item := range ranked {
+		result = append(result, item.option)
+	}
+	return result
+}
@@ -0,0 +1,91 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+
+	"github.com/mattermost/mattermost-plugin-apps/apps"
+)
+
+func TestRankOptions(t *testing.T) {
+	options := []apps.SelectOption{
+		{Label: "Weekly report", Value: "weekly"},
+		{Label: "Report", Value: "report"},
+		{Label: "Daily Report", Value: "daily"},
+		{Label: "Reporting", Value: "reporting"},
+		{Label: "Toronto", Value: "yyz"},
+	}
+	tests := []struct {
+		name     string
+		query    string
+		maxItems int
+		want     []string
+	}{
+		{"empty query keeps the source's order", "", 10, []string{"weekly", "report", "daily", "reporting", "yyz"}},
+		{"empty query is capped", "", 2, []string{"weekly", "report"}},
+		{"exact match, prefix, word start, then substring", "report", 10, []string{"report", "reporting", "daily", "weekly"}},
+		{"case-insensitive", "REPORT", 10, []string{"report", "reporting", "daily", "weekly"}},
+		{"ties ordered by label", "r", 10, []string{"report", "reporting", "daily", "weekly", "yyz"}},
+		{"value matches", "yy", 10, []string{"yyz"}},
+		{"substring", "ont", 10, []string{"yyz"}},
+		{"no match", "paris", 10, []string{}},
+		{"capped after ranking", "report", 2, []string{"report", "reporting"}},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			got := make([]string, 0)
+			for _, option := range rankOptions(options, test.query, test.maxItems) {
+				got = append(got, option.Value)
+			}
+			if !reflect.DeepEqual(got, test.want) {
+				t.Errorf("got %v, want %v", got, test.want)
+			}
+		})
+	}
+}
+
+func TestDynamicLookupServesASourceFunc(t *testing.T) {
+	var gotQuery string
+	var gotLimit int
+	lookup := &dynamicLookup{
+		Path:     "/lookup",
+		MaxItems: 2,
+		Source: lookupSourceFunc(func(_ context.Context, _ *apps.CallRequest, query string, limit int) ([]apps.SelectOption, error) {
+			gotQuery, gotLimit = query, limit
+			if query == "fail" {
+				return nil, errors.New("unavailable")
+			}
+			return []apps.SelectOption{{Label: "Alpha", Value: "a"}, {Label: "Alphabet", Value: "b"}, {Label: "Alps", Value: "c"}}, nil
+		}),
+	}
+	send := func(query string) apps.CallResponse {
+		body, _ := json.Marshal(apps.CallRequest{Query: query})
+		recorder := httptest.NewRecorder()
+		lookup.lookup(recorder, httptest.NewRequest(http.MethodPost, "/lookup", bytes.NewReader(body)))
+		callResponse := apps.CallResponse{}
+		err := json.Unmarshal(recorder.Body.Bytes(), &callResponse)
+		if err != nil {
+			t.Fatalf("error decoding response: %s", err.Error())
+		}
+		return callResponse
+	}
+
+	callResponse := send(" alp ")
+	if gotQuery != "alp" || gotLimit != 2 {
+		t.Errorf("the source got the query %q and limit %d, want \"alp\" and 2", gotQuery, gotLimit)
+	}
+	data, _ := json.Marshal(callResponse.Data)
+	if want := `{"items":[{"label":"Alpha","value":"a"},{"label":"Alphabet","value":"b"}]}`; string(data) != want {
+		t.Errorf("got data %s, want %s", data, want)
+	}
+	callResponse = send("fail")
+	if callResponse.Type != apps.CallResponseTypeError {
+		t.Errorf("got %s response %q, want an error", callResponse.Type, callResponse.Text)
+	}
+}
@@ -1,38 +1,46 @@
 package main
 
 import (
-	_ "embed"
+	"context"
 	"encoding/json"
 	"errors"
 	"fmt"
 	"io"
 	"log"
+	"math"
 	"net/http"
 	"net/http/httputil"
 	"os"
+	"regexp"
+	"strconv"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/mattermost/mattermost-plugin-apps/apps"
-	"github.com/mattermost/mattermost-plugin-apps/apps/appclient"
 	"github.com/mattermost/mattermost-plugin-apps/utils/httputils"
 	"github.com/mattermost/mattermost-server/v6/model"
 )
 
+const (
+	// sendStylePlain, sendStyleQuote and sendStyleCard are the message styles of the send form
+	sendStylePlain = "plain"
+	sendStyleQuote = "quote"
+	sendStyleCard  = "card"
+	// sendDeliveryDM and sendDeliveryChannel are the ways the send form delivers a message
+	sendDeliveryDM      = "dm"
+	sendDeliveryChannel = "channel"
+	// weatherDefaultLocation is the location of the canned weather reports
+	weatherDefaultLocation = "Toronto, Ontario"
+	weatherUnitsCelsius    = "celsius"
+	weatherUnitsFahrenheit = "fahrenheit"
+)
+
 type weatherResponseStruct struct {
 	ResponseType string `json:"response_type"`
 	Text         string `json:"text"`
 }
 
-//go:embed static/icon.png
-var iconData []byte
-
-//go:embed static/icon-info.png
-var iconInfoData []byte
-
-//go:embed static/icon-head.png
-var iconHeadData []byte
-
 var (
 	weatherResponseData = &weatherResponseStruct{
 		ResponseType: "in_channel",
@@ -64,6 +72,38 @@ var (
 ---`,
 	}
 
+	// weatherForm holds the arguments of the weather day and week commands
+	weatherForm = apps.Form{
+		Fields: []apps.Field{
+			{
+				Name:                 "location",
+				Label:                "location",
+				Type:                 apps.FieldTypeText,
+				TextSubtype:          apps.TextFieldSubtypeInput,
+				Description:          "The location to report the weather for",
+				AutocompletePosition: 1,
+			},
+			{
+				Name:        "units",
+				Label:       "units",
+				Type:        apps.FieldTypeStaticSelect,
+				Description: "The temperature units",
+				SelectStaticOptions: []apps.SelectOption{
+					{
+						Label: "Celsius",
+						Value: weatherUnitsCelsius,
+					},
+					{
+						Label: "Fahrenheit",
+						Value: weatherUnitsFahrenheit,
+					},
+				},
+			},
+		},
+	}
+
+	weatherCelsiusPattern = regexp.MustCompile(`(-?\d+) °C`)
+
 	appManifest = apps.Manifest{
 		AppID:       apps.AppID("hello-world"),
 		Version:     apps.AppVersion("0.1.0"),
@@ -72,6 +112,9 @@ var (
 		Description: "A starter Mattermost App",
 		RequestedPermissions: apps.Permissions{
 			apps.PermissionActAsBot,
+			apps.PermissionActAsUser,
+			apps.PermissionRemoteOAuth2,
+			apps.PermissionRemoteWebhooks,
 		},
 		RequestedLocations: apps.Locations{
 			apps.LocationChannelHeader,
@@ -79,14 +122,20 @@ var (
 			apps.LocationPostMenu,
 		},
 		Deploy: apps.Deploy{
+			// UseJWT is set by setupCallAuthentication when APP_SECRET is set
 			HTTP: &apps.HTTP{
 				RootURL: "http://mm-apps-starter-go:4000",
 			},
 		},
 		OnInstall: apps.NewCall("/installed").WithExpand(apps.Expand{
-			App: apps.ExpandSummary,
+			App:        apps.ExpandSummary,
+			ActingUser: apps.ExpandSummary,
 		}),
-		OnUninstall: apps.NewCall("/uninstalled"),
+		OnUninstall:           apps.NewCall("/uninstalled"),
+		GetOAuth2ConnectURL:   apps.DefaultGetOAuth2ConnectURL.PartialCopy(),
+		OnOAuth2Complete:      apps.DefaultOnOAuth2Complete.PartialCopy(),
+		OnRemoteWebhook:       apps.NewCall(webhookCallPath),
+		RemoteWebhookAuthType: apps.NoAuth,
 	}
 
 	appBindings = []apps.Binding{
@@ -118,31 +167,29 @@ var (
 		},
 		{
 			Location: apps.LocationCommand,
-			Bindings: []apps.Binding{
+			Bindings: commandBindings([]apps.Binding{
 				{
 					Location:    "weather",
 					Label:       "weather",
 					Description: "Show the weather conditions for today or the next week",
-					Hint:        "[day|week]",
-					Bindings: []apps.Binding{
+					Bindings: append([]apps.Binding{
 						{
 							Location:    "day",
 							Label:       "day",
 							Description: "Show the weather conditions for today",
-							Submit:      apps.NewCall("/weather/day"),
+							Form:        withSubmit(weatherForm, apps.NewCall("/weather/day")),
 						},
 						{
 							Location:    "week",
 							Label:       "week",
 							Description: "Show the weather conditions for the next week",
-							Submit:      apps.NewCall("/weather/week"),
+							Form:        withSubmit(weatherForm, apps.NewCall("/weather/week")),
 						},
-					},
+					}, scheduleBindings...),
 				},
 				{
 					Location:    "sub",
 					Label:       "sub",
-					Hint:        "[eventname] [teamid] [channelid]",
 					Description: "Subscribe to an event",
 					Form: &apps.Form{
 						Title:  "Subscribe to an event",
@@ -150,62 +197,95 @@ var (
 						Icon:   "icon.png",
 						Fields: []apps.Field{
 							{
-								Name:        "eventname",
-								Label:       "eventname",
-								Type:        apps.FieldTypeText,
-								TextSubtype: apps.TextFieldSubtypeInput,
-								Description: "The name of the event to subscribe to",
-								IsRequired:  true,
+								Name:                 "eventname",
+								Label:                "eventname",
+								Type:                 apps.FieldTypeText,
+								TextSubtype:          apps.TextFieldSubtypeInput,
+								Description:          "The name of the event to subscribe to",
+								IsRequired:           true,
+								AutocompletePosition: 1,
 							},
 							{
-								Name:        "teamid",
-								Label:       "teamid",
-								Type:        apps.FieldTypeText,
-								TextSubtype: apps.TextFieldSubtypeInput,
-								Description: "The ID of the team",
+								Name:                 "teamid",
+								Label:                "teamid",
+								Type:                 apps.FieldTypeText,
+								TextSubtype:          apps.TextFieldSubtypeInput,
+								Description:          "The ID of the team",
+								AutocompletePosition: 2,
 							},
 							{
-								Name:        "channelid",
-								Label:       "channelid",
-								Type:        apps.FieldTypeText,
-								TextSubtype: apps.TextFieldSubtypeInput,
-								Description: "The ID of the channel",
+								Name:                 "channelid",
+								Label:                "channelid",
+								Type:                 apps.FieldTypeText,
+								TextSubtype:          apps.TextFieldSubtypeInput,
+								Description:          "The ID of the channel",
+								AutocompletePosition: 3,
 							},
+							subscribeAsUserField,
 						},
-						Submit: apps.NewCall("/sub"),
+						Submit: apps.NewCall("/sub").WithExpand(subscribeExpand),
 					},
 				},
 				{
 					Location:    "unsub",
 					Label:       "unsub",
-					Hint:        "[eventname]",
 					Description: "Unsubscribe from an event",
 					Form: &apps.Form{
 						Fields: []apps.Field{
 							{
-								Name:        "eventname",
-								Label:       "eventname",
-								Type:        apps.FieldTypeText,
-								TextSubtype: apps.TextFieldSubtypeInput,
-								Description: "The name of the event to unsubscribe from",
-								IsRequired:  true,
+								Name:                 "eventname",
+								Label:                "eventname",
+								Type:                 apps.FieldTypeText,
+								TextSubtype:          apps.TextFieldSubtypeInput,
+								Description:          "The name of the event to unsubscribe from",
+								IsRequired:           true,
+								AutocompletePosition: 1,
 							},
+							subscribeAsUserField,
 						},
-						Submit: apps.NewCall("/unsub"),
+						Submit: apps.NewCall("/unsub").WithExpand(subscribeExpand),
 					},
 				},
-			},
+				{
+					Location:    "info",
+					Label:       "info",
+					Description: "Show information about this installation of the app",
+					Submit:      apps.NewCall("/info"),
+				},
+				{
+					Location:    "connect",
+					Label:       "connect",
+					Description: "Connect your account to the remote OAuth2 provider",
+					Submit:      apps.NewCall("/connect").WithExpand(oauth2Expand),
+				},
+				{
+					Location:    "disconnect",
+					Label:       "disconnect",
+					Description: "Disconnect your account from the remote OAuth2 provider",
+					Submit:      apps.NewCall("/disconnect").WithExpand(oauth2Expand).ExpandActingUserClient(),
+				},
+				{
+					Location:    "configure-oauth2",
+					Label:       "configure-oauth2",
+					Description: "Configure the remote OAuth2 provider (system administrators only)",
+					Form:        &configureOAuth2Form,
+				},
+				webhookBinding,
+				notesBinding,
+				messagesBinding,
+				onboardingWizard.binding("onboard", "Walk through setting up your account"),
+			}),
 		},
 		{
 			Location: apps.LocationPostMenu,
-			Bindings: []apps.Binding{
+			Bindings: append([]apps.Binding{
 				{
 					Location: "weather",
 					Icon:     "icon.png",
 					Label:    "Show weather conditions",
 					Submit:   apps.NewCall("/weather"),
 				},
-			},
+			}, postActionBindings...),
 		},
 	}
 
@@ -217,34 +297,84 @@ var (
 		},
 		Fields: []apps.Field{
 			{
-				Type:  apps.FieldTypeText,
-				Name:  "message",
-				Label: "Message",
+				Type:          apps.FieldTypeText,
+				Name:          "message",
+				Label:         "Message",
+				IsRequired:    true,
+				TextMaxLength: 1000,
 			},
 			{
-				Type:          apps.FieldTypeUser,
-				Name:          "user",
-				Label:         "User",
-				SelectRefresh: true,
+				Type:       apps.FieldTypeUser,
+				Name:       "user",
+				Label:      "User",
+				IsRequired: true,
+			},
+			{
+				Type:        apps.FieldTypeStaticSelect,
+				Name:        "option",
+				Label:       "Style",
+				Description: "How the message is presented",
+				SelectStaticOptions: []apps.SelectOption{
+					{
+						Label: "Plain",
+						Value: sendStylePlain,
+					},
+					{
+						Label: "Quote",
+						Value: sendStyleQuote,
+					},
+					{
+						Label: "Card",
+						Value: sendStyleCard,
+					},
+				},
 			},
 			{
 				Type:  apps.FieldTypeStaticSelect,
-				Name:  "option",
-				Label: "Option",
+				Name:  "delivery",
+				Label: "Delivery",
+				Value: apps.SelectOption{
+					Label: "Direct message",
+					Value: sendDeliveryDM,
+				},
 				SelectStaticOptions: []apps.SelectOption{
 					{
-						Label: "Option One",
-						Value: "option_1",
+						Label: "Direct message",
+						Value: sendDeliveryDM,
 					},
 					{
-						Label: "Option Two",
-						Value: "option_2",
+						Label: "Post in this channel with a mention",
+						Value: sendDeliveryChannel,
 					},
 				},
 			},
 		},
-		Submit: &apps.Call{
-			Path: "/modal-submit",
+		Submit: apps.NewCall("/modal-submit").WithExpand(apps.Expand{
+			ActingUser: apps.ExpandSummary,
+			Channel:    apps.ExpandID,
+			Team:       apps.ExpandID,
+		}),
+	}
+
+	sendFormDependencies = refreshableForm{
+		Form: sendForm,
+		Dependencies: []fieldDependency{
+			{
+				Field:     "message",
+				DependsOn: []string{"user"},
+				When:      valueSet("user"),
+				Prefill: func(values map[string]interface{}) interface{} {
+					user, _ := values["user"].(map[string]interface{})
+					label, _ := user["label"].(string)
+					return fmt.Sprintf("Hello, %s!", label)
+				},
+			},
+			{
+				Field:     "option",
+				DependsOn: []string{"user"},
+				When:      valueNotSet("user"),
+				Disable:   true,
+			},
 		},
 	}
 
@@ -253,12 +383,28 @@ var (
 		Icon:  "icon-info.png",
 		Fields: []apps.Field{
 			{
-				Type:  apps.FieldTypeDynamicSelect,
-				Name:  "option",
-				Label: "Option",
-				SelectDynamicLookup: &apps.Call{
-					Path: "/dynamic-form-lookup",
-				},
+				Type:                apps.FieldTypeDynamicSelect,
+				Name:                "option",
+				Label:               "Option",
+				SelectDynamicLookup: dynamicFormOptionLookup.call(),
+			},
+			{
+				Type:                apps.FieldTypeDynamicSelect,
+				Name:                "teammate",
+				Label:               "Teammate",
+				SelectDynamicLookup: dynamicFormUserLookup.call(),
+			},
+			{
+				Type:                apps.FieldTypeDynamicSelect,
+				Name:                "channel",
+				Label:               "Channel",
+				SelectDynamicLookup: dynamicFormChannelLookup.call(),
+			},
+			{
+				Type:                apps.FieldTypeDynamicSelect,
+				Name:                "schedule",
+				Label:               "Weather report schedule",
+				SelectDynamicLookup: dynamicFormScheduleLookup.call(),
 			},
 		},
 		Submit: &apps.Call{
@@ -266,41 +412,179 @@ var (
 		},
 	}
 
-	subscriptions = make(map[string]*apps.Subscription)
+	lookupExpand = apps.Expand{
+		ActingUser:            apps.ExpandID,
+		ActingUserAccessToken: apps.ExpandAll,
+		Team:                  apps.ExpandID,
+	}
+
+	dynamicFormOptionLookup = &dynamicLookup{
+		Path: "/dynamic-form-lookup",
+		Source: staticLookupSource{
+			{
+				Label: "Option One",
+				Value: "option_1",
+			},
+			{
+				Label: "Option Two",
+				Value: "option_2",
+			},
+			{
+				Label: "Option Three",
+				Value: "option_3",
+			},
+		},
+	}
+
+	dynamicFormUserLookup = &dynamicLookup{
+		Path:   "/dynamic-form-lookup/user",
+		Source: usersLookupSource{},
+		Expand: &lookupExpand,
+	}
+
+	dynamicFormChannelLookup = &dynamicLookup{
+		Path:   "/dynamic-form-lookup/channel",
+		Source: channelsLookupSource{},
+		Expand: &lookupExpand,
+	}
+
+	dynamicFormScheduleLookup = &dynamicLookup{
+		Path: "/dynamic-form-lookup/schedule",
+		Source: channelKVLookupSource{
+			Prefix: scheduleOptionsKVPrefix,
+		},
+		Expand: &apps.Expand{
+			Channel: apps.ExpandID,
+		},
+	}
+
+	sendFormRules = formRulesFor(sendForm)
+
+	subscribeRules = formRules{
+		"eventname": {
+			Required: true,
+		},
+		"teamid": {
+			Pattern:        mattermostIDPattern,
+			PatternMessage: "Must be a team ID.",
+		},
+		"channelid": {
+			Pattern:        mattermostIDPattern,
+			PatternMessage: "Must be a channel ID.",
+		},
+	}
+
+	subscribeAsUserField = apps.Field{
+		Name:        "as_user",
+		Label:       "as_user",
+		Type:        apps.FieldTypeBool,
+		Description: "Subscribe with your own account instead of the bot's; implied for events about you, such as self_mentioned",
+	}
+
+	subscribeExpand = apps.Expand{
+		ActingUser:            apps.ExpandSummary,
+		ActingUserAccessToken: apps.ExpandAll,
+		Team:                  apps.ExpandID,
+	}
+
+	// userScopedSubjects are the events that are about the subscribing user, so they are always subscribed to
+	// as the acting user
+	userScopedSubjects = map[apps.Subject]bool{
+		subjectSelfMentioned: true,
+	}
+
+	// subscriptionsMutex serializes subscribing and unsubscribing, which check for a stored subscription before
+	// changing it
+	subscriptionsMutex sync.Mutex
 )
 
-func getCallRequest(r *http.Request) (*apps.CallRequest, error) {
-	requestBytes, err := httputil.DumpRequest(r, true)
+// subjectSelfMentioned is the event of the subscribing user being mentioned. It is delivered by newer versions
+// of the Apps plugin than the one this app is built against.
+const subjectSelfMentioned apps.Subject = "self_mentioned"
+
+// subscriptionKVPrefix stores the subscriptions made through the sub command under their subscriptionKey, so
+// that their owners can remove them after a restart
+const subscriptionKVPrefix = "subscription"
+
+// eventSubscription is a subscription made through the sub command and the user who made it
+type eventSubscription struct {
+	Subscription *apps.Subscription `json:"subscription"`
+	// OwnerID is the user who created the subscription; only they can remove it
+	OwnerID string `json:"owner_id"`
+	// AsUser is true when the subscription was made with the owner's credentials rather than the bot's
+	AsUser bool `json:"as_user"`
+}
+
+// subscriptionKey identifies a subscription: an event has at most one bot subscription, and at most one
+// subscription per user
+func subscriptionKey(eventName string, asUser bool, userID string) string {
+	if asUser {
+		return eventName + "-" + userID
+	}
+	return eventName
+}
+
+// getSubscription reads the stored subscription with the key, or returns nil if there is none
+func getSubscription(clt mattermostClient, key string) (*eventSubscription, error) {
+	existing := eventSubscription{}
+	err := clt.KVGet(subscriptionKVPrefix, key, &existing)
+	if err != nil {
+		return nil, newUpstreamError(err, "error reading subscription")
+	}
+	if existing.Subscription == nil {
+		return nil, nil
+	}
+	return &existing, nil
+}
+
+func getCallRequest(r *http.Request) (callRequest *apps.CallRequest, err error) {
+	_, span := startSpan(r.Context(), "decode call request", "INTERNAL")
+	defer func() {
+		span.end(err)
+	}()
+	// the body is dumped after it has been read within the size limit
+	requestBytes, err := httputil.DumpRequest(r, false)
 	if err != nil {
 		return nil, err
 	}
-	log.Printf("getCallRequest(): request=%s\n", string(requestBytes))
 	bodyBytes, err := io.ReadAll(r.Body)
 	if err != nil {
 		return nil, err
 	}
-	callRequest := new(apps.CallRequest)
+	log.Printf("getCallRequest(): request=%s%s\n", string(requestBytes), string(bodyBytes))
+	callRequest = new(apps.CallRequest)
 	err = json.Unmarshal(bodyBytes, callRequest)
 	if err != nil {
 		log.Printf("getCallRequest(): error decoding request body: %s\n", err.Error())
-		return nil, err
+		return nil, newUserInputError("the call request is not valid JSON")
 	}
+	jobScheduler.setContext(callRequest.Context)
 	return callRequest, nil
 }
 
+// sendErrorResponse sends an apps error response with the user text of the error's kind. The response always
+// has status 200, because the apps plugin shows the whole body of any other response as the error text. The
+// error is logged with its kind and details under the trace ID of the call, which is shown to the user so that
+// the details can be found.
 func sendErrorResponse(w http.ResponseWriter, err error) {
-	errorResponse := apps.NewErrorResponse(err)
-	encodedResponse, err := json.Marshal(errorResponse)
+	kind, message := classifyError(err)
+	id := w.Header().Get(traceIDHeader)
+	log.Printf("sendErrorResponse(): trace %s: %s error: %s\n", id, errorKindNames[kind], err.Error())
+	if id != "" {
+		message = fmt.Sprintf("%s [trace ID %s]", message, id)
+	}
+	sendCallResponse(w, apps.NewErrorResponse(errors.New(message)))
+}
+
+func sendCallResponse(w http.ResponseWriter, callResponse apps.CallResponse) {
+	encodedResponse, err := json.Marshal(callResponse)
 	if err != nil {
-		log.Printf("sendErrorResponse(): error encoding response body: %s\n", err.Error())
-		w.Header().Set("Content-Type", "text/plain")
-		w.WriteHeader(http.StatusBadRequest)
-		_, _ = w.Write([]byte(err.Error()))
+		log.Printf("sendCallResponse(): error encoding response body: %s\n", err.Error())
+		sendErrorResponse(w, err)
 		return
 	}
-	log.Printf("sendErrorResponse(): encodedResponse=%s\n", string(encodedResponse))
 	w.Header().Set("Content-Type", "application/json")
-	w.WriteHeader(http.StatusBadRequest)
+	w.WriteHeader(http.StatusOK)
 	_, _ = w.Write(encodedResponse)
 }
 
@@ -311,22 +595,10 @@ func sendFormSource(w http.ResponseWriter, r *http.Request) {
 		sendErrorResponse(w, err)
 		return
 	}
-	userIntf, ok := callRequest.Values["user"]
-	if !ok {
-		sendErrorResponse(w, errors.New("expected value 'user' is missing"))
-		return
-	}
-	userMap, ok := userIntf.(map[string]interface{})
-	if !ok {
-		sendErrorResponse(w, errors.New("expected value 'user' is not a map"))
-		return
-	}
-	sendFormClone := sendForm.PartialCopy()
-	sendFormClone.Fields[1].Value = userMap
-	// return the same form since we don't want to do anything further
+	// return the same form, refreshed from the values entered so far
 	callResponse := apps.CallResponse{
 		Type: apps.CallResponseTypeForm,
-		Form: sendFormClone,
+		Form: sendFormDependencies.evaluate(callRequest.Values),
 	}
 	encodedResponse, err := json.Marshal(callResponse)
 	if err != nil {
@@ -348,7 +620,7 @@ func send(w http.ResponseWriter, r *http.Request) {
 	}
 	callResponse := apps.CallResponse{
 		Type: apps.CallResponseTypeForm,
-		Form: &sendForm,
+		Form: sendFormDependencies.evaluate(nil),
 	}
 	encodedResponse, err := json.Marshal(callResponse)
 	if err != nil {
@@ -369,13 +641,20 @@ func weather(w http.ResponseWriter, r *http.Request) {
 		sendErrorResponse(w, err)
 		return
 	}
+	values := weatherValues{}
+	err = decodeValues(callRequest.Values, &values)
+	if err != nil {
+		sendDecodeErrorResponse(w, err)
+		return
+	}
 	var responseBytes []byte
 	if strings.HasSuffix(callRequest.Path, "day") {
-		responseBytes, err = json.Marshal(weatherDayResponseData)
+		responseBytes, err = json.Marshal(values.apply(callRequest.Context, *weatherDayResponseData))
 	} else if strings.HasSuffix(callRequest.Path, "week") {
-		responseBytes, err = json.Marshal(weatherResponseData)
+		responseBytes, err = json.Marshal(values.apply(callRequest.Context, *weatherResponseData))
 	} else {
-		responseBytes = []byte(`{"type":"error","text":"unknown argument"}`)
+		sendErrorResponse(w, newUserInputError("unknown argument"))
+		return
 	}
 	if err != nil {
 		sendErrorResponse(w, err)
@@ -386,6 +665,60 @@ func weather(w http.ResponseWriter, r *http.Request) {
 	_, _ = w.Write(responseBytes)
 }
 
+// weatherValues are the arguments of the weather day and week commands
+type weatherValues struct {
+	Location string            `value:"location"`
+	Units    apps.SelectOption `value:"units"`
+}
+
+// apply returns the weather response with the temperatures in the units and the asset URLs of the
+// installation. The report is canned sample data for weatherDefaultLocation, so a response for another
+// location says so instead of relabelling it.
+func (values weatherValues) apply(appContext apps.Context, response weatherResponseStruct) *weatherResponseStruct {
+	response.Text += weatherSampleNote(values.Location)
+	if values.Units.Value == weatherUnitsFahrenheit {
+		response.Text = weatherCelsiusPattern.ReplaceAllStringFunc(response.Text, func(temperature string) string {
+			celsius, _ := strconv.Atoi(weatherCelsiusPattern.FindStringSubmatch(temperature)[1])
+			return fmt.Sprintf("%d °F", int(math.Round(float64(celsius)*9/5+32)))
+		})
+	}
+	response.Text = renderMarkdown(appContext, response.Text)
+	return &response
+}
+
+// weatherSampleNote returns the note added to the canned report when it is asked for another location than the
+// one it describes, or an empty string
+func weatherSampleNote(location string) string {
+	location = strings.TrimSpace(location)
+	if location == "" || strings.EqualFold(location, weatherDefaultLocation) {
+		return ""
+	}
+	return fmt.Sprintf("\n_This app has no weather service: this is the sample report for %s, not for %s._",
+		weatherDefaultLocation, location)
+}
+
+type subscribeValues struct {
+	EventName string `value:"eventname"`
+	TeamID    string `value:"teamid"`
+	ChannelID string `value:"channelid"`
+	AsUser    bool   `value:"as_user"`
+}
+
+// subscriptionClient returns the client that a subscription is made with: the acting user's for a user
+// subscription, which requires the act_as_user permission and the expanded user access token, or the bot's
+func subscriptionClient(ctx context.Context, appContext apps.Context, asUser bool) (mattermostClient, error) {
+	if appContext.ActingUser == nil {
+		return nil, errors.New("acting user not expanded")
+	}
+	if !asUser {
+		return asBot(ctx, appContext), nil
+	}
+	if appContext.ActingUserAccessToken == "" {
+		return nil, newForbiddenError("subscribing as yourself requires the app to act as you; ask a system administrator to grant it the act_as_user permission")
+	}
+	return asActingUser(ctx, appContext), nil
+}
+
 func subscribeEvent(w http.ResponseWriter, r *http.Request) {
 	callRequest, err := getCallRequest(r)
 	if err != nil {
@@ -393,33 +726,48 @@ func subscribeEvent(w http.ResponseWriter, r *http.Request) {
 		sendErrorResponse(w, err)
 		return
 	}
-	// validate parameters
-	eventNameIntf, ok := callRequest.Values["eventname"]
-	if !ok {
-		sendErrorResponse(w, errors.New("event name not specified"))
+	fieldErrors := subscribeRules.validate(callRequest.Values)
+	if len(fieldErrors) > 0 {
+		sendCallResponse(w, newFieldErrorsResponse(fieldErrors))
 		return
 	}
-	eventName, ok := eventNameIntf.(string)
-	if !ok || eventName == "" {
-		sendErrorResponse(w, errors.New("invalid event name"))
+	// validate parameters
+	values := subscribeValues{}
+	err = decodeValues(callRequest.Values, &values)
+	if err != nil {
+		sendDecodeErrorResponse(w, err)
 		return
 	}
-	channelId := ""
-	teamId := ""
-	teamIdIntf, ok := callRequest.Values["teamid"]
-	if ok && teamIdIntf != nil {
-		teamId = teamIdIntf.(string)
+	eventName := values.EventName
+	if eventName == "" {
+		sendErrorResponse(w, newUserInputError("invalid event name"))
+		return
 	}
-	channelIdIntf, ok := callRequest.Values["channelid"]
-	if ok && channelIdIntf != nil {
-		channelId = channelIdIntf.(string)
+	channelId := values.ChannelID
+	teamId := values.TeamID
+	asUser := values.AsUser || userScopedSubjects[apps.Subject(eventName)]
+	// create a MM client
+	clt, err := subscriptionClient(r.Context(), callRequest.Context, asUser)
+	if err != nil {
+		sendErrorResponse(w, err)
+		return
 	}
+	ownerID := callRequest.Context.ActingUser.Id
+	key := subscriptionKey(eventName, asUser, ownerID)
+	botClient := asBot(r.Context(), callRequest.Context)
+	subscriptionsMutex.Lock()
+	defer subscriptionsMutex.Unlock()
 	// make sure there isn't already a subscription for the one event
-	if _, ok = subscriptions[eventName]; ok {
-		sendErrorResponse(w, errors.New("a subscription for this event already exists"))
+	existing, err := getSubscription(botClient, key)
+	if err != nil {
+		sendErrorResponse(w, err)
+		return
+	}
+	if existing != nil {
+		sendErrorResponse(w, newUserInputError("a subscription for this event already exists"))
 		return
 	}
-	// create subscription object and add it to the map
+	// create subscription object
 	subscription := &apps.Subscription{
 		Event: apps.Event{
 			Subject:   apps.Subject(eventName),
@@ -430,14 +778,10 @@ func subscribeEvent(w http.ResponseWriter, r *http.Request) {
 			Path: "/event",
 		},
 	}
-	subscriptions[eventName] = subscription
-	// create a MM client
-	clt := appclient.AsBot(callRequest.Context)
-	// join a channel if needed
-	if channelId != "" {
-		_, _, err = clt.AddChannelMember(channelId, callRequest.Context.BotUserID)
+	// a user subscribes to the channels they can already read
+	if channelId != "" && !asUser {
+		err = addBotToChannel(clt, callRequest.Context, channelId)
 		if err != nil {
-			err = fmt.Errorf("error adding bot to channel: %w", err)
 			sendErrorResponse(w, err)
 			return
 		}
@@ -445,16 +789,30 @@ func subscribeEvent(w http.ResponseWriter, r *http.Request) {
 	// subscribe
 	err = clt.Subscribe(subscription)
 	if err != nil {
-		err = fmt.Errorf("error subscribing to event: %w", err)
+		err = newUpstreamError(err, "error subscribing to event")
 		sendErrorResponse(w, err)
 		return
 	}
-	responseBytes := []byte(
-		fmt.Sprintf(`{"type":"ok","text":"successfully subscribed to event %s, channel %s, team %s"}`, eventName, channelId, teamId),
-	)
-	w.Header().Set("Content-Type", "application/json")
-	w.WriteHeader(http.StatusOK)
-	_, _ = w.Write(responseBytes)
+	_, err = botClient.KVSet(subscriptionKVPrefix, key, eventSubscription{
+		Subscription: subscription,
+		OwnerID:      ownerID,
+		AsUser:       asUser,
+	})
+	if err != nil {
+		err = newUpstreamError(err, "error storing subscription")
+		sendErrorResponse(w, err)
+		return
+	}
+	subscriber := "the bot"
+	if asUser {
+		subscriber = "you"
+	}
+	sendCallResponse(w, apps.NewTextResponse("%s", renderMessage(r.Context(), callRequest.Context, "subscribed", messageData{
+		"Subscriber": subscriber,
+		"Event":      eventName,
+		"ChannelID":  channelId,
+		"TeamID":     teamId,
+	})))
 }
 
 func unsubscribeEvent(w http.ResponseWriter, r *http.Request) {
@@ -465,38 +823,61 @@ func unsubscribeEvent(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 	// validate parameters
-	eventNameIntf, ok := callRequest.Values["eventname"]
-	if !ok {
-		sendErrorResponse(w, errors.New("event name not specified"))
+	values := subscribeValues{}
+	err = decodeValues(callRequest.Values, &values)
+	if err != nil {
+		sendDecodeErrorResponse(w, err)
 		return
 	}
-	eventName, ok := eventNameIntf.(string)
-	if !ok || eventName == "" {
-		sendErrorResponse(w, errors.New("invalid event name"))
+	eventName := values.EventName
+	if eventName == "" {
+		sendErrorResponse(w, newUserInputError("invalid event name"))
 		return
 	}
+	if callRequest.Context.ActingUser == nil {
+		sendErrorResponse(w, errors.New("acting user not expanded"))
+		return
+	}
+	asUser := values.AsUser || userScopedSubjects[apps.Subject(eventName)]
+	key := subscriptionKey(eventName, asUser, callRequest.Context.ActingUser.Id)
+	botClient := asBot(r.Context(), callRequest.Context)
+	subscriptionsMutex.Lock()
+	defer subscriptionsMutex.Unlock()
 	// Look for a subscription with that event name
-	subscription, ok := subscriptions[eventName]
-	if !ok {
-		sendErrorResponse(w, errors.New("no subscription for event"))
+	existing, err := getSubscription(botClient, key)
+	if err != nil {
+		sendErrorResponse(w, err)
 		return
 	}
-	// unsubscribe
-	clt := appclient.NewClient(callRequest.Context.BotAccessToken, callRequest.Context.MattermostSiteURL)
-	err = clt.Unsubscribe(subscription)
+	if existing == nil {
+		sendErrorResponse(w, newNotFoundError("no subscription for event"))
+		return
+	}
+	if existing.OwnerID != callRequest.Context.ActingUser.Id {
+		sendErrorResponse(w, newForbiddenError("only the user who created the subscription can remove it"))
+		return
+	}
+	// unsubscribe with the credentials the subscription was made with
+	clt, err := subscriptionClient(r.Context(), callRequest.Context, existing.AsUser)
 	if err != nil {
-		err = fmt.Errorf("error unsubscribing from event: %w", err)
 		sendErrorResponse(w, err)
 		return
 	}
-	// remove the subscription from the map
-	delete(subscriptions, eventName)
-	responseBytes := []byte(
-		fmt.Sprintf(`{"type":"ok","text":"successfully unsubscribed from event %s"}`, eventName),
-	)
-	w.Header().Set("Content-Type", "application/json")
-	w.WriteHeader(http.StatusOK)
-	_, _ = w.Write(responseBytes)
+	err = clt.Unsubscribe(existing.Subscription)
+	if err != nil {
+		err = newUpstreamError(err, "error unsubscribing from event")
+		sendErrorResponse(w, err)
+		return
+	}
+	err = botClient.KVDelete(subscriptionKVPrefix, key)
+	if err != nil {
+		err = newUpstreamError(err, "error deleting subscription")
+		sendErrorResponse(w, err)
+		return
+	}
+	sendCallResponse(w, apps.NewTextResponse("%s", renderMessage(r.Context(), callRequest.Context, "unsubscribed", messageData{
+		"Event": eventName,
+	})))
 }
 
 func handleEvent(w http.ResponseWriter, r *http.Request) {
@@ -506,26 +887,12 @@ func handleEvent(w http.ResponseWriter, r *http.Request) {
 		sendErrorResponse(w, err)
 		return
 	}
-	responseBytes := []byte(`{"type":"ok","text":"handleEvent() was called"}`)
-	w.Header().Set("Content-Type", "application/json")
-	w.WriteHeader(http.StatusOK)
-	_, _ = w.Write(responseBytes)
-}
-
-func appInstalled(w http.ResponseWriter, r *http.Request) {
-	log.Println("app installed")
-	responseBytes := []byte(`{"type":"ok","text":"successfully installed app"}`)
-	w.Header().Set("Content-Type", "application/json")
-	w.WriteHeader(http.StatusOK)
-	_, _ = w.Write(responseBytes)
+	sendCallResponse(w, apps.NewTextResponse("handleEvent() was called"))
 }
 
 func appUninstalled(w http.ResponseWriter, r *http.Request) {
 	log.Println("app uninstalled")
-	responseBytes := []byte(`{"type":"ok","text":"successfully uninstalled app"}`)
-	w.Header().Set("Content-Type", "application/json")
-	w.WriteHeader(http.StatusOK)
-	_, _ = w.Write(responseBytes)
+	sendCallResponse(w, apps.NewTextResponse("successfully uninstalled app"))
 }
 
 func sendDynamicForm(w http.ResponseWriter, r *http.Request) {
@@ -550,34 +917,49 @@ func sendDynamicForm(w http.ResponseWriter, r *http.Request) {
 	_, _ = w.Write(encodedResponse)
 }
 
-func dynamicFormLookup(w http.ResponseWriter, r *http.Request) {
-	_, err := getCallRequest(r)
+func dynamicFormSubmit(w http.ResponseWriter, r *http.Request) {
+	callRequest, err := getCallRequest(r)
 	if err != nil {
-		log.Printf("dynamicFormLookup(): %s\n", err.Error())
+		log.Printf("dynamicFormSubmit(): %s\n", err.Error())
 		sendErrorResponse(w, err)
 		return
 	}
-	callResponse := apps.NewDataResponse(map[string]interface{}{
-		"items": []interface{}{
-			map[string]interface{}{
-				"label": "Option One",
-				"value": "option_1",
-			},
-			map[string]interface{}{
-				"label": "Option Two",
-				"value": "option_2",
+	responseText := "## Selected values\n"
+	for _, field := range dynamicForm.Fields {
+		responseText += fmt.Sprintf("- %s: %s\n", field.Label, strings.Join(wizardAnswerLabels(callRequest.Values[field.Name]), ", "))
+	}
+	sendCallResponse(w, apps.NewTextResponse("%s", responseText))
+}
+
+// sendValues are the values of the send form
+type sendValues struct {
+	Message  string            `value:"message"`
+	User     apps.SelectOption `value:"user"`
+	Style    apps.SelectOption `value:"option"`
+	Delivery apps.SelectOption `value:"delivery"`
+}
+
+// styledPost returns a post that presents the message in the style. The bot posts the message, so every style
+// names the sender and the mentions in the message are neutralized.
+func styledPost(style string, message string, sender *model.User) *model.Post {
+	senderName := "@" + sender.Username
+	post := &model.Post{}
+	switch style {
+	case sendStyleQuote:
+		post.Message = fmt.Sprintf("%s says:\n%s", senderName, quoteMessage(message))
+	case sendStyleCard:
+		// Mattermost notifies the mentions in attachment text too
+		post.AddProp("attachments", []*model.SlackAttachment{
+			{
+				Fallback:   fmt.Sprintf("%s: %s", senderName, neutralizeMentions(message)),
+				AuthorName: senderName,
+				Text:       neutralizeMentions(message),
 			},
-		},
-	})
-	encodedResponse, err := json.Marshal(callResponse)
-	if err != nil {
-		sendErrorResponse(w, err)
-		return
+		})
+	default:
+		post.Message = fmt.Sprintf("From %s: %s", senderName, neutralizeMentions(message))
 	}
-	log.Printf("dynamicFormLookup(): encodedResponse=%s\n", string(encodedResponse))
-	w.Header().Set("Content-Type", "application/json")
-	w.WriteHeader(http.StatusOK)
-	_, _ = w.Write(encodedResponse)
+	return post
 }
 
 func modalSubmit(w http.ResponseWriter, r *http.Request) {
@@ -587,20 +969,60 @@ func modalSubmit(w http.ResponseWriter, r *http.Request) {
 		sendErrorResponse(w, err)
 		return
 	}
-	responseText := "## Form values\n"
-	for key := range callRequest.Values {
-		responseText += fmt.Sprintf("- %s: %#v\n", key, callRequest.Values[key])
+	fieldErrors := sendFormRules.validate(callRequest.Values)
+	if len(fieldErrors) > 0 {
+		sendCallResponse(w, newFieldErrorsResponse(fieldErrors))
+		return
 	}
-	callResponse := apps.NewTextResponse(responseText)
-	encodedResponse, err := json.Marshal(callResponse)
+	values := sendValues{}
+	err = decodeValues(callRequest.Values, &values)
+	if err != nil {
+		sendDecodeErrorResponse(w, err)
+		return
+	}
+	recipient := values.User.Label
+	if recipient == "" {
+		recipient = values.User.Value
+	}
+	recipient = "@" + strings.TrimPrefix(recipient, "@")
+	if callRequest.Context.ActingUser == nil {
+		sendErrorResponse(w, errors.New("acting user not expanded"))
+		return
+	}
+	post := styledPost(values.Style.Value, values.Message, callRequest.Context.ActingUser)
+	clt := asBot(r.Context(), callRequest.Context)
+	if values.Delivery.Value == sendDeliveryChannel {
+		if callRequest.Context.Channel == nil {
+			sendErrorResponse(w, newUserInputError("the message can only be posted from a channel"))
+			return
+		}
+		err = addBotToChannel(clt, callRequest.Context, callRequest.Context.Channel.Id)
+		if err != nil {
+			sendErrorResponse(w, err)
+			return
+		}
+		post.ChannelId = callRequest.Context.Channel.Id
+		post.Message = strings.TrimSpace(recipient + " " + post.Message)
+		_, err = clt.CreatePost(post)
+		if err != nil {
+			err = newUpstreamError(err, "error posting the message for %s", recipient)
+			sendErrorResponse(w, err)
+			return
+		}
+		sendCallResponse(w, apps.NewTextResponse("%s", renderMessage(r.Context(), callRequest.Context, "message_sent_channel", messageData{
+			"Recipient": recipient,
+		})))
+		return
+	}
+	_, err = clt.DMPost(values.User.Value, post)
 	if err != nil {
+		err = newUpstreamError(err, "error sending the message to %s", recipient)
 		sendErrorResponse(w, err)
 		return
 	}
-	log.Printf("modalSubmit(): encodedResponse=%s\n", string(encodedResponse))
-	w.Header().Set("Content-Type", "application/json")
-	w.WriteHeader(http.StatusOK)
-	_, _ = w.Write(encodedResponse)
+	sendCallResponse(w, apps.NewTextResponse("%s", renderMessage(r.Context(), callRequest.Context, "message_sent_dm", messageData{
+		"Recipient": recipient,
+	})))
 }
 
 func sendMessageAttachment(w http.ResponseWriter, r *http.Request) {
@@ -645,7 +1067,7 @@ func sendMessageAttachment(w http.ResponseWriter, r *http.Request) {
 		},
 	}
 	post.AddProp(apps.PropAppBindings, postAppBindings)
-	clt := appclient.AsBot(callRequest.Context)
+	clt := asBot(r.Context(), callRequest.Context)
 	_, err = clt.CreatePost(post)
 	if err != nil {
 		sendErrorResponse(w, err)
@@ -684,6 +1106,71 @@ func setRoastPreference(w http.ResponseWriter, r *http.Request) {
 	_, _ = w.Write(encodedResponse)
 }
 
+// newRouter registers the handlers of every call and static asset
+func newRouter() *httputils.Handler {
+	mux := httputils.NewHandler()
+	handleUnmatched(mux)
+	handleStatic(mux, "/manifest.json", httputils.DoHandleJSON(appManifest))
+	handleCall(mux, "/bindings", httputils.DoHandleJSON(apps.NewDataResponse(appBindings)))
+	handleCall(mux, "/send", send)
+	handleCall(mux, "/weather", weather)
+	handleCall(mux, "/weather/day", weather)
+	handleCall(mux, "/weather/week", weather)
+	handleCall(mux, "/weather/schedule", weatherSchedule)
+	handleCall(mux, "/weather/schedules", weatherSchedules)
+	handleCall(mux, "/weather/pause", weatherPauseSchedule)
+	handleCall(mux, "/weather/resume", weatherPauseSchedule)
+	handleCall(mux, "/weather/unschedule", weatherUnschedule)
+	handleCall(mux, "/weather/timezone", weatherTimezone)
+	handleCall(mux, "/sub", subscribeEvent)
+	handleCall(mux, "/unsub", unsubscribeEvent)
+	handleCall(mux, "/event", handleEvent)
+	handleCall(mux, "/help", commandsHelp)
+	handleCall(mux, "/notes", listNotes)
+	handleCall(mux, "/post/save-note", savePostNote)
+	handleCall(mux, "/post/remind", remindAboutPost)
+	handleCall(mux, "/post/share", sharePost)
+	handleCall(mux, "/messages/list", messagesList)
+	handleCall(mux, "/messages/show", messagesShow)
+	handleCall(mux, "/messages/set", messagesSet)
+	handleCall(mux, "/messages/reset", messagesReset)
+	handleCall(mux, "/installed", appInstalled)
+	handleCall(mux, "/uninstalled", appUninstalled)
+	handleCall(mux, "/info", appInfo)
+	handleCall(mux, "/connect", oauth2Connect)
+	handleCall(mux, "/disconnect", oauth2Disconnect)
+	handleCall(mux, "/configure-oauth2", configureOAuth2)
+	handleCall(mux, "/oauth2/connect", oauth2ConnectURL)
+	handleCall(mux, "/oauth2/complete", oauth2Complete)
+	handleCall(mux, "/webhook-create", webhookCreate)
+	handleCall(mux, "/webhook-list", webhookList)
+	handleCall(mux, "/webhook-delete", webhookDelete)
+	handleCall(mux, webhookCallPath+"/{id}", handleWebhook)
+	onboardingWizard.register(mux)
+	handleCall(mux, "/send-form-source", sendFormSource)
+	handleCall(mux, "/send-dynamic-form", sendDynamicForm)
+	handleCall(mux, "/dynamic-form-submit", dynamicFormSubmit)
+	dynamicFormOptionLookup.register(mux)
+	dynamicFormUserLookup.register(mux)
+	dynamicFormChannelLookup.register(mux)
+	dynamicFormScheduleLookup.register(mux)
+	handleCall(mux, "/modal-submit", modalSubmit)
+	handleCall(mux, "/send-message-attachment", sendMessageAttachment)
+	handleCall(mux, "/set-roast-preference", setRoastPreference)
+	staticFiles, err := newStaticHandler(staticFS)
+	if err != nil {
+		log.Printf("newRouter(): %s\n", err.Error())
+	} else {
+		handleStatic(mux, staticPath+"/{path:.+}", staticFiles.ServeHTTP)
+	}
+	return mux
+}
+
+// newServerHandler wraps the router in the middleware applied to every request
+func newServerHandler(config serverConfig) http.Handler {
+	return tracingMiddleware(recoverMiddleware(limitMiddleware(config)(recordMiddleware(newRouter()))))
+}
+
 func main() {
 	serverAddress := "localhost:4000"
 	envAddress, ok := os.LookupEnv("SERVER_ADDRESS")
@@ -691,32 +1178,28 @@ func main() {
 		serverAddress = envAddress
 		appManifest.Deploy.HTTP.RootURL = fmt.Sprintf("http://%s", serverAddress)
 	}
-	mux := httputils.NewHandler()
-	mux.HandleFunc("/manifest.json", httputils.DoHandleJSON(appManifest))
-	mux.HandleFunc("/bindings", httputils.DoHandleJSON(apps.NewDataResponse(appBindings)))
-	mux.HandleFunc("/send", send)
-	mux.HandleFunc("/weather", weather)
-	mux.HandleFunc("/weather/day", weather)
-	mux.HandleFunc("/weather/week", weather)
-	mux.HandleFunc("/sub", subscribeEvent)
-	mux.HandleFunc("/unsub", unsubscribeEvent)
-	mux.HandleFunc("/event", handleEvent)
-	mux.HandleFunc("/installed", appInstalled)
-	mux.HandleFunc("/uninstalled", appUninstalled)
-	mux.HandleFunc("/send-form-source", sendFormSource)
-	mux.HandleFunc("/send-dynamic-form", sendDynamicForm)
-	mux.HandleFunc("/dynamic-form-lookup", dynamicFormLookup)
-	mux.HandleFunc("/modal-submit", modalSubmit)
-	mux.HandleFunc("/send-message-attachment", sendMessageAttachment)
-	mux.HandleFunc("/set-roast-preference", setRoastPreference)
-	mux.HandleFunc("/static/icon.png", httputils.DoHandleData("image/png", iconData))
-	mux.HandleFunc("/static/icon-info.png", httputils.DoHandleData("image/png", iconInfoData))
-	mux.HandleFunc("/static/icon-head.png", httputils.DoHandleData("image/png", iconHeadData))
+	config, err := loadServerConfig()
+	if err != nil {
+		log.Fatalf("main(): %s\n", err.Error())
+	}
+	err = setupTracing()
+	if err != nil {
+		log.Fatalf("main(): %s\n", err.Error())
+	}
+	err = setupRecording()
+	if err != nil {
+		log.Fatalf("main(): %s\n", err.Error())
+	}
+	setupCallAuthentication()
 	server := http.Server{
 		Addr:              serverAddress,
-		Handler:           mux,
+		Handler:           newServerHandler(config),
 		ReadHeaderTimeout: time.Duration(5) * time.Second,
+		ReadTimeout:       config.ReadTimeout,
+		WriteTimeout:      config.WriteTimeout,
+		IdleTimeout:       config.IdleTimeout,
 	}
+	jobScheduler.start()
 	log.Printf("Listening on %s\n", serverAddress)
 	_ = server.ListenAndServe()
 }
@@ -0,0 +1,572 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"embed"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"text/template"
+	"time"
+
+	"github.com/mattermost/mattermost-plugin-apps/apps"
+	"github.com/mattermost/mattermost-server/v6/model"
+)
+
+const (
+	// messagesKVPrefix holds the custom message templates of each team, keyed by team ID
+	messagesKVPrefix = "messages"
+	// maxMessageTemplateLength is the longest custom template that can be saved
+	maxMessageTemplateLength = 4000
+	// teamTemplatesCacheTTL is how long the custom templates of a team are cached; templates saved through
+	// another replica of the app are used once it has passed
+	teamTemplatesCacheTTL = time.Minute
+)
+
+// teamTemplates are the parsed custom templates of a team, keyed by message name; a template that does not
+// parse is kept as its error
+type teamTemplates struct {
+	templates map[string]*template.Template
+	errors    map[string]error
+	loadedAt  time.Time
+}
+
+var (
+	// teamTemplatesCache holds the custom templates of each team that a response was rendered for, keyed by
+	// team ID, so that a response does not read and parse them every time
+	teamTemplatesCache      = make(map[string]*teamTemplates)
+	teamTemplatesCacheMutex sync.Mutex
+)
+
+//go:embed templates
+var embeddedMessageTemplates embed.FS
+
+// messageData holds the values that a message template refers to, such as {{ .Event }}
+type messageData map[string]interface{}
+
+// messageDefinition is a response message that team admins can customise; custom templates are checked by
+// rendering them with the example data
+type messageDefinition struct {
+	Name        string
+	Description string
+	Example     messageData
+}
+
+// messageValues are the values of the messages commands
+type messageValues struct {
+	Name     string `value:"name"`
+	Template string `value:"template"`
+}
+
+var (
+	messageDefinitions = []messageDefinition{
+		{"subscribed", "An event subscription was created", messageData{"Subscriber": "the bot", "Event": "channel_created", "ChannelID": "", "TeamID": ""}},
+		{"unsubscribed", "An event subscription was removed", messageData{"Event": "channel_created"}},
+		{"schedule_created", "A weather report was scheduled", messageData{"ID": "a1b2c3d4", "Location": "Toronto, Ontario", "Expression": "30 8 * * 1-5", "Timezone": "America/Toronto"}},
+		{"schedule_paused", "A weather report schedule was paused", messageData{"ID": "a1b2c3d4"}},
+		{"schedule_resumed", "A weather report schedule was resumed", messageData{"ID": "a1b2c3d4"}},
+		{"schedule_deleted", "A weather report schedule was deleted", messageData{"ID": "a1b2c3d4"}},
+		{"timezone_set", "The timezone of a channel was set", messageData{"Timezone": "America/Toronto"}},
+		{"webhook_created", "An incoming webhook was created", messageData{"ID": "a1b2c3d4", "URL": "https://mattermost.example.com/plugins/com.mattermost.apps/apps/hello-world/webhook/a1b2c3d4?secret=s3cr3t"}},
+		{"webhook_deleted", "An incoming webhook was deleted", messageData{"ID": "a1b2c3d4"}},
+		{"note_saved", "A post was saved to the notes of a user", messageData{"Command": "/hello-world notes"}},
+		{"reminder_set", "A reminder about a post was set", messageData{"Time": "Mon Jan 2 15:04 UTC"}},
+		{"post_shared", "A post was shared to another channel", messageData{"Channel": "Town Square"}},
+		{"message_sent_dm", "The send form delivered a direct message", messageData{"Recipient": "bob"}},
+		{"message_sent_channel", "The send form posted a message in the channel", messageData{"Recipient": "bob"}},
+		{"onboarding_complete", "A user finished the onboarding wizard", messageData{"Name": "Alice", "Role": "Developer"}},
+	}
+
+	// defaultMessageTexts are the templates in templates/, one per message definition
+	defaultMessageTexts = mustReadDefaultMessageTexts()
+	// defaultMessageTemplates are the parsed default templates
+	defaultMessageTemplates = mustParseDefaultMessageTemplates()
+
+	// messageTemplateFuncs are the Markdown helpers available to message templates
+	messageTemplateFuncs = template.FuncMap{
+		"bold":      func(text string) string { return "**" + text + "**" },
+		"italic":    func(text string) string { return "_" + text + "_" },
+		"code":      markdownCode,
+		"codeBlock": markdownCodeBlock,
+		"quote":     quoteMessage,
+		"link":      func(text string, url string) string { return fmt.Sprintf("[%s](%s)", text, url) },
+		"mention":   func(username string) string { return "@" + strings.TrimPrefix(username, "@") },
+		"escape":    markdownEscaper.Replace,
+	}
+
+	// markdownEscaper backslash-escapes the characters that Markdown would otherwise format
+	markdownEscaper = strings.NewReplacer(
+		`\`, `\\`, "`", "\\`", "*", `\*`, "_", `\_`, "[", `\[`, "]", `\]`, "(", `\(`, ")", `\)`,
+		"#", `\#`, "~", `\~`, "|", `\|`, "<", `\<`, ">", `\>`,
+	)
+
+	messagesExpand = apps.Expand{
+		ActingUser: apps.ExpandSummary,
+		Team:       apps.ExpandID,
+		TeamMember: apps.ExpandAll,
+	}
+
+	messageNameField = apps.Field{
+		Name:                 "name",
+		Type:                 apps.FieldTypeStaticSelect,
+		Description:          "The message",
+		IsRequired:           true,
+		AutocompleteHint:     "name",
+		AutocompletePosition: 1,
+		SelectStaticOptions:  messageNameOptions(),
+	}
+
+	messagesBinding = apps.Binding{
+		Location:    "messages",
+		Label:       "messages",
+		Description: "Customise the response messages of this app for the team",
+		Bindings: []apps.Binding{
+			{
+				Location:    "list",
+				Label:       "list",
+				Description: "List the response messages and whether the team customised them",
+				Form:        &apps.Form{Submit: apps.NewCall("/messages/list").WithExpand(messagesExpand)},
+			},
+			{
+				Location:    "show",
+				Label:       "show",
+				Description: "Show the template of a response message and the fields it can use",
+				Form: &apps.Form{
+					Fields: []apps.Field{messageNameField},
+					Submit: apps.NewCall("/messages/show").WithExpand(messagesExpand),
+				},
+			},
+			{
+				Location:    "set",
+				Label:       "set",
+				Description: "Replace the template of a response message for the team (team admins only)",
+				Form: &apps.Form{
+					Fields: []apps.Field{
+						messageNameField,
+						{
+							Name:          "template",
+							Type:          apps.FieldTypeText,
+							TextSubtype:   apps.TextFieldSubtypeTextarea,
+							Description:   "A text/template template, such as {{ bold .Event }}",
+							IsRequired:    true,
+							TextMaxLength: maxMessageTemplateLength,
+						},
+					},
+					Submit: apps.NewCall("/messages/set").WithExpand(messagesExpand),
+				},
+			},
+			{
+				Location:    "reset",
+				Label:       "reset",
+				Description: "Restore the default template of a response message for the team (team admins only)",
+				Form: &apps.Form{
+					Fields: []apps.Field{messageNameField},
+					Submit: apps.NewCall("/messages/reset").WithExpand(messagesExpand),
+				},
+			},
+		},
+	}
+)
+
+func mustReadDefaultMessageTexts() map[string]string {
+	texts := make(map[string]string, len(messageDefinitions))
+	for _, definition := range messageDefinitions {
+		text, err := embeddedMessageTemplates.ReadFile("templates/" + definition.Name + ".md")
+		if err != nil {
+			// every message must ship with a default template
+			panic(err)
+		}
+		texts[definition.Name] = strings.TrimRight(string(text), "\n")
+	}
+	return texts
+}
+
+func mustParseDefaultMessageTemplates() map[string]*template.Template {
+	templates := make(map[string]*template.Template, len(defaultMessageTexts))
+	for name, text := range defaultMessageTexts {
+		tmpl, err := parseMessageTemplate(name, text)
+		if err != nil {
+			panic(err)
+		}
+		templates[name] = tmpl
+	}
+	return templates
+}
+
+func messageNameOptions() []apps.SelectOption {
+	options := make([]apps.SelectOption, 0, len(messageDefinitions))
+	for _, definition := range messageDefinitions {
+		options = append(options, apps.SelectOption{Label: definition.Name, Value: definition.Name})
+	}
+	return options
+}
+
+func getMessageDefinition(name string) (messageDefinition, error) {
+	for _, definition := range messageDefinitions {
+		if definition.Name == name {
+			return definition, nil
+		}
+	}
+	return messageDefinition{}, newNotFoundError("there is no message `%s`; run `/%s messages list` to list them", name, appManifest.AppID)
+}
+
+// markdownCode formats text as inline code, with a longer fence when the text contains backticks
+func markdownCode(text string) string {
+	fence := "`"
+	for strings.Contains(text, fence) {
+		fence += "`"
+	}
+	if len(fence) > 1 {
+		return fmt.Sprintf("%s %s %s", fence, text, fence)
+	}
+	return fence + text + fence
+}
+
+// markdownCodeBlock formats text as a fenced code block
+func markdownCodeBlock(text string) string {
+	fence := "```"
+	for strings.Contains(text, fence) {
+		fence += "`"
+	}
+	return fmt.Sprintf("%s\n%s\n%s", fence, text, fence)
+}
+
+// parseMessageTemplate parses a message template; referring to a value that the message does not have is an error
+func parseMessageTemplate(name string, text string) (*template.Template, error) {
+	return template.New(name).Funcs(messageTemplateFuncs).Option("missingkey=error").Parse(text)
+}
+
+func executeMessageTemplate(tmpl *template.Template, data messageData) (string, error) {
+	buffer := bytes.Buffer{}
+	err := tmpl.Execute(&buffer, data)
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimRight(buffer.String(), "\n"), nil
+}
+
+// validateMessageTemplate checks that a custom template parses and renders the example data of its message
+func validateMessageTemplate(definition messageDefinition, text string) error {
+	if strings.TrimSpace(text) == "" {
+		return errors.New("the template is empty")
+	}
+	if len(text) > maxMessageTemplateLength {
+		return fmt.Errorf("the template is longer than %d characters", maxMessageTemplateLength)
+	}
+	tmpl, err := parseMessageTemplate(definition.Name, text)
+	if err != nil {
+		return err
+	}
+	rendered, err := executeMessageTemplate(tmpl, definition.Example)
+	if err != nil {
+		return err
+	}
+	if strings.TrimSpace(rendered) == "" {
+		return errors.New("the template renders an empty message")
+	}
+	return nil
+}
+
+// getTeamMessageTemplates returns the custom message templates of a team, keyed by message name
+func getTeamMessageTemplates(clt mattermostClient, teamID string) (map[string]string, error) {
+	templates := make(map[string]string)
+	err := clt.KVGet(messagesKVPrefix, teamID, &templates)
+	if err != nil {
+		return nil, err
+	}
+	if templates == nil {
+		// a team without custom templates has no KV entry
+		templates = make(map[string]string)
+	}
+	return templates, nil
+}
+
+// renderMessage renders a response message with the custom template of the team that the call was made in, or
+// with the default template. A custom template that cannot be read or rendered is logged and the default is used
+// instead, so a response is never lost to a broken template.
+func renderMessage(ctx context.Context, appContext apps.Context, name string, data messageData) string {
+	if appContext.Team != nil && appContext.Team.Id != "" {
+		rendered, err := renderTeamMessage(ctx, appContext, name, data)
+		if err != nil {
+			log.Printf("renderMessage(): custom %s message of team %s: %s\n", name, appContext.Team.Id, err.Error())
+		} else if rendered != "" {
+			return renderMarkdown(appContext, rendered)
+		}
+	}
+	rendered, err := executeMessageTemplate(defaultMessageTemplates[name], data)
+	if err != nil {
+		log.Printf("renderMessage(): default %s message: %s\n", name, err.Error())
+		return name
+	}
+	return renderMarkdown(appContext, rendered)
+}
+
+// renderTeamMessage renders the custom template of the team, or returns an empty message if it has none
+func renderTeamMessage(ctx context.Context, appContext apps.Context, name string, data messageData) (string, error) {
+	cached, err := getCachedTeamTemplates(ctx, appContext)
+	if err != nil {
+		return "", err
+	}
+	if parseErr, ok := cached.errors[name]; ok {
+		return "", parseErr
+	}
+	tmpl, ok := cached.templates[name]
+	if !ok {
+		return "", nil
+	}
+	return executeMessageTemplate(tmpl, data)
+}
+
+// getCachedTeamTemplates returns the parsed custom templates of the call's team, reading them from the KV store
+// when they are not cached or the cached ones are older than teamTemplatesCacheTTL
+func getCachedTeamTemplates(ctx context.Context, appContext apps.Context) (*teamTemplates, error) {
+	teamID := appContext.Team.Id
+	teamTemplatesCacheMutex.Lock()
+	cached, ok := teamTemplatesCache[teamID]
+	teamTemplatesCacheMutex.Unlock()
+	if ok && time.Since(cached.loadedAt) < teamTemplatesCacheTTL {
+		return cached, nil
+	}
+	texts, err := getTeamMessageTemplates(asBot(ctx, appContext), teamID)
+	if err != nil {
+		return nil, err
+	}
+	cached = &teamTemplates{
+		templates: make(map[string]*template.Template, len(texts)),
+		errors:    make(map[string]error),
+		loadedAt:  time.Now(),
+	}
+	for name, text := range texts {
+		tmpl, err := parseMessageTemplate(name, text)
+		if err != nil {
+			cached.errors[name] = err
+			continue
+		}
+		cached.templates[name] = tmpl
+	}
+	teamTemplatesCacheMutex.Lock()
+	teamTemplatesCache[teamID] = cached
+	teamTemplatesCacheMutex.Unlock()
+	return cached, nil
+}
+
+// forgetTeamTemplates drops the cached templates of a team once they have been changed
+func forgetTeamTemplates(teamID string) {
+	teamTemplatesCacheMutex.Lock()
+	defer teamTemplatesCacheMutex.Unlock()
+	delete(teamTemplatesCache, teamID)
+}
+
+// requireTeamAdmin allows system administrators and administrators of the team that the call was made in
+func requireTeamAdmin(appContext apps.Context) error {
+	if appContext.ActingUser != nil && appContext.ActingUser.IsSystemAdmin() {
+		return nil
+	}
+	if member := appContext.TeamMember; member != nil && member.DeleteAt == 0 {
+		if member.SchemeAdmin || containsString(strings.Fields(member.Roles), model.TeamAdminRoleId) {
+			return nil
+		}
+	}
+	return newForbiddenError("this command is restricted to team administrators")
+}
+
+// messageCallTeamID returns the team of a messages command
+func messageCallTeamID(appContext apps.Context) (string, error) {
+	if appContext.Team == nil || appContext.Team.Id == "" {
+		return "", newUserInputError("response messages can only be customised in a team")
+	}
+	return appContext.Team.Id, nil
+}
+
+func messagesList(w http.ResponseWriter, r *http.Request) {
+	callRequest, err := getCallRequest(r)
+	if err != nil {
+		log.Printf("messagesList(): %s\n", err.Error())
+		sendErrorResponse(w, err)
+		return
+	}
+	teamID, err := messageCallTeamID(callRequest.Context)
+	if err != nil {
+		sendErrorResponse(w, err)
+		return
+	}
+	templates, err := getTeamMessageTemplates(asBot(r.Context(), callRequest.Context), teamID)
+	if err != nil {
+		err = newUpstreamError(err, "error getting message templates")
+		sendErrorResponse(w, err)
+		return
+	}
+	responseText := "## Response messages\n"
+	for _, definition := range messageDefinitions {
+		customised := ""
+		if _, ok := templates[definition.Name]; ok {
+			customised = " (customised)"
+		}
+		responseText += fmt.Sprintf("- `%s`: %s%s\n", definition.Name, definition.Description, customised)
+	}
+	responseText += fmt.Sprintf("\nRun `/%s messages show [name]` to see a template and the fields it can use.", appManifest.AppID)
+	sendCallResponse(w, apps.NewTextResponse("%s", responseText))
+}
+
+func messagesShow(w http.ResponseWriter, r *http.Request) {
+	callRequest, err := getCallRequest(r)
+	if err != nil {
+		log.Printf("messagesShow(): %s\n", err.Error())
+		sendErrorResponse(w, err)
+		return
+	}
+	teamID, err := messageCallTeamID(callRequest.Context)
+	if err != nil {
+		sendErrorResponse(w, err)
+		return
+	}
+	values := messageValues{}
+	err = decodeValues(callRequest.Values, &values)
+	if err != nil {
+		sendDecodeErrorResponse(w, err)
+		return
+	}
+	definition, err := getMessageDefinition(values.Name)
+	if err != nil {
+		sendErrorResponse(w, err)
+		return
+	}
+	templates, err := getTeamMessageTemplates(asBot(r.Context(), callRequest.Context), teamID)
+	if err != nil {
+		err = newUpstreamError(err, "error getting message templates")
+		sendErrorResponse(w, err)
+		return
+	}
+	text, customised := templates[definition.Name]
+	source := "default"
+	if customised {
+		source = "custom"
+	} else {
+		text = defaultMessageTexts[definition.Name]
+	}
+	fields := make([]string, 0, len(definition.Example))
+	for field := range definition.Example {
+		fields = append(fields, "`."+field+"`")
+	}
+	sort.Strings(fields)
+	sendCallResponse(w, apps.NewTextResponse(
+		"%s (%s template):\n%s\nFields: %s\n\nHelpers: %s",
+		markdownCode(definition.Name),
+		source,
+		markdownCodeBlock(text),
+		strings.Join(fields, ", "),
+		"`bold`, `italic`, `code`, `codeBlock`, `quote`, `link`, `mention`, `escape`",
+	))
+}
+
+func messagesSet(w http.ResponseWriter, r *http.Request) {
+	callRequest, err := getCallRequest(r)
+	if err != nil {
+		log.Printf("messagesSet(): %s\n", err.Error())
+		sendErrorResponse(w, err)
+		return
+	}
+	err = requireTeamAdmin(callRequest.Context)
+	if err != nil {
+		sendErrorResponse(w, err)
+		return
+	}
+	teamID, err := messageCallTeamID(callRequest.Context)
+	if err != nil {
+		sendErrorResponse(w, err)
+		return
+	}
+	values := messageValues{}
+	err = decodeValues(callRequest.Values, &values)
+	if err != nil {
+		sendDecodeErrorResponse(w, err)
+		return
+	}
+	definition, err := getMessageDefinition(values.Name)
+	if err != nil {
+		sendErrorResponse(w, err)
+		return
+	}
+	err = validateMessageTemplate(definition, values.Template)
+	if err != nil {
+		sendCallResponse(w, newFieldErrorsResponse(map[string]string{"template": err.Error()}))
+		return
+	}
+	clt := asBot(r.Context(), callRequest.Context)
+	// the team's templates are one value, see kvUpdateMutex
+	kvUpdateMutex.Lock()
+	defer kvUpdateMutex.Unlock()
+	templates, err := getTeamMessageTemplates(clt, teamID)
+	if err != nil {
+		err = newUpstreamError(err, "error getting message templates")
+		sendErrorResponse(w, err)
+		return
+	}
+	templates[definition.Name] = values.Template
+	_, err = clt.KVSet(messagesKVPrefix, teamID, templates)
+	if err != nil {
+		err = newUpstreamError(err, "error storing message templates")
+		sendErrorResponse(w, err)
+		return
+	}
+	forgetTeamTemplates(teamID)
+	sendCallResponse(w, apps.NewTextResponse("successfully customised the `%s` message for this team", definition.Name))
+}
+
+func messagesReset(w http.ResponseWriter, r *http.Request) {
+	callRequest, err := getCallRequest(r)
+	if err != nil {
+		log.Printf("messagesReset(): %s\n", err.Error())
+		sendErrorResponse(w, err)
+		return
+	}
+	err = requireTeamAdmin(callRequest.Context)
+	if err != nil {
+		sendErrorResponse(w, err)
+		return
+	}
+	teamID, err := messageCallTeamID(callRequest.Context)
+	if err != nil {
+		sendErrorResponse(w, err)
+		return
+	}
+	values := messageValues{}
+	err = decodeValues(callRequest.Values, &values)
+	if err != nil {
+		sendDecodeErrorResponse(w, err)
+		return
+	}
+	definition, err := getMessageDefinition(values.Name)
+	if err != nil {
+		sendErrorResponse(w, err)
+		return
+	}
+	clt := asBot(r.Context(), callRequest.Context)
+	// the team's templates are one value, see kvUpdateMutex
+	kvUpdateMutex.Lock()
+	defer kvUpdateMutex.Unlock()
+	templates, err := getTeamMessageTemplates(clt, teamID)
+	if err != nil {
+		err = newUpstreamError(err, "error getting message templates")
+		sendErrorResponse(w, err)
+		return
+	}
+	if _, ok := templates[definition.Name]; !ok {
+		sendCallResponse(w, apps.NewTextResponse("the `%s` message already uses the default template", definition.Name))
+		return
+	}
+	delete(templates, definition.Name)
+	_, err = clt.KVSet(messagesKVPrefix, teamID, templates)
+	if err != nil {
+		err = newUpstreamError(err, "error storing message templates")
+		sendErrorResponse(w, err)
+		return
+	}
+	forgetTeamTemplates(teamID)
+	sendCallResponse(w, apps.NewTextResponse("restored the default `%s` message for this team", definition.Name))
+}
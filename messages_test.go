@@ -0,0 +1,175 @@
+package main
+
+import (
+	"net/http"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/mattermost/mattermost-plugin-apps/apps"
+	"github.com/mattermost/mattermost-server/v6/model"
+)
+
+func TestDefaultMessageTemplatesRenderTheirExamples(t *testing.T) {
+	paths, err := filepath.Glob(filepath.Join("templates", "*.md"))
+	if err != nil {
+		t.Fatalf("error listing templates/: %s", err.Error())
+	}
+	if len(paths) != len(messageDefinitions) {
+		t.Errorf("templates/ has %d templates for %d messages", len(paths), len(messageDefinitions))
+	}
+	for _, definition := range messageDefinitions {
+		err = validateMessageTemplate(definition, defaultMessageTexts[definition.Name])
+		if err != nil {
+			t.Errorf("default %s template: %s", definition.Name, err.Error())
+		}
+	}
+}
+
+func TestMessageTemplateHelpers(t *testing.T) {
+	tests := []struct {
+		text string
+		want string
+	}{
+		{"{{ code .Value }}", "`a*b`"},
+		{"{{ code \"a`b\" }}", "`` a`b ``"},
+		{"{{ codeBlock .Value }}", "```\na*b\n```"},
+		{"{{ bold .Value }} {{ italic .Value }}", "**a*b** _a*b_"},
+		{"{{ escape .Value }}", `a\*b`},
+		{"{{ mention \"@bob\" }} {{ mention \"bob\" }}", "@bob @bob"},
+		{"{{ link \"docs\" \"https://example.com\" }}", "[docs](https://example.com)"},
+		{"{{ quote \"one\\ntwo\" }}", "> one\n> two"},
+	}
+	for _, test := range tests {
+		tmpl, err := parseMessageTemplate("test", test.text)
+		if err != nil {
+			t.Errorf("%q: %s", test.text, err.Error())
+			continue
+		}
+		got, err := executeMessageTemplate(tmpl, messageData{"Value": "a*b"})
+		if err != nil {
+			t.Errorf("%q: %s", test.text, err.Error())
+			continue
+		}
+		if got != test.want {
+			t.Errorf("%q rendered %q, want %q", test.text, got, test.want)
+		}
+	}
+}
+
+func TestCustomMessageTemplates(t *testing.T) {
+	at := newAppTest(t)
+	at.mustCall("/messages/set", map[string]interface{}{
+		"name":     "timezone_set",
+		"template": "{{ bold .Timezone }} it is ![icon](icon.png)",
+	}, apps.CallResponseTypeOK)
+	callResponse := at.mustCall("/weather/timezone", map[string]interface{}{"timezone": "Europe/Paris"}, apps.CallResponseTypeOK)
+	want := "**Europe/Paris** it is ![icon](" + assetURL(at.context(), "icon.png") + ")"
+	if callResponse.Text != want {
+		t.Errorf("custom message is %q, want %q", callResponse.Text, want)
+	}
+	callResponse = at.mustCall("/messages/list", nil, apps.CallResponseTypeOK)
+	if !strings.Contains(callResponse.Text, "`timezone_set`: The timezone of a channel was set (customised)") {
+		t.Errorf("list does not mark the custom message: %q", callResponse.Text)
+	}
+	callResponse = at.mustCall("/messages/show", map[string]interface{}{"name": "timezone_set"}, apps.CallResponseTypeOK)
+	if !strings.Contains(callResponse.Text, "custom template") || !strings.Contains(callResponse.Text, "`.Timezone`") {
+		t.Errorf("unexpected template description %q", callResponse.Text)
+	}
+
+	// other teams keep the default
+	team := at.team
+	at.team = &model.Team{Id: model.NewId(), Name: "other"}
+	callResponse = at.mustCall("/weather/timezone", map[string]interface{}{"timezone": "Europe/Paris"}, apps.CallResponseTypeOK)
+	if callResponse.Text != "successfully set the channel timezone to Europe/Paris" {
+		t.Errorf("other team got %q", callResponse.Text)
+	}
+
+	at.team = team
+	at.mustCall("/messages/reset", map[string]interface{}{"name": "timezone_set"}, apps.CallResponseTypeOK)
+	callResponse = at.mustCall("/weather/timezone", map[string]interface{}{"timezone": "Europe/Paris"}, apps.CallResponseTypeOK)
+	if callResponse.Text != "successfully set the channel timezone to Europe/Paris" {
+		t.Errorf("reset message is %q", callResponse.Text)
+	}
+}
+
+func TestMessageTemplatesAreValidatedOnSave(t *testing.T) {
+	at := newAppTest(t)
+	for _, template := range []string{"{{ .Missing }}", "{{ bold .Timezone ", "{{ nope .Timezone }}", "  ", "{{ if false }}x{{ end }}"} {
+		callResponse := at.mustCall("/messages/set", map[string]interface{}{
+			"name":     "timezone_set",
+			"template": template,
+		}, apps.CallResponseTypeError)
+		data, _ := callResponse.Data.(map[string]interface{})
+		fieldErrors, _ := data["errors"].(map[string]interface{})
+		if fieldErrors["template"] == nil {
+			t.Errorf("%q was not rejected with a field error: %v", template, callResponse.Data)
+		}
+	}
+	at.mustFail("/messages/set", map[string]interface{}{"name": "unknown", "template": "x"}, "there is no message `unknown`")
+	if len(at.mattermost.KVWrites) != 0 {
+		t.Errorf("unexpected KV writes %v", at.mattermost.KVWrites)
+	}
+}
+
+func TestCustomMessageTemplatesAreCached(t *testing.T) {
+	at := newAppTest(t)
+	at.mustCall("/messages/set", map[string]interface{}{
+		"name":     "timezone_set",
+		"template": "it is {{ .Timezone }}",
+	}, apps.CallResponseTypeOK)
+	at.mustCall("/weather/timezone", map[string]interface{}{"timezone": "Europe/Paris"}, apps.CallResponseTypeOK)
+	// the templates are not read again while they are cached
+	at.mattermost.Failures[http.MethodGet+" "+appsPluginAPIPath+"/kv/"+messagesKVPrefix+"/"+at.team.Id] = http.StatusServiceUnavailable
+	callResponse := at.mustCall("/weather/timezone", map[string]interface{}{"timezone": "Europe/Paris"}, apps.CallResponseTypeOK)
+	if callResponse.Text != "it is Europe/Paris" {
+		t.Errorf("cached message is %q", callResponse.Text)
+	}
+	// saving a template replaces the cached ones
+	delete(at.mattermost.Failures, http.MethodGet+" "+appsPluginAPIPath+"/kv/"+messagesKVPrefix+"/"+at.team.Id)
+	at.mustCall("/messages/set", map[string]interface{}{
+		"name":     "timezone_set",
+		"template": "now {{ .Timezone }}",
+	}, apps.CallResponseTypeOK)
+	callResponse = at.mustCall("/weather/timezone", map[string]interface{}{"timezone": "Europe/Paris"}, apps.CallResponseTypeOK)
+	if callResponse.Text != "now Europe/Paris" {
+		t.Errorf("message after saving a template is %q", callResponse.Text)
+	}
+}
+
+func TestBrokenCustomMessageTemplatesFallBackToTheDefault(t *testing.T) {
+	at := newAppTest(t)
+	at.mattermost.kvSet(t, messagesKVPrefix, at.team.Id, map[string]string{"timezone_set": "{{ .Missing }}"})
+	callResponse := at.mustCall("/weather/timezone", map[string]interface{}{"timezone": "Europe/Paris"}, apps.CallResponseTypeOK)
+	if callResponse.Text != "successfully set the channel timezone to Europe/Paris" {
+		t.Errorf("broken template rendered %q", callResponse.Text)
+	}
+}
+
+func TestMessageCommandsAreRestrictedToTeamAdmins(t *testing.T) {
+	at := newAppTest(t)
+	at.user.Roles = model.SystemUserRoleId
+	values := map[string]interface{}{"name": "timezone_set", "template": "{{ .Timezone }}"}
+	for _, path := range []string{"/messages/set", "/messages/reset"} {
+		at.mustFail(path, values, "restricted to team administrators")
+	}
+	if len(at.mattermost.KVWrites) != 0 {
+		t.Errorf("unexpected KV writes %v", at.mattermost.KVWrites)
+	}
+	at.mustCall("/messages/list", nil, apps.CallResponseTypeOK)
+
+	appContext := at.context()
+	appContext.TeamMember = &model.TeamMember{
+		TeamId: at.team.Id,
+		UserId: at.user.Id,
+		Roles:  model.TeamUserRoleId + " " + model.TeamAdminRoleId,
+	}
+	statusCode, callResponse := at.call(apps.CallRequest{
+		Call:    *apps.NewCall("/messages/set"),
+		Context: appContext,
+		Values:  values,
+	})
+	if statusCode != http.StatusOK || callResponse.Type != apps.CallResponseTypeOK {
+		t.Errorf("a team admin got status %d and response %q", statusCode, callResponse.Text)
+	}
+}
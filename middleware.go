@@ -0,0 +1,59 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+	"runtime/debug"
+)
+
+// statusRecorder remembers whether the handler has started writing its response, and with which status
+type statusRecorder struct {
+	http.ResponseWriter
+	wroteHeader bool
+	statusCode  int
+}
+
+func (sr *statusRecorder) WriteHeader(statusCode int) {
+	if !sr.wroteHeader {
+		sr.statusCode = statusCode
+	}
+	sr.wroteHeader = true
+	sr.ResponseWriter.WriteHeader(statusCode)
+}
+
+func (sr *statusRecorder) Write(data []byte) (int, error) {
+	if !sr.wroteHeader {
+		sr.statusCode = http.StatusOK
+	}
+	sr.wroteHeader = true
+	return sr.ResponseWriter.Write(data)
+}
+
+// recoverMiddleware turns a panic in a handler into an apps error response instead of a dropped connection, and
+// marks the span of the call as failed
+func recoverMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		recorder := &statusRecorder{ResponseWriter: w}
+		defer func() {
+			recovered := recover()
+			if recovered == nil {
+				return
+			}
+			if recovered == http.ErrAbortHandler {
+				panic(recovered)
+			}
+			err := fmt.Errorf("panic handling %s: %v", r.URL.Path, recovered)
+			log.Printf("recoverMiddleware(): trace %s: %s\n%s", traceID(r.Context()), err.Error(), debug.Stack())
+			if span := spanFromContext(r.Context()); span != nil {
+				span.fail(err)
+			}
+			if recorder.wroteHeader {
+				// too late to replace the response
+				return
+			}
+			sendErrorResponse(w, newInternalError(err, "an unexpected error occurred; please contact your system administrator"))
+		}()
+		next.ServeHTTP(recorder, r)
+	})
+}
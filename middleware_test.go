@@ -0,0 +1,79 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/mattermost/mattermost-plugin-apps/apps"
+)
+
+// captureLog sends the log output to a buffer for the rest of the test
+func captureLog(t *testing.T) *bytes.Buffer {
+	t.Helper()
+	previous := log.Writer()
+	buffer := &bytes.Buffer{}
+	log.SetOutput(buffer)
+	t.Cleanup(func() {
+		log.SetOutput(previous)
+	})
+	return buffer
+}
+
+func TestRecoverMiddlewareAnswersPanicsWithAnErrorResponse(t *testing.T) {
+	logged := captureLog(t)
+	handler := tracingMiddleware(recoverMiddleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		panic("boom")
+	})))
+	recorder := httptest.NewRecorder()
+	handler.ServeHTTP(recorder, httptest.NewRequest(http.MethodPost, "/boom", nil))
+	callResponse := apps.CallResponse{}
+	err := json.Unmarshal(recorder.Body.Bytes(), &callResponse)
+	if err != nil {
+		t.Fatalf("error decoding response %s: %s", recorder.Body.String(), err.Error())
+	}
+	if recorder.Code != http.StatusOK || callResponse.Type != apps.CallResponseTypeError {
+		t.Fatalf("got status %d and %s response, want an apps error response", recorder.Code, callResponse.Type)
+	}
+	if strings.Contains(callResponse.Text, "boom") || !strings.Contains(callResponse.Text, "an unexpected error occurred") {
+		t.Errorf("unexpected error text %q", callResponse.Text)
+	}
+	id := recorder.Header().Get(traceIDHeader)
+	want := "recoverMiddleware(): trace " + id + ": panic handling /boom: boom"
+	if !strings.Contains(logged.String(), want) || !strings.Contains(logged.String(), "runtime/debug.Stack") {
+		t.Errorf("the panic was not logged with its trace ID and stack:\n%s", logged.String())
+	}
+}
+
+func TestRecoverMiddlewareKeepsAStartedResponse(t *testing.T) {
+	logged := captureLog(t)
+	handler := recoverMiddleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusAccepted)
+		panic("boom")
+	}))
+	recorder := httptest.NewRecorder()
+	handler.ServeHTTP(recorder, httptest.NewRequest(http.MethodPost, "/boom", nil))
+	if recorder.Code != http.StatusAccepted || recorder.Body.Len() != 0 {
+		t.Errorf("got status %d and body %q, want the started response", recorder.Code, recorder.Body.String())
+	}
+	if !strings.Contains(logged.String(), "panic handling /boom: boom") {
+		t.Errorf("the panic was not logged:\n%s", logged.String())
+	}
+}
+
+func TestRecoverMiddlewareRepanicsAbortedHandlers(t *testing.T) {
+	captureLog(t)
+	handler := recoverMiddleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		panic(http.ErrAbortHandler)
+	}))
+	defer func() {
+		if recovered := recover(); recovered != http.ErrAbortHandler {
+			t.Errorf("recovered %v, want http.ErrAbortHandler", recovered)
+		}
+	}()
+	handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodPost, "/boom", nil))
+}
@@ -0,0 +1,202 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"log"
+	"net"
+	"net/http"
+	"time"
+
+	"github.com/mattermost/mattermost-plugin-apps/apps"
+	"github.com/mattermost/mattermost-plugin-apps/apps/appclient"
+	"github.com/mattermost/mattermost-server/v6/model"
+)
+
+const (
+	// mattermostClientAttempts is the number of times an idempotent request is tried
+	mattermostClientAttempts = 3
+	// mattermostClientBackoff is the delay before the first retry; it doubles with every retry
+	mattermostClientBackoff = 200 * time.Millisecond
+)
+
+// mattermostClient is the part of the Mattermost REST and Apps plugin APIs that the app uses
+type mattermostClient interface {
+	CreatePost(post *model.Post) (*model.Post, error)
+	UpdatePost(post *model.Post) (*model.Post, error)
+	// DM sends a direct message from the client's user, which must be known to the client
+	DM(userID string, format string, args ...interface{}) (*model.Post, error)
+	// DMPost sends a post, such as one with attachments, as a direct message from the client's user
+	DMPost(userID string, post *model.Post) (*model.Post, error)
+	AddChannelMember(channelID string, userID string) error
+	Subscribe(subscription *apps.Subscription) error
+	Unsubscribe(subscription *apps.Subscription) error
+	KVSet(prefix string, id string, value interface{}) (bool, error)
+	KVGet(prefix string, id string, ref interface{}) error
+	KVDelete(prefix string, id string) error
+	StoreOAuth2App(oauth2App apps.OAuth2App) error
+	StoreOAuth2User(ref interface{}) error
+	AutocompleteUsers(query string, limit int) ([]*model.User, error)
+	AutocompleteChannelsForTeam(teamID string, query string) ([]*model.Channel, error)
+}
+
+// addBotToChannel makes the bot a member of the channel, which it must be to post there, whether now or later
+// from a schedule, webhook or subscription
+func addBotToChannel(clt mattermostClient, appContext apps.Context, channelID string) error {
+	err := clt.AddChannelMember(channelID, appContext.BotUserID)
+	if err != nil {
+		return newUpstreamError(err, "error adding bot to channel")
+	}
+	return nil
+}
+
+// newMattermostClient wraps an appclient whose requests are bound to the context
+func newMattermostClient(ctx context.Context, clt *appclient.Client) mattermostClient {
+	return &retryingClient{
+		ctx: ctx,
+		clt: clt,
+	}
+}
+
+// retryingClient is the mattermostClient backed by the Mattermost server. Idempotent requests that fail with a
+// transient error are retried with an exponential backoff until the call's context ends.
+type retryingClient struct {
+	ctx context.Context
+	clt *appclient.Client
+}
+
+// isTransientFailure reports whether a failed request may succeed if it is sent again
+func isTransientFailure(response *model.Response, err error) bool {
+	if errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded) {
+		return false
+	}
+	if response != nil && response.StatusCode != 0 {
+		return response.StatusCode == http.StatusTooManyRequests || response.StatusCode >= http.StatusInternalServerError
+	}
+	var netErr net.Error
+	return errors.As(err, &netErr)
+}
+
+// retry sends the request until it succeeds, fails with an error that is not transient, or runs out of attempts
+func (rc *retryingClient) retry(name string, request func() (*model.Response, error)) error {
+	backoff := mattermostClientBackoff
+	var err error
+	for attempt := 1; attempt <= mattermostClientAttempts; attempt++ {
+		var response *model.Response
+		response, err = request()
+		if err == nil || !isTransientFailure(response, err) || attempt == mattermostClientAttempts {
+			break
+		}
+		log.Printf("retryingClient.%s(): attempt %d failed, retrying in %s: %s\n", name, attempt, backoff, err.Error())
+		select {
+		case <-rc.ctx.Done():
+			return err
+		case <-time.After(backoff):
+		}
+		backoff *= 2
+	}
+	return err
+}
+
+// CreatePost is not retried because a retry could post the message twice
+func (rc *retryingClient) CreatePost(post *model.Post) (*model.Post, error) {
+	return rc.clt.CreatePost(post)
+}
+
+func (rc *retryingClient) UpdatePost(post *model.Post) (*model.Post, error) {
+	var updatedPost *model.Post
+	err := rc.retry("UpdatePost", func() (*model.Response, error) {
+		var response *model.Response
+		var err error
+		updatedPost, response, err = rc.clt.Client4.UpdatePost(post.Id, post)
+		return response, err
+	})
+	return updatedPost, err
+}
+
+// DM is not retried because a retry could send the message twice
+func (rc *retryingClient) DM(userID string, format string, args ...interface{}) (*model.Post, error) {
+	return rc.clt.DM(userID, format, args...)
+}
+
+// DMPost is not retried because a retry could send the message twice
+func (rc *retryingClient) DMPost(userID string, post *model.Post) (*model.Post, error) {
+	return rc.clt.DMPost(userID, post)
+}
+
+func (rc *retryingClient) AddChannelMember(channelID string, userID string) error {
+	return rc.retry("AddChannelMember", func() (*model.Response, error) {
+		_, response, err := rc.clt.AddChannelMember(channelID, userID)
+		return response, err
+	})
+}
+
+func (rc *retryingClient) Subscribe(subscription *apps.Subscription) error {
+	return rc.retry("Subscribe", func() (*model.Response, error) {
+		return rc.clt.ClientPP.Subscribe(subscription)
+	})
+}
+
+func (rc *retryingClient) Unsubscribe(subscription *apps.Subscription) error {
+	return rc.retry("Unsubscribe", func() (*model.Response, error) {
+		return rc.clt.ClientPP.Unsubscribe(subscription)
+	})
+}
+
+func (rc *retryingClient) KVSet(prefix string, id string, value interface{}) (bool, error) {
+	var changed bool
+	err := rc.retry("KVSet", func() (*model.Response, error) {
+		var response *model.Response
+		var err error
+		changed, response, err = rc.clt.ClientPP.KVSet(prefix, id, value)
+		return response, err
+	})
+	return changed, err
+}
+
+func (rc *retryingClient) KVGet(prefix string, id string, ref interface{}) error {
+	return rc.retry("KVGet", func() (*model.Response, error) {
+		return rc.clt.ClientPP.KVGet(prefix, id, ref)
+	})
+}
+
+func (rc *retryingClient) KVDelete(prefix string, id string) error {
+	return rc.retry("KVDelete", func() (*model.Response, error) {
+		return rc.clt.ClientPP.KVDelete(prefix, id)
+	})
+}
+
+func (rc *retryingClient) StoreOAuth2App(oauth2App apps.OAuth2App) error {
+	return rc.retry("StoreOAuth2App", func() (*model.Response, error) {
+		return rc.clt.ClientPP.StoreOAuth2App(oauth2App)
+	})
+}
+
+func (rc *retryingClient) StoreOAuth2User(ref interface{}) error {
+	return rc.retry("StoreOAuth2User", func() (*model.Response, error) {
+		return rc.clt.ClientPP.StoreOAuth2User(ref)
+	})
+}
+
+func (rc *retryingClient) AutocompleteUsers(query string, limit int) ([]*model.User, error) {
+	var users []*model.User
+	err := rc.retry("AutocompleteUsers", func() (*model.Response, error) {
+		autocomplete, response, err := rc.clt.AutocompleteUsers(query, limit, "")
+		if err != nil {
+			return response, err
+		}
+		users = autocomplete.Users
+		return response, nil
+	})
+	return users, err
+}
+
+func (rc *retryingClient) AutocompleteChannelsForTeam(teamID string, query string) ([]*model.Channel, error) {
+	var channels []*model.Channel
+	err := rc.retry("AutocompleteChannelsForTeam", func() (*model.Response, error) {
+		channelList, response, err := rc.clt.AutocompleteChannelsForTeam(teamID, query)
+		channels = channelList
+		return response, err
+	})
+	return channels, err
+}
@@ -0,0 +1,309 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/mattermost/mattermost-plugin-apps/apps"
+	"github.com/mattermost/mattermost-plugin-apps/apps/appclient"
+	"github.com/mattermost/mattermost-server/v6/model"
+)
+
+// fakeMattermostClient is an in-memory mattermostClient that records what the app does. Unit tests hand it to
+// the functions that take a client; handler tests use the fake Mattermost server of newAppTest instead.
+type fakeMattermostClient struct {
+	mutex sync.Mutex
+	// UserID is the user that DMs are sent from
+	UserID         string
+	Posts          []*model.Post
+	Subscriptions  []*apps.Subscription
+	ChannelMembers map[string][]string
+	KV             map[string]map[string][]byte
+	OAuth2App      *apps.OAuth2App
+	OAuth2User     []byte
+	Users          []*model.User
+	Channels       []*model.Channel
+	// Errors makes the named method fail with the error
+	Errors map[string]error
+}
+
+func newFakeMattermostClient(userID string) *fakeMattermostClient {
+	return &fakeMattermostClient{
+		UserID:         userID,
+		ChannelMembers: make(map[string][]string),
+		KV:             make(map[string]map[string][]byte),
+		Errors:         make(map[string]error),
+	}
+}
+
+// failure returns the error configured for the named method
+func (f *fakeMattermostClient) failure(name string) error {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	return f.Errors[name]
+}
+
+func (f *fakeMattermostClient) CreatePost(post *model.Post) (*model.Post, error) {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	if err := f.Errors["CreatePost"]; err != nil {
+		return nil, err
+	}
+	created := post.Clone()
+	created.Id = model.NewId()
+	f.Posts = append(f.Posts, created)
+	return created, nil
+}
+
+func (f *fakeMattermostClient) UpdatePost(post *model.Post) (*model.Post, error) {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	if err := f.Errors["UpdatePost"]; err != nil {
+		return nil, err
+	}
+	for i, existing := range f.Posts {
+		if existing.Id == post.Id {
+			f.Posts[i] = post.Clone()
+			return f.Posts[i], nil
+		}
+	}
+	return nil, fmt.Errorf("post %s not found", post.Id)
+}
+
+func (f *fakeMattermostClient) DM(userID string, format string, args ...interface{}) (*model.Post, error) {
+	if err := f.failure("DM"); err != nil {
+		return nil, err
+	}
+	if f.UserID == "" {
+		return nil, fmt.Errorf("empty sender user_id")
+	}
+	return f.CreatePost(&model.Post{
+		ChannelId: model.GetDMNameFromIds(f.UserID, userID),
+		Message:   fmt.Sprintf(format, args...),
+	})
+}
+
+func (f *fakeMattermostClient) DMPost(userID string, post *model.Post) (*model.Post, error) {
+	if f.UserID == "" {
+		return nil, fmt.Errorf("empty sender user_id")
+	}
+	post.ChannelId = model.GetDMNameFromIds(f.UserID, userID)
+	return f.CreatePost(post)
+}
+
+func (f *fakeMattermostClient) AddChannelMember(channelID string, userID string) error {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	if err := f.Errors["AddChannelMember"]; err != nil {
+		return err
+	}
+	if !containsString(f.ChannelMembers[channelID], userID) {
+		f.ChannelMembers[channelID] = append(f.ChannelMembers[channelID], userID)
+	}
+	return nil
+}
+
+func (f *fakeMattermostClient) Subscribe(subscription *apps.Subscription) error {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	if err := f.Errors["Subscribe"]; err != nil {
+		return err
+	}
+	f.Subscriptions = append(f.Subscriptions, subscription)
+	return nil
+}
+
+func (f *fakeMattermostClient) Unsubscribe(subscription *apps.Subscription) error {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	if err := f.Errors["Unsubscribe"]; err != nil {
+		return err
+	}
+	for i, existing := range f.Subscriptions {
+		if existing.Event == subscription.Event {
+			f.Subscriptions = append(f.Subscriptions[:i], f.Subscriptions[i+1:]...)
+			return nil
+		}
+	}
+	return fmt.Errorf("not subscribed to %s", subscription.Event.Subject)
+}
+
+func (f *fakeMattermostClient) KVSet(prefix string, id string, value interface{}) (bool, error) {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	if err := f.Errors["KVSet"]; err != nil {
+		return false, err
+	}
+	data, err := json.Marshal(value)
+	if err != nil {
+		return false, err
+	}
+	if f.KV[prefix] == nil {
+		f.KV[prefix] = make(map[string][]byte)
+	}
+	changed := string(f.KV[prefix][id]) != string(data)
+	f.KV[prefix][id] = data
+	return changed, nil
+}
+
+// KVGet leaves ref unchanged for a missing key, like the Apps plugin
+func (f *fakeMattermostClient) KVGet(prefix string, id string, ref interface{}) error {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	if err := f.Errors["KVGet"]; err != nil {
+		return err
+	}
+	data, ok := f.KV[prefix][id]
+	if !ok {
+		return nil
+	}
+	return json.Unmarshal(data, ref)
+}
+
+func (f *fakeMattermostClient) KVDelete(prefix string, id string) error {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	if err := f.Errors["KVDelete"]; err != nil {
+		return err
+	}
+	delete(f.KV[prefix], id)
+	return nil
+}
+
+func (f *fakeMattermostClient) StoreOAuth2App(oauth2App apps.OAuth2App) error {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	if err := f.Errors["StoreOAuth2App"]; err != nil {
+		return err
+	}
+	f.OAuth2App = &oauth2App
+	return nil
+}
+
+func (f *fakeMattermostClient) StoreOAuth2User(ref interface{}) error {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	if err := f.Errors["StoreOAuth2User"]; err != nil {
+		return err
+	}
+	data, err := json.Marshal(ref)
+	if err != nil {
+		return err
+	}
+	f.OAuth2User = data
+	return nil
+}
+
+func (f *fakeMattermostClient) AutocompleteUsers(query string, limit int) ([]*model.User, error) {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	if err := f.Errors["AutocompleteUsers"]; err != nil {
+		return nil, err
+	}
+	users := make([]*model.User, 0)
+	for _, user := range f.Users {
+		if strings.HasPrefix(user.Username, query) && len(users) < limit {
+			users = append(users, user)
+		}
+	}
+	return users, nil
+}
+
+func (f *fakeMattermostClient) AutocompleteChannelsForTeam(teamID string, query string) ([]*model.Channel, error) {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	if err := f.Errors["AutocompleteChannelsForTeam"]; err != nil {
+		return nil, err
+	}
+	channels := make([]*model.Channel, 0)
+	for _, channel := range f.Channels {
+		if channel.TeamId == teamID && strings.HasPrefix(channel.Name, query) {
+			channels = append(channels, channel)
+		}
+	}
+	return channels, nil
+}
+
+// flakyServer fails the first failures requests to every path with the status and then answers with the body
+func flakyServer(t *testing.T, failures int, status int, body string) (*httptest.Server, map[string]int) {
+	t.Helper()
+	var mutex sync.Mutex
+	requests := make(map[string]int)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mutex.Lock()
+		requests[r.URL.Path]++
+		count := requests[r.URL.Path]
+		mutex.Unlock()
+		w.Header().Set("Content-Type", "application/json")
+		if count <= failures {
+			w.WriteHeader(status)
+			_, _ = w.Write([]byte(fmt.Sprintf(`{"id":"test","message":"failure","status_code":%d}`, status)))
+			return
+		}
+		w.WriteHeader(http.StatusCreated)
+		_, _ = w.Write([]byte(body))
+	}))
+	t.Cleanup(server.Close)
+	return server, requests
+}
+
+func TestRetryingClientRetriesIdempotentRequests(t *testing.T) {
+	server, requests := flakyServer(t, 2, http.StatusServiceUnavailable, `{"changed":true}`)
+	clt := newMattermostClient(context.Background(), appclient.NewClient("token", server.URL))
+	changed, err := clt.KVSet("prefix", "id", "value")
+	if err != nil {
+		t.Fatalf("KVSet failed: %s", err.Error())
+	}
+	if !changed {
+		t.Errorf("KVSet did not report the change")
+	}
+	if got := requests["/plugins/com.mattermost.apps/api/v1/kv/prefix/id"]; got != 3 {
+		t.Errorf("KVSet sent %d requests, want 3", got)
+	}
+}
+
+func TestRetryingClientDoesNotRetryClientErrors(t *testing.T) {
+	server, requests := flakyServer(t, 1, http.StatusForbidden, `{}`)
+	clt := newMattermostClient(context.Background(), appclient.NewClient("token", server.URL))
+	err := clt.AddChannelMember("channel", "user")
+	if err == nil {
+		t.Fatalf("AddChannelMember succeeded, want an error")
+	}
+	if got := requests["/api/v4/channels/channel/members"]; got != 1 {
+		t.Errorf("AddChannelMember sent %d requests, want 1", got)
+	}
+}
+
+func TestRetryingClientDoesNotRetryPosts(t *testing.T) {
+	server, requests := flakyServer(t, 1, http.StatusServiceUnavailable, `{"id":"post"}`)
+	clt := newMattermostClient(context.Background(), appclient.NewClient("token", server.URL))
+	_, err := clt.CreatePost(&model.Post{ChannelId: "channel", Message: "hello"})
+	if err == nil {
+		t.Fatalf("CreatePost succeeded, want an error")
+	}
+	if got := requests["/api/v4/posts"]; got != 1 {
+		t.Errorf("CreatePost sent %d requests, want 1", got)
+	}
+}
+
+func TestAddBotToChannel(t *testing.T) {
+	fake := newFakeMattermostClient(testBotUserID)
+	appContext := apps.Context{}
+	appContext.BotUserID = testBotUserID
+	err := addBotToChannel(fake, appContext, "channel")
+	if err != nil || !containsString(fake.ChannelMembers["channel"], testBotUserID) {
+		t.Errorf("got error %v and members %v, want the bot added", err, fake.ChannelMembers)
+	}
+	fake.Errors["AddChannelMember"] = errors.New("forbidden")
+	err = addBotToChannel(fake, appContext, "other")
+	if err == nil || !strings.Contains(err.Error(), "error adding bot to channel") {
+		t.Errorf("got error %v, want an upstream error", err)
+	}
+}
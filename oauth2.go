@@ -0,0 +1,248 @@
+package main
+
+import (
+	"log"
+	"net/http"
+	"strings"
+
+	"github.com/mattermost/mattermost-plugin-apps/apps"
+	"github.com/mattermost/mattermost-plugin-apps/utils"
+	"golang.org/x/oauth2"
+)
+
+// oauth2ProviderData holds the endpoints of the generic OAuth2 provider; it is
+// stored in the Data field of the remote OAuth2 app configuration
+type oauth2ProviderData struct {
+	AuthURL  string   `json:"auth_url"`
+	TokenURL string   `json:"token_url"`
+	Scopes   []string `json:"scopes,omitempty"`
+}
+
+// oauth2User is the per-user record stored with appclient.StoreOAuth2User
+type oauth2User struct {
+	Token *oauth2.Token `json:"token,omitempty"`
+}
+
+var (
+	oauth2Expand = apps.Expand{
+		ActingUser: apps.ExpandSummary,
+		OAuth2App:  apps.ExpandAll,
+		OAuth2User: apps.ExpandAll,
+	}
+
+	configureOAuth2Form = apps.Form{
+		Title:  "Configure OAuth2",
+		Header: "Configure the remote OAuth2 provider used by `connect`",
+		Icon:   "icon.png",
+		Fields: []apps.Field{
+			{
+				Name:        "client_id",
+				Label:       "client_id",
+				Type:        apps.FieldTypeText,
+				TextSubtype: apps.TextFieldSubtypeInput,
+				Description: "The OAuth2 client ID",
+				IsRequired:  true,
+			},
+			{
+				Name:        "client_secret",
+				Label:       "client_secret",
+				Type:        apps.FieldTypeText,
+				TextSubtype: apps.TextFieldSubtypePassword,
+				Description: "The OAuth2 client secret",
+				IsRequired:  true,
+			},
+			{
+				Name:        "auth_url",
+				Label:       "auth_url",
+				Type:        apps.FieldTypeText,
+				TextSubtype: apps.TextFieldSubtypeURL,
+				Description: "The provider's authorization endpoint",
+				IsRequired:  true,
+			},
+			{
+				Name:        "token_url",
+				Label:       "token_url",
+				Type:        apps.FieldTypeText,
+				TextSubtype: apps.TextFieldSubtypeURL,
+				Description: "The provider's token endpoint",
+				IsRequired:  true,
+			},
+			{
+				Name:        "scopes",
+				Label:       "scopes",
+				Type:        apps.FieldTypeText,
+				TextSubtype: apps.TextFieldSubtypeInput,
+				Description: "A comma-separated list of scopes to request",
+			},
+		},
+		Submit: apps.NewCall("/configure-oauth2").ExpandActingUserClient(),
+	}
+)
+
+// oauth2Config builds an oauth2.Config from the expanded OAuth2 context
+func oauth2Config(oauth2Context apps.OAuth2Context) (*oauth2.Config, error) {
+	if oauth2Context.ClientID == "" || oauth2Context.ClientSecret == "" {
+		return nil, newUserInputError("OAuth2 is not configured; ask a system administrator to run the configure-oauth2 command")
+	}
+	providerData := oauth2ProviderData{}
+	utils.Remarshal(&providerData, oauth2Context.Data)
+	if providerData.AuthURL == "" || providerData.TokenURL == "" {
+		return nil, newUserInputError("OAuth2 provider endpoints are not configured")
+	}
+	return &oauth2.Config{
+		ClientID:     oauth2Context.ClientID,
+		ClientSecret: oauth2Context.ClientSecret,
+		Endpoint: oauth2.Endpoint{
+			AuthURL:  providerData.AuthURL,
+			TokenURL: providerData.TokenURL,
+		},
+		RedirectURL: oauth2Context.CompleteURL,
+		Scopes:      providerData.Scopes,
+	}, nil
+}
+
+// connectedOAuth2User returns the stored OAuth2 user from the expanded context, or nil if the user is not connected
+func connectedOAuth2User(oauth2Context apps.OAuth2Context) *oauth2User {
+	if oauth2Context.User == nil {
+		return nil
+	}
+	user := oauth2User{}
+	utils.Remarshal(&user, oauth2Context.User)
+	if user.Token == nil || user.Token.AccessToken == "" {
+		return nil
+	}
+	return &user
+}
+
+func configureOAuth2(w http.ResponseWriter, r *http.Request) {
+	callRequest, err := getCallRequest(r)
+	if err != nil {
+		log.Printf("configureOAuth2(): %s\n", err.Error())
+		sendErrorResponse(w, err)
+		return
+	}
+	scopes := make([]string, 0)
+	for _, scope := range strings.Split(callRequest.GetValue("scopes", ""), ",") {
+		scope = strings.TrimSpace(scope)
+		if scope != "" {
+			scopes = append(scopes, scope)
+		}
+	}
+	oauth2App := apps.OAuth2App{
+		ClientID:     callRequest.GetValue("client_id", ""),
+		ClientSecret: callRequest.GetValue("client_secret", ""),
+		Data: oauth2ProviderData{
+			AuthURL:  callRequest.GetValue("auth_url", ""),
+			TokenURL: callRequest.GetValue("token_url", ""),
+			Scopes:   scopes,
+		},
+	}
+	if oauth2App.ClientID == "" || oauth2App.ClientSecret == "" {
+		sendErrorResponse(w, newUserInputError("client_id and client_secret are required"))
+		return
+	}
+	// only a system administrator may store the OAuth2 app configuration
+	clt := asActingUser(r.Context(), callRequest.Context)
+	err = clt.StoreOAuth2App(oauth2App)
+	if err != nil {
+		err = newUpstreamError(err, "error storing OAuth2 configuration")
+		sendErrorResponse(w, err)
+		return
+	}
+	sendCallResponse(w, apps.NewTextResponse("successfully configured OAuth2"))
+}
+
+func oauth2Connect(w http.ResponseWriter, r *http.Request) {
+	callRequest, err := getCallRequest(r)
+	if err != nil {
+		log.Printf("oauth2Connect(): %s\n", err.Error())
+		sendErrorResponse(w, err)
+		return
+	}
+	if connectedOAuth2User(callRequest.Context.OAuth2) != nil {
+		sendCallResponse(w, apps.NewTextResponse("your account is already connected; use `disconnect` first to reconnect it"))
+		return
+	}
+	_, err = oauth2Config(callRequest.Context.OAuth2)
+	if err != nil {
+		sendErrorResponse(w, err)
+		return
+	}
+	sendCallResponse(w, apps.NewTextResponse("[Connect your account](%s)", callRequest.Context.OAuth2.ConnectURL))
+}
+
+func oauth2Disconnect(w http.ResponseWriter, r *http.Request) {
+	callRequest, err := getCallRequest(r)
+	if err != nil {
+		log.Printf("oauth2Disconnect(): %s\n", err.Error())
+		sendErrorResponse(w, err)
+		return
+	}
+	if connectedOAuth2User(callRequest.Context.OAuth2) == nil {
+		sendCallResponse(w, apps.NewTextResponse("your account is not connected"))
+		return
+	}
+	clt := asActingUser(r.Context(), callRequest.Context)
+	err = clt.StoreOAuth2User(oauth2User{})
+	if err != nil {
+		err = newUpstreamError(err, "error removing OAuth2 token")
+		sendErrorResponse(w, err)
+		return
+	}
+	sendCallResponse(w, apps.NewTextResponse("successfully disconnected your account"))
+}
+
+// oauth2ConnectURL answers the GetOAuth2ConnectURL call with the provider's authorization URL
+func oauth2ConnectURL(w http.ResponseWriter, r *http.Request) {
+	callRequest, err := getCallRequest(r)
+	if err != nil {
+		log.Printf("oauth2ConnectURL(): %s\n", err.Error())
+		sendErrorResponse(w, err)
+		return
+	}
+	state := callRequest.GetValue("state", "")
+	if state == "" {
+		sendErrorResponse(w, newUserInputError("state not specified"))
+		return
+	}
+	config, err := oauth2Config(callRequest.Context.OAuth2)
+	if err != nil {
+		sendErrorResponse(w, err)
+		return
+	}
+	sendCallResponse(w, apps.NewDataResponse(config.AuthCodeURL(state)))
+}
+
+// oauth2Complete answers the OnOAuth2Complete call by exchanging the code for a token and storing it for the acting user
+func oauth2Complete(w http.ResponseWriter, r *http.Request) {
+	callRequest, err := getCallRequest(r)
+	if err != nil {
+		log.Printf("oauth2Complete(): %s\n", err.Error())
+		sendErrorResponse(w, err)
+		return
+	}
+	code := callRequest.GetValue("code", "")
+	if code == "" {
+		sendErrorResponse(w, newUserInputError("code not specified"))
+		return
+	}
+	config, err := oauth2Config(callRequest.Context.OAuth2)
+	if err != nil {
+		sendErrorResponse(w, err)
+		return
+	}
+	token, err := config.Exchange(r.Context(), code)
+	if err != nil {
+		err = newUpstreamError(err, "error exchanging OAuth2 code")
+		sendErrorResponse(w, err)
+		return
+	}
+	clt := asActingUser(r.Context(), callRequest.Context)
+	err = clt.StoreOAuth2User(oauth2User{Token: token})
+	if err != nil {
+		err = newUpstreamError(err, "error storing OAuth2 token")
+		sendErrorResponse(w, err)
+		return
+	}
+	sendCallResponse(w, apps.NewTextResponse("successfully connected your account"))
+}
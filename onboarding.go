@@ -0,0 +1,99 @@
+package main
+
+import (
+	"context"
+	"errors"
+
+	"github.com/mattermost/mattermost-plugin-apps/apps"
+)
+
+const onboardingKVPrefix = "onboarding"
+
+// onboardingAnswers is the decoded result of the onboarding wizard
+type onboardingAnswers struct {
+	Name          string             `value:"name"`
+	Role          apps.SelectOption  `value:"role"`
+	Channel       *apps.SelectOption `value:"channel"`
+	Notifications bool               `value:"notifications"`
+}
+
+var onboardingWizard = &wizard{
+	Path:  "/onboarding",
+	Title: "Welcome aboard!",
+	Icon:  "icon.png",
+	Expand: &apps.Expand{
+		ActingUser: apps.ExpandID,
+		Team:       apps.ExpandID,
+	},
+	Steps: []wizardStep{
+		{
+			Title:  "About you",
+			Header: "Tell us a little about yourself.",
+			Fields: []apps.Field{
+				{
+					Type:       apps.FieldTypeText,
+					Name:       "name",
+					Label:      "Name",
+					IsRequired: true,
+				},
+				{
+					Type:       apps.FieldTypeStaticSelect,
+					Name:       "role",
+					Label:      "Role",
+					IsRequired: true,
+					SelectStaticOptions: []apps.SelectOption{
+						{
+							Label: "Developer",
+							Value: "developer",
+						},
+						{
+							Label: "Designer",
+							Value: "designer",
+						},
+						{
+							Label: "Manager",
+							Value: "manager",
+						},
+					},
+				},
+			},
+		},
+		{
+			Title:  "Preferences",
+			Header: "Choose how the app should keep in touch.",
+			Fields: []apps.Field{
+				{
+					Type:  apps.FieldTypeChannel,
+					Name:  "channel",
+					Label: "Favourite channel",
+				},
+				{
+					Type:  apps.FieldTypeBool,
+					Name:  "notifications",
+					Label: "Send me notifications",
+				},
+			},
+		},
+	},
+	OnComplete: completeOnboarding,
+}
+
+func completeOnboarding(ctx context.Context, callRequest *apps.CallRequest, answers map[string]interface{}) (apps.CallResponse, error) {
+	values := onboardingAnswers{}
+	err := decodeValues(answers, &values)
+	if err != nil {
+		return apps.CallResponse{}, err
+	}
+	if callRequest.Context.ActingUser == nil {
+		return apps.CallResponse{}, errors.New("acting user not expanded")
+	}
+	clt := asBot(ctx, callRequest.Context)
+	_, err = clt.KVSet(onboardingKVPrefix, callRequest.Context.ActingUser.Id, answers)
+	if err != nil {
+		return apps.CallResponse{}, newUpstreamError(err, "error storing onboarding answers")
+	}
+	return apps.NewTextResponse("%s", renderMessage(ctx, callRequest.Context, "onboarding_complete", messageData{
+		"Name": values.Name,
+		"Role": values.Role.Label,
+	})), nil
+}
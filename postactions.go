@@ -0,0 +1,404 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+	"regexp"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/mattermost/mattermost-plugin-apps/apps"
+	"github.com/mattermost/mattermost-server/v6/model"
+)
+
+const (
+	notesKVPrefix = "notes"
+	// maxNotes is the number of notes kept per user; saving another note drops the oldest
+	maxNotes = 50
+	// noteExcerptLength is the number of characters of a post shown in note listings and reminders
+	noteExcerptLength = 80
+	// reminderTimeFormat is how the time of a reminder is shown to the user
+	reminderTimeFormat = "Mon Jan 2 15:04 MST"
+)
+
+// savedNote is a post that a user saved to their notes
+type savedNote struct {
+	PostID    string    `json:"post_id"`
+	ChannelID string    `json:"channel_id"`
+	Message   string    `json:"message"`
+	SavedAt   time.Time `json:"saved_at"`
+}
+
+// remindValues are the values of the "Remind me about this" form
+type remindValues struct {
+	When string `value:"when"`
+}
+
+// shareValues are the values of the "Share to channel" form
+type shareValues struct {
+	Channel apps.SelectOption `value:"channel"`
+	Comment string            `value:"comment"`
+}
+
+var (
+	// mentionPattern matches the @ that starts a mention, with the character before it, and the first character
+	// of the mentioned name; an @ inside a word, as in an email address, is not a mention
+	mentionPattern = regexp.MustCompile(`(^|[^\pL\pN_.-])@([\pL\pN_.-])`)
+
+	postActionExpand = apps.Expand{
+		ActingUser: apps.ExpandSummary,
+		Post:       apps.ExpandAll,
+		Team:       apps.ExpandID,
+	}
+
+	postShareExpand = apps.Expand{
+		ActingUser:            apps.ExpandSummary,
+		ActingUserAccessToken: apps.ExpandAll,
+		Post:                  apps.ExpandAll,
+		Team:                  apps.ExpandID,
+	}
+
+	// postActionBindings act on the post that the menu was opened on
+	postActionBindings = []apps.Binding{
+		{
+			Location: "save-note",
+			Icon:     "icon.png",
+			Label:    "Save to my notes",
+			Submit:   apps.NewCall("/post/save-note").WithExpand(postActionExpand),
+		},
+		{
+			Location: "remind",
+			Icon:     "icon.png",
+			Label:    "Remind me about this",
+			Form: &apps.Form{
+				Title: "Remind me about this",
+				Icon:  "icon.png",
+				Fields: []apps.Field{
+					{
+						Name:        "when",
+						Label:       "When",
+						Type:        apps.FieldTypeText,
+						TextSubtype: apps.TextFieldSubtypeInput,
+						Description: "A delay such as 30m or 2h, or a time of day such as 14:30 in your timezone",
+						IsRequired:  true,
+					},
+				},
+				Submit: apps.NewCall("/post/remind").WithExpand(postActionExpand),
+			},
+		},
+		{
+			Location: "share",
+			Icon:     "icon.png",
+			Label:    "Share to channel",
+			Form: &apps.Form{
+				Title: "Share to channel",
+				Icon:  "icon.png",
+				Fields: []apps.Field{
+					{
+						Name:        "channel",
+						Label:       "Channel",
+						Type:        apps.FieldTypeChannel,
+						Description: "The channel to share the message to",
+						IsRequired:  true,
+					},
+					{
+						Name:        "comment",
+						Label:       "Comment",
+						Type:        apps.FieldTypeText,
+						TextSubtype: apps.TextFieldSubtypeTextarea,
+						Description: "An optional comment posted with the message",
+					},
+				},
+				Submit: apps.NewCall("/post/share").WithExpand(postShareExpand),
+			},
+		},
+	}
+
+	notesBinding = apps.Binding{
+		Location:    "notes",
+		Label:       "notes",
+		Description: "List the posts saved to your notes",
+		Submit: apps.NewCall("/notes").WithExpand(apps.Expand{
+			ActingUser: apps.ExpandSummary,
+		}),
+	}
+)
+
+// postPermalink returns the URL that opens the post in its channel
+func postPermalink(appContext apps.Context, postID string) string {
+	return fmt.Sprintf("%s/_redirect/pl/%s", appContext.MattermostSiteURL, postID)
+}
+
+// postExcerpt returns the first line of a message, shortened to noteExcerptLength characters
+func postExcerpt(message string) string {
+	excerpt := strings.TrimSpace(strings.SplitN(strings.TrimSpace(message), "\n", 2)[0])
+	if utf8.RuneCountInString(excerpt) > noteExcerptLength {
+		excerpt = string([]rune(excerpt)[:noteExcerptLength]) + "…"
+	}
+	if excerpt == "" {
+		excerpt = "(no text)"
+	}
+	return excerpt
+}
+
+// quoteMessage formats every line of a message as a Markdown block quote. The bot posts the quote, so its
+// mentions are neutralized to not notify anyone again.
+func quoteMessage(message string) string {
+	return "> " + strings.ReplaceAll(neutralizeMentions(strings.TrimSpace(message)), "\n", "\n> ")
+}
+
+// neutralizeMentions puts a zero-width space after the @ of every mention, such as @channel, @here or
+// @username, so that the text looks the same but notifies nobody when the bot posts it
+func neutralizeMentions(text string) string {
+	return mentionPattern.ReplaceAllString(text, "${1}@\u200b$2")
+}
+
+// requirePostContext makes sure that the call was made from a post menu by a user
+func requirePostContext(appContext apps.Context) error {
+	if appContext.Post == nil || appContext.ActingUser == nil {
+		return newUserInputError("this action must be used from the menu of a post")
+	}
+	return nil
+}
+
+// parseReminderTime returns the time described by a delay such as "90m" or a time of day such as "14:30" in
+// the supplied location; a time of day that has already passed today is taken to mean tomorrow
+func parseReminderTime(when string, now time.Time, location *time.Location) (time.Time, error) {
+	when = strings.TrimSpace(when)
+	delay, err := time.ParseDuration(when)
+	if err == nil {
+		if delay <= 0 {
+			return time.Time{}, newUserInputError("the delay must be positive")
+		}
+		return now.Add(delay), nil
+	}
+	clock, err := time.Parse("15:04", when)
+	if err != nil {
+		return time.Time{}, newUserInputError("invalid time %q; use a delay such as 30m or a time of day such as 14:30", when)
+	}
+	local := now.In(location)
+	remindAt := time.Date(local.Year(), local.Month(), local.Day(), clock.Hour(), clock.Minute(), 0, 0, location)
+	if !remindAt.After(now) {
+		remindAt = remindAt.AddDate(0, 0, 1)
+	}
+	return remindAt, nil
+}
+
+// getNotes reads the notes saved by a user from the KV store
+func getNotes(clt mattermostClient, userID string) ([]savedNote, error) {
+	notes := make([]savedNote, 0)
+	err := clt.KVGet(notesKVPrefix, userID, &notes)
+	if err != nil {
+		return nil, err
+	}
+	return notes, nil
+}
+
+func savePostNote(w http.ResponseWriter, r *http.Request) {
+	callRequest, err := getCallRequest(r)
+	if err != nil {
+		log.Printf("savePostNote(): %s\n", err.Error())
+		sendErrorResponse(w, err)
+		return
+	}
+	err = requirePostContext(callRequest.Context)
+	if err != nil {
+		sendErrorResponse(w, err)
+		return
+	}
+	post := callRequest.Context.Post
+	userID := callRequest.Context.ActingUser.Id
+	clt := asBot(r.Context(), callRequest.Context)
+	// the notes are one value, see kvUpdateMutex
+	kvUpdateMutex.Lock()
+	defer kvUpdateMutex.Unlock()
+	notes, err := getNotes(clt, userID)
+	if err != nil {
+		err = newUpstreamError(err, "error reading notes")
+		sendErrorResponse(w, err)
+		return
+	}
+	for _, note := range notes {
+		if note.PostID == post.Id {
+			sendErrorResponse(w, newUserInputError("this message is already in your notes"))
+			return
+		}
+	}
+	notes = append(notes, savedNote{
+		PostID:    post.Id,
+		ChannelID: post.ChannelId,
+		Message:   post.Message,
+		SavedAt:   time.Now().UTC(),
+	})
+	if len(notes) > maxNotes {
+		notes = notes[len(notes)-maxNotes:]
+	}
+	_, err = clt.KVSet(notesKVPrefix, userID, notes)
+	if err != nil {
+		err = newUpstreamError(err, "error storing notes")
+		sendErrorResponse(w, err)
+		return
+	}
+	sendCallResponse(w, apps.NewTextResponse("%s", renderMessage(r.Context(), callRequest.Context, "note_saved", messageData{
+		"Command": fmt.Sprintf("/%s notes", appManifest.AppID),
+	})))
+}
+
+func listNotes(w http.ResponseWriter, r *http.Request) {
+	callRequest, err := getCallRequest(r)
+	if err != nil {
+		log.Printf("listNotes(): %s\n", err.Error())
+		sendErrorResponse(w, err)
+		return
+	}
+	if callRequest.Context.ActingUser == nil {
+		sendErrorResponse(w, newUserInputError("acting user not expanded"))
+		return
+	}
+	clt := asBot(r.Context(), callRequest.Context)
+	notes, err := getNotes(clt, callRequest.Context.ActingUser.Id)
+	if err != nil {
+		err = newUpstreamError(err, "error reading notes")
+		sendErrorResponse(w, err)
+		return
+	}
+	if len(notes) == 0 {
+		sendCallResponse(w, apps.NewTextResponse("you have no saved notes; use \"Save to my notes\" in the menu of a post"))
+		return
+	}
+	responseText := "## Your notes\n"
+	// newest first
+	for i := len(notes) - 1; i >= 0; i-- {
+		note := notes[i]
+		responseText += fmt.Sprintf(
+			"- [%s](%s), saved %s\n",
+			postExcerpt(note.Message),
+			postPermalink(callRequest.Context, note.PostID),
+			note.SavedAt.Format("2006-01-02"),
+		)
+	}
+	sendCallResponse(w, apps.NewTextResponse("%s", responseText))
+}
+
+func remindAboutPost(w http.ResponseWriter, r *http.Request) {
+	callRequest, err := getCallRequest(r)
+	if err != nil {
+		log.Printf("remindAboutPost(): %s\n", err.Error())
+		sendErrorResponse(w, err)
+		return
+	}
+	err = requirePostContext(callRequest.Context)
+	if err != nil {
+		sendErrorResponse(w, err)
+		return
+	}
+	values := remindValues{}
+	err = decodeValues(callRequest.Values, &values)
+	if err != nil {
+		sendDecodeErrorResponse(w, err)
+		return
+	}
+	user := callRequest.Context.ActingUser
+	timezone := user.GetPreferredTimezone()
+	if timezone == "" {
+		timezone = "UTC"
+	}
+	location, err := time.LoadLocation(timezone)
+	if err != nil {
+		location = time.UTC
+	}
+	remindAt, err := parseReminderTime(values.When, time.Now(), location)
+	if err != nil {
+		sendCallResponse(w, newFieldErrorsResponse(map[string]string{"when": err.Error()}))
+		return
+	}
+	post := callRequest.Context.Post
+	job := scheduledJob{
+		ID:        model.NewId(),
+		ChannelID: post.ChannelId,
+		CreatedBy: user.Id,
+		RemindAt:  remindAt.Unix(),
+		UserID:    user.Id,
+		Message: fmt.Sprintf(
+			"Reminder about [this message](%s):\n%s",
+			postPermalink(callRequest.Context, post.Id),
+			quoteMessage(postExcerpt(post.Message)),
+		),
+	}
+	clt := asBot(r.Context(), callRequest.Context)
+	_, err = clt.KVSet(reminderKVPrefix, job.ID, job)
+	if err != nil {
+		err = newUpstreamError(err, "error storing reminder")
+		sendErrorResponse(w, err)
+		return
+	}
+	err = addToKVIndex(clt, reminderKVPrefix, job.ID)
+	if err != nil {
+		err = newUpstreamError(err, "error storing reminder index")
+		sendErrorResponse(w, err)
+		return
+	}
+	jobScheduler.put(job)
+	sendCallResponse(w, apps.NewTextResponse("%s", renderMessage(r.Context(), callRequest.Context, "reminder_set", messageData{
+		"Time": remindAt.In(location).Format(reminderTimeFormat),
+	})))
+}
+
+func sharePost(w http.ResponseWriter, r *http.Request) {
+	callRequest, err := getCallRequest(r)
+	if err != nil {
+		log.Printf("sharePost(): %s\n", err.Error())
+		sendErrorResponse(w, err)
+		return
+	}
+	err = requirePostContext(callRequest.Context)
+	if err != nil {
+		sendErrorResponse(w, err)
+		return
+	}
+	values := shareValues{}
+	err = decodeValues(callRequest.Values, &values)
+	if err != nil {
+		sendDecodeErrorResponse(w, err)
+		return
+	}
+	if values.Channel.Value == "" {
+		sendCallResponse(w, newFieldErrorsResponse(map[string]string{"channel": "select a channel"}))
+		return
+	}
+	if callRequest.Context.ActingUserAccessToken == "" {
+		sendErrorResponse(w, newForbiddenError("sharing a message requires the app to act as the user (the act_as_user permission)"))
+		return
+	}
+	// adding the bot as the user makes sure that the user may post in the channel
+	err = addBotToChannel(asActingUser(r.Context(), callRequest.Context), callRequest.Context, values.Channel.Value)
+	if err != nil {
+		sendErrorResponse(w, err)
+		return
+	}
+	post := callRequest.Context.Post
+	message := fmt.Sprintf(
+		"@%s shared [a message](%s):\n%s",
+		callRequest.Context.ActingUser.Username,
+		postPermalink(callRequest.Context, post.Id),
+		quoteMessage(post.Message),
+	)
+	// the bot may mention channels where the user could not
+	if comment := strings.TrimSpace(values.Comment); comment != "" {
+		message += "\n\n" + neutralizeMentions(comment)
+	}
+	_, err = asBot(r.Context(), callRequest.Context).CreatePost(&model.Post{
+		ChannelId: values.Channel.Value,
+		Message:   message,
+	})
+	if err != nil {
+		err = newUpstreamError(err, "error sharing message")
+		sendErrorResponse(w, err)
+		return
+	}
+	sendCallResponse(w, apps.NewTextResponse("%s", renderMessage(r.Context(), callRequest.Context, "post_shared", messageData{
+		"Channel": values.Channel.Label,
+	})))
+}
@@ -0,0 +1,243 @@
+package main
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"reflect"
+	"regexp"
+	"strings"
+	"testing"
+
+	"github.com/mattermost/mattermost-plugin-apps/apps"
+	"github.com/mattermost/mattermost-server/v6/model"
+)
+
+// replayFixtureDir holds the recorded calls replayed by TestReplayFixtures
+const replayFixtureDir = "testdata/fixtures"
+
+var (
+	// replayIDPattern matches Mattermost IDs, which differ between the recording and the replay
+	replayIDPattern = regexp.MustCompile(`\b[a-z0-9]{26}\b`)
+	// replayTraceIDPattern matches trace IDs and other random hex strings
+	replayTraceIDPattern = regexp.MustCompile(`\b[0-9a-f]{32}\b`)
+)
+
+// replayFixture is a recorded call with the KV entries, keyed by "prefix/id", that the fake server must hold
+// for the call to behave as it did when it was recorded. Recordings have no KV entries; add them by hand when
+// a call reads from the KV store.
+type replayFixture struct {
+	callFixture
+	KV map[string]json.RawMessage `json:"kv,omitempty"`
+}
+
+// normalizeReplayResponse re-encodes a response with the values that change between runs masked
+func normalizeReplayResponse(t *testing.T, response []byte, siteURL string) string {
+	t.Helper()
+	var decoded interface{}
+	err := json.Unmarshal(response, &decoded)
+	if err != nil {
+		t.Fatalf("error decoding response: %s", err.Error())
+	}
+	encoded, err := json.MarshalIndent(decoded, "", "  ")
+	if err != nil {
+		t.Fatalf("error encoding response: %s", err.Error())
+	}
+	normalized := strings.ReplaceAll(string(encoded), siteURL, fixtureSiteURL)
+	normalized = replayIDPattern.ReplaceAllString(normalized, "<id>")
+	return replayTraceIDPattern.ReplaceAllString(normalized, "<hex>")
+}
+
+// replay sends the recorded call to the app with the fake server's credentials and compares the response
+func replay(t *testing.T, fixture replayFixture) {
+	t.Helper()
+	at := newAppTest(t)
+	for key, value := range fixture.KV {
+		at.mattermost.KV[key] = value
+	}
+	callRequest := fixture.Request
+	appContext := &callRequest.Context
+	appContext.MattermostSiteURL = at.mattermost.URL
+	if appContext.BotAccessToken != "" {
+		appContext.BotAccessToken = testBotAccessToken
+	}
+	if appContext.ActingUserAccessToken != "" {
+		appContext.ActingUserAccessToken = testUserAccessToken
+	}
+	if appContext.ActingUser != nil {
+		at.user = appContext.ActingUser
+	}
+	body, err := json.Marshal(callRequest)
+	if err != nil {
+		t.Fatalf("error encoding call request: %s", err.Error())
+	}
+	statusCode, response := at.post(callRequest.Path, body, map[string][]string{
+		"Content-Type":          {"application/json"},
+		apps.OutgoingAuthHeader: {at.signCall(testAppSecret)},
+	})
+	if statusCode != fixture.StatusCode {
+		t.Errorf("got status %d, recorded %d", statusCode, fixture.StatusCode)
+	}
+	got := normalizeReplayResponse(t, response, at.mattermost.URL)
+	want := normalizeReplayResponse(t, fixture.Response, at.mattermost.URL)
+	if got != want {
+		t.Errorf("response differs from the recording\ngot:\n%s\nrecorded:\n%s", got, want)
+	}
+}
+
+func TestReplayFixtures(t *testing.T) {
+	paths, err := filepath.Glob(filepath.Join(replayFixtureDir, "*.json"))
+	if err != nil {
+		t.Fatalf("error listing fixtures: %s", err.Error())
+	}
+	for _, path := range paths {
+		t.Run(filepath.Base(path), func(t *testing.T) {
+			data, err := os.ReadFile(path)
+			if err != nil {
+				t.Fatalf("error reading fixture: %s", err.Error())
+			}
+			fixture := replayFixture{}
+			err = json.Unmarshal(data, &fixture)
+			if err != nil {
+				t.Fatalf("error decoding fixture: %s", err.Error())
+			}
+			replay(t, fixture)
+		})
+	}
+}
+
+func TestRecordMiddlewareWritesSanitizedFixtures(t *testing.T) {
+	previousDir := fixtureDir
+	fixtureDir = t.TempDir()
+	t.Cleanup(func() {
+		fixtureDir = previousDir
+	})
+	at := newAppTest(t)
+	at.user.Email = "alice@example.com"
+	at.mustCall("/configure-oauth2", map[string]interface{}{
+		"client_id":     "client-id",
+		"client_secret": "very-secret",
+		"auth_url":      "https://provider.example.com/authorize",
+		"token_url":     "https://provider.example.com/token",
+	}, apps.CallResponseTypeOK)
+
+	paths, err := filepath.Glob(filepath.Join(fixtureDir, "configure-oauth2-*.json"))
+	if err != nil || len(paths) != 1 {
+		t.Fatalf("got fixtures %v, want one", paths)
+	}
+	data, err := os.ReadFile(paths[0])
+	if err != nil {
+		t.Fatalf("error reading fixture: %s", err.Error())
+	}
+	for _, secret := range []string{"very-secret", testBotAccessToken, testUserAccessToken, "alice@example.com", at.mattermost.URL} {
+		if strings.Contains(string(data), secret) {
+			t.Errorf("fixture contains %q:\n%s", secret, data)
+		}
+	}
+	fixture := replayFixture{}
+	err = json.Unmarshal(data, &fixture)
+	if err != nil {
+		t.Fatalf("error decoding fixture: %s", err.Error())
+	}
+	if fixture.Request.Path != "/configure-oauth2" || fixture.StatusCode != 200 {
+		t.Errorf("unexpected fixture %s", data)
+	}
+	// the fixture replays against a fresh app
+	fixtureDir = ""
+	replay(t, fixture)
+}
+
+func TestRecordMiddlewareRedactsWebhookURLs(t *testing.T) {
+	previousDir := fixtureDir
+	fixtureDir = t.TempDir()
+	t.Cleanup(func() {
+		fixtureDir = previousDir
+	})
+	at := newAppTest(t)
+	at.mustCall("/webhook-create", map[string]interface{}{"template": "private {{ .text }}"}, apps.CallResponseTypeOK)
+	index := make([]string, 0)
+	at.mattermost.kvGet(t, webhookKVPrefix, kvIndexKey, &index)
+	route := webhookRoute{}
+	if len(index) != 1 || !at.mattermost.kvGet(t, webhookKVPrefix, index[0], &route) {
+		t.Fatalf("unexpected webhook index %v", index)
+	}
+
+	paths, err := filepath.Glob(filepath.Join(fixtureDir, "webhook-create-*.json"))
+	if err != nil || len(paths) != 1 {
+		t.Fatalf("got fixtures %v, want one", paths)
+	}
+	data, err := os.ReadFile(paths[0])
+	if err != nil {
+		t.Fatalf("error reading fixture: %s", err.Error())
+	}
+	for _, secret := range []string{route.Secret, at.mattermost.URL, "private"} {
+		if strings.Contains(string(data), secret) {
+			t.Errorf("fixture contains %q:\n%s", secret, data)
+		}
+	}
+	if !strings.Contains(string(data), fixtureSiteURL) || !strings.Contains(string(data), "secret="+fixtureRedacted) {
+		t.Errorf("fixture does not hold the sanitized webhook URL:\n%s", data)
+	}
+}
+
+func TestSanitizeCallResponse(t *testing.T) {
+	siteURL := "https://chat.example.org"
+	response, err := sanitizeCallResponse([]byte(`{"type":"ok","text":"[connect](`+siteURL+`/connect?state=abc&code=def&keep=1)","data":{"urls":["`+siteURL+`/hook?secret=xyz"]}}`), siteURL)
+	if err != nil {
+		t.Fatalf("error sanitizing response: %s", err.Error())
+	}
+	want := `{"data":{"urls":["` + fixtureSiteURL + `/hook?secret=redacted"]},"text":"[connect](` + fixtureSiteURL + `/connect?state=redacted\u0026code=redacted\u0026keep=1)","type":"ok"}`
+	if string(response) != want {
+		t.Errorf("got %s, want %s", response, want)
+	}
+	_, err = sanitizeCallResponse([]byte("not json"), siteURL)
+	if err == nil {
+		t.Errorf("invalid JSON was accepted")
+	}
+}
+
+func TestSanitizeCallRequestRedactsWebhookSecrets(t *testing.T) {
+	callRequest := apps.CallRequest{
+		Values: map[string]interface{}{
+			"rawQuery": "secret=abc&other=1",
+			"state":    "some-state",
+			"message":  "private",
+			"headers": map[string]interface{}{
+				"Content-Type":        "application/json",
+				"user-agent":          "GitHub-Hookshot/1",
+				"Authorization":       "Bearer token",
+				"X-Hub-Signature-256": "sha256=abc",
+			},
+			"data": map[string]interface{}{"text": "private"},
+		},
+	}
+	callRequest.Context.ActingUser = &model.User{Id: model.NewId(), Email: "alice@example.com"}
+	callRequest.Context.Post = &model.Post{Id: model.NewId(), Message: "private"}
+	callRequest.Context.RootPost = &model.Post{Id: model.NewId(), Message: "private"}
+	sanitizeCallRequest(&callRequest)
+	if callRequest.Values["rawQuery"] != "other=1&secret="+fixtureRedacted || callRequest.Values["state"] != fixtureRedacted ||
+		callRequest.Values["message"] != fixtureRedacted {
+		t.Errorf("unexpected values %v", callRequest.Values)
+	}
+	wantHeaders := map[string]interface{}{
+		"Content-Type":        "application/json",
+		"user-agent":          "GitHub-Hookshot/1",
+		"Authorization":       fixtureRedacted,
+		"X-Hub-Signature-256": fixtureRedacted,
+	}
+	if !reflect.DeepEqual(callRequest.Values["headers"], wantHeaders) {
+		t.Errorf("got headers %v, want %v", callRequest.Values["headers"], wantHeaders)
+	}
+	if !reflect.DeepEqual(callRequest.Values["data"], map[string]interface{}{}) {
+		t.Errorf("webhook data was not removed: %v", callRequest.Values["data"])
+	}
+	if callRequest.Context.ActingUser.Email != "" {
+		t.Errorf("acting user email was not removed")
+	}
+	if callRequest.Context.Post.Message != fixtureRedacted || callRequest.Context.RootPost.Message != fixtureRedacted {
+		t.Errorf("post messages were not redacted")
+	}
+	if callRequest.Context.Post.Id == "" || callRequest.Context.RootPost.Id == "" {
+		t.Errorf("post IDs were removed")
+	}
+}
@@ -0,0 +1,72 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"mime"
+	"net/http"
+
+	"github.com/mattermost/mattermost-plugin-apps/apps"
+	"github.com/mattermost/mattermost-plugin-apps/utils/httputils"
+)
+
+// handleCall registers a handler for a call path; the apps plugin POSTs every call as JSON, signed when the app
+// has a secret
+func handleCall(mux *httputils.Handler, path string, handler http.HandlerFunc) {
+	mux.HandleFunc(path, requireJWT(requireJSON(handler))).Methods(http.MethodPost)
+}
+
+// handleStatic registers a handler for a path that is fetched rather than called, such as the manifest or an icon
+func handleStatic(mux *httputils.Handler, path string, handler http.HandlerFunc) {
+	mux.HandleFunc(path, handler).Methods(http.MethodGet, http.MethodHead)
+}
+
+// handleUnmatched answers requests that no route accepts
+func handleUnmatched(mux *httputils.Handler) {
+	mux.NotFoundHandler = http.HandlerFunc(unknownPath)
+	mux.MethodNotAllowedHandler = http.HandlerFunc(methodNotAllowed)
+}
+
+// requireJSON rejects a call whose body is not JSON
+func requireJSON(handler http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		mediaType, _, err := mime.ParseMediaType(r.Header.Get("Content-Type"))
+		if err != nil || mediaType != "application/json" {
+			sendStatusErrorResponse(w, http.StatusUnsupportedMediaType,
+				fmt.Errorf("call %s must have a JSON body", r.URL.Path))
+			return
+		}
+		handler(w, r)
+	}
+}
+
+// unknownPath explains which call path is missing so that a binding pointing at the wrong path is easy to spot
+func unknownPath(w http.ResponseWriter, r *http.Request) {
+	log.Printf("unknownPath(): %s %s\n", r.Method, r.URL.Path)
+	if r.Method != http.MethodPost {
+		http.NotFound(w, r)
+		return
+	}
+	sendErrorResponse(w, newNotFoundError("the app has no handler for call path %s", r.URL.Path))
+}
+
+func methodNotAllowed(w http.ResponseWriter, r *http.Request) {
+	log.Printf("methodNotAllowed(): %s %s\n", r.Method, r.URL.Path)
+	sendStatusErrorResponse(w, http.StatusMethodNotAllowed,
+		fmt.Errorf("method %s is not allowed for %s", r.Method, r.URL.Path))
+}
+
+// sendStatusErrorResponse sends an apps error response with the status code; it is meant for requests that
+// do not come from the apps plugin, whose calls are answered by sendErrorResponse
+func sendStatusErrorResponse(w http.ResponseWriter, statusCode int, err error) {
+	encodedResponse, err := json.Marshal(apps.NewErrorResponse(err))
+	if err != nil {
+		log.Printf("sendStatusErrorResponse(): error encoding response body: %s\n", err.Error())
+		http.Error(w, err.Error(), statusCode)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(statusCode)
+	_, _ = w.Write(encodedResponse)
+}
@@ -0,0 +1,70 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/mattermost/mattermost-plugin-apps/apps"
+)
+
+func TestRoutesAnswerUnmatchedRequests(t *testing.T) {
+	at := newAppTest(t)
+	body, err := json.Marshal(apps.CallRequest{Context: at.context()})
+	if err != nil {
+		t.Fatalf("error encoding call request: %s", err.Error())
+	}
+	tests := []struct {
+		name        string
+		method      string
+		path        string
+		contentType string
+		wantStatus  int
+		// wantError is the text of the apps error response, or empty if the response is not one
+		wantError string
+	}{
+		{"unknown call path", http.MethodPost, "/nope", "application/json", http.StatusOK, "the app has no handler for call path /nope"},
+		{"unknown path", http.MethodGet, "/nope", "", http.StatusNotFound, ""},
+		{"call fetched with GET", http.MethodGet, "/info", "", http.StatusMethodNotAllowed, "method GET is not allowed for /info"},
+		{"static path called with POST", http.MethodPost, "/manifest.json", "application/json", http.StatusMethodNotAllowed, "method POST is not allowed for /manifest.json"},
+		{"call without a JSON body", http.MethodPost, "/info", "text/plain", http.StatusUnsupportedMediaType, "call /info must have a JSON body"},
+		{"call with a JSON body", http.MethodPost, "/info", "application/json; charset=utf-8", http.StatusOK, ""},
+		{"static path", http.MethodGet, "/manifest.json", "", http.StatusOK, ""},
+		{"static path with HEAD", http.MethodHead, "/manifest.json", "", http.StatusOK, ""},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			request, err := http.NewRequest(test.method, at.app.URL+test.path, bytes.NewReader(body))
+			if err != nil {
+				t.Fatalf("error creating request: %s", err.Error())
+			}
+			if test.contentType != "" {
+				request.Header.Set("Content-Type", test.contentType)
+			}
+			request.Header.Set(apps.OutgoingAuthHeader, at.signCall(testAppSecret))
+			response, err := http.DefaultClient.Do(request)
+			if err != nil {
+				t.Fatalf("error sending request: %s", err.Error())
+			}
+			responseBody, err := io.ReadAll(response.Body)
+			_ = response.Body.Close()
+			if err != nil {
+				t.Fatalf("error reading response: %s", err.Error())
+			}
+			if response.StatusCode != test.wantStatus {
+				t.Errorf("got status %d, want %d: %s", response.StatusCode, test.wantStatus, responseBody)
+			}
+			if test.wantError == "" {
+				return
+			}
+			callResponse := apps.CallResponse{}
+			err = json.Unmarshal(responseBody, &callResponse)
+			if err != nil || callResponse.Type != apps.CallResponseTypeError || !strings.Contains(callResponse.Text, test.wantError) {
+				t.Errorf("got response %s, want an apps error containing %q", responseBody, test.wantError)
+			}
+		})
+	}
+}
@@ -0,0 +1,740 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/mattermost/mattermost-plugin-apps/apps"
+	"github.com/mattermost/mattermost-plugin-apps/apps/appclient"
+	"github.com/mattermost/mattermost-server/v6/model"
+)
+
+const (
+	scheduleKVPrefix         = "schedule"
+	scheduleTimezoneKVPrefix = "schedule-tz"
+	// scheduleOptionsKVPrefix stores, under each channel ID, the select options of the channel's schedules
+	scheduleOptionsKVPrefix = "schedule-options"
+	reminderKVPrefix        = "reminder"
+	schedulerTickInterval   = time.Duration(15) * time.Second
+	// reminderMaxAttempts is the number of times a reminder is tried, a tick apart, before it is dropped
+	reminderMaxAttempts = 8
+)
+
+// scheduledJob is a persistent recurring weather report for a channel, or a one-off reminder for a user
+type scheduledJob struct {
+	ID         string `json:"id"`
+	ChannelID  string `json:"channel_id"`
+	Expression string `json:"expression"`
+	Timezone   string `json:"timezone"`
+	Location   string `json:"location"`
+	Paused     bool   `json:"paused"`
+	CreatedBy  string `json:"created_by"`
+	// RemindAt is the Unix time at which a reminder is sent; it is zero for a weather report
+	RemindAt int64 `json:"remind_at,omitempty"`
+	// UserID is the user that a reminder is sent to as a DM
+	UserID string `json:"user_id,omitempty"`
+	// Message is the Markdown of a reminder
+	Message string `json:"message,omitempty"`
+	// Attempts is the number of times that sending a reminder has failed
+	Attempts int `json:"attempts,omitempty"`
+}
+
+// isReminder reports whether the job is a one-off reminder, which is stored under reminderKVPrefix and removed
+// once it has been sent
+func (job scheduledJob) isReminder() bool {
+	return job.RemindAt != 0
+}
+
+// scheduler runs scheduled jobs in the background. Jobs are persisted in the KV store; because the app has
+// no credentials of its own, the scheduler loads them and posts using the bot credentials of incoming calls.
+// It works for the first Mattermost server that calls the app, whose KV store holds its jobs. After a restart
+// no job runs until that server sends the app a call; the credentials are not persisted, since they would
+// have to be stored outside Mattermost.
+type scheduler struct {
+	mutex          sync.Mutex
+	jobs           map[string]scheduledJob
+	lastRun        map[string]time.Time
+	loaded         bool
+	loading        bool
+	siteURL        string
+	appPath        string
+	botUserID      string
+	botAccessToken string
+}
+
+var (
+	// scheduleFrequencies maps a frequency name to the day-of-month, month and day-of-week cron fields
+	scheduleFrequencies = map[string]string{
+		"daily":    "* * *",
+		"weekdays": "* * 1-5",
+		"weekends": "* * 0,6",
+		"weekly":   "* * 1",
+	}
+
+	scheduleCommandExpand = apps.Expand{
+		ActingUser: apps.ExpandSummary,
+		Channel:    apps.ExpandID,
+		Team:       apps.ExpandID,
+	}
+
+	scheduleIDForm = apps.Form{
+		Fields: []apps.Field{
+			{
+				Name:                 "id",
+				Label:                "id",
+				Type:                 apps.FieldTypeText,
+				TextSubtype:          apps.TextFieldSubtypeInput,
+				Description:          "The ID of the schedule",
+				IsRequired:           true,
+				AutocompletePosition: 1,
+			},
+		},
+	}
+
+	scheduleBindings = []apps.Binding{
+		{
+			Location:    "schedule",
+			Label:       "schedule",
+			Description: "Post the weather report to this channel on a schedule",
+			Form: &apps.Form{
+				Title: "Schedule a weather report",
+				Icon:  "icon.png",
+				Fields: []apps.Field{
+					{
+						Name:                 "frequency",
+						Label:                "frequency",
+						Type:                 apps.FieldTypeText,
+						TextSubtype:          apps.TextFieldSubtypeInput,
+						Description:          "daily, weekdays, weekends, weekly, or the day-of-month, month and day-of-week fields of a cron expression",
+						AutocompleteHint:     `daily|weekdays|weekends|weekly|"dom month dow"`,
+						IsRequired:           true,
+						AutocompletePosition: 1,
+					},
+					{
+						Name:                 "time",
+						Label:                "time",
+						Type:                 apps.FieldTypeText,
+						TextSubtype:          apps.TextFieldSubtypeInput,
+						Description:          "The time of day to post at, as HH:MM; use *:MM to post every hour",
+						AutocompleteHint:     "HH:MM",
+						IsRequired:           true,
+						AutocompletePosition: 2,
+					},
+					{
+						Name:                 "location",
+						Label:                "location",
+						Type:                 apps.FieldTypeText,
+						TextSubtype:          apps.TextFieldSubtypeInput,
+						Description:          "The location to report the weather for",
+						IsRequired:           true,
+						AutocompletePosition: 3,
+					},
+				},
+				Submit: apps.NewCall("/weather/schedule").WithExpand(scheduleCommandExpand),
+			},
+		},
+		{
+			Location:    "schedules",
+			Label:       "schedules",
+			Description: "List the weather report schedules for this channel",
+			Submit:      apps.NewCall("/weather/schedules").WithExpand(scheduleCommandExpand),
+		},
+		{
+			Location:    "pause",
+			Label:       "pause",
+			Description: "Pause a weather report schedule",
+			Form:        withSubmit(scheduleIDForm, apps.NewCall("/weather/pause").WithExpand(scheduleCommandExpand)),
+		},
+		{
+			Location:    "resume",
+			Label:       "resume",
+			Description: "Resume a paused weather report schedule",
+			Form:        withSubmit(scheduleIDForm, apps.NewCall("/weather/resume").WithExpand(scheduleCommandExpand)),
+		},
+		{
+			Location:    "unschedule",
+			Label:       "unschedule",
+			Description: "Delete a weather report schedule",
+			Form:        withSubmit(scheduleIDForm, apps.NewCall("/weather/unschedule").WithExpand(scheduleCommandExpand)),
+		},
+		{
+			Location:    "timezone",
+			Label:       "timezone",
+			Description: "Set the timezone used by this channel's weather report schedules",
+			Form: &apps.Form{
+				Fields: []apps.Field{
+					{
+						Name:                 "timezone",
+						Label:                "timezone",
+						Type:                 apps.FieldTypeText,
+						TextSubtype:          apps.TextFieldSubtypeInput,
+						Description:          "An IANA timezone name such as America/Toronto",
+						IsRequired:           true,
+						AutocompletePosition: 1,
+					},
+				},
+				Submit: apps.NewCall("/weather/timezone").WithExpand(scheduleCommandExpand),
+			},
+		},
+	}
+
+	jobScheduler = newScheduler()
+)
+
+// withSubmit returns a copy of the form that submits to the supplied call
+func withSubmit(form apps.Form, submit *apps.Call) *apps.Form {
+	formClone := form.PartialCopy()
+	formClone.Submit = submit
+	return formClone
+}
+
+func newScheduler() *scheduler {
+	return &scheduler{
+		jobs:    make(map[string]scheduledJob),
+		lastRun: make(map[string]time.Time),
+	}
+}
+
+// setContext records the bot credentials from an incoming call and loads the persisted jobs the first time.
+// Calls from other servers than the first are ignored, so that the jobs of one server are never run with the
+// credentials of another.
+func (s *scheduler) setContext(appContext apps.Context) {
+	if appContext.BotAccessToken == "" || appContext.MattermostSiteURL == "" {
+		return
+	}
+	s.mutex.Lock()
+	if s.siteURL != "" && s.siteURL != appContext.MattermostSiteURL {
+		s.mutex.Unlock()
+		return
+	}
+	s.siteURL = appContext.MattermostSiteURL
+	s.appPath = appContext.AppPath
+	s.botUserID = appContext.BotUserID
+	s.botAccessToken = appContext.BotAccessToken
+	load := !s.loaded && !s.loading
+	if load {
+		s.loading = true
+	}
+	s.mutex.Unlock()
+	if load {
+		go s.load()
+	}
+}
+
+// client returns a bot client built from the most recent credentials, or nil if there are none yet; its
+// requests end with the context
+func (s *scheduler) client(ctx context.Context) mattermostClient {
+	if s.botAccessToken == "" {
+		return nil
+	}
+	// the bot user ID lets the client send DMs
+	return newMattermostClient(ctx, withContext(ctx, appclient.AsBot(apps.Context{
+		ExpandedContext: apps.ExpandedContext{
+			MattermostSiteURL: s.siteURL,
+			BotUserID:         s.botUserID,
+			BotAccessToken:    s.botAccessToken,
+		},
+	})))
+}
+
+func (s *scheduler) load() {
+	// loading must not outlast the next tick
+	ctx, cancel := context.WithTimeout(context.Background(), schedulerTickInterval)
+	defer cancel()
+	s.mutex.Lock()
+	clt := s.client(ctx)
+	s.mutex.Unlock()
+	loadedJobs := make([]scheduledJob, 0)
+	var err error
+	for _, prefix := range []string{scheduleKVPrefix, reminderKVPrefix} {
+		var index []string
+		index, err = getKVIndex(clt, prefix)
+		if err != nil {
+			break
+		}
+		for _, id := range index {
+			job := scheduledJob{}
+			err = clt.KVGet(prefix, id, &job)
+			if err != nil {
+				break
+			}
+			if job.ID != "" {
+				loadedJobs = append(loadedJobs, job)
+			}
+		}
+		if err != nil {
+			break
+		}
+	}
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.loading = false
+	if err != nil {
+		// the next incoming call will retry
+		log.Printf("scheduler.load(): error loading jobs: %s\n", err.Error())
+		return
+	}
+	for _, job := range loadedJobs {
+		// jobs changed while loading take precedence
+		if _, ok := s.jobs[job.ID]; !ok {
+			s.jobs[job.ID] = job
+		}
+	}
+	s.loaded = true
+	log.Printf("scheduler.load(): loaded %d jobs\n", len(loadedJobs))
+}
+
+// put adds or replaces a job in the running scheduler
+func (s *scheduler) put(job scheduledJob) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.jobs[job.ID] = job
+}
+
+// remove removes a job from the running scheduler
+func (s *scheduler) remove(id string) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	delete(s.jobs, id)
+	delete(s.lastRun, id)
+}
+
+func (s *scheduler) start() {
+	go func() {
+		ticker := time.NewTicker(schedulerTickInterval)
+		defer ticker.Stop()
+		for now := range ticker.C {
+			s.tick(now)
+		}
+	}()
+}
+
+// tick runs every job that is due in the current minute and has not already run in it, and every reminder
+// whose time has come
+func (s *scheduler) tick(now time.Time) {
+	ctx, cancel := context.WithTimeout(context.Background(), schedulerTickInterval)
+	defer cancel()
+	s.mutex.Lock()
+	clt := s.client(ctx)
+	// the context that the asset URLs in reports are built from
+	assetContext := apps.Context{
+		ExpandedContext: apps.ExpandedContext{
+			MattermostSiteURL: s.siteURL,
+			AppPath:           s.appPath,
+		},
+	}
+	minute := now.Truncate(time.Minute)
+	due := make([]scheduledJob, 0)
+	for _, job := range s.jobs {
+		if job.isReminder() {
+			if clt != nil && now.Unix() >= job.RemindAt {
+				// a reminder that fails to send is put back to be retried, up to reminderMaxAttempts times
+				delete(s.jobs, job.ID)
+				due = append(due, job)
+			}
+			continue
+		}
+		if job.Paused || s.lastRun[job.ID].Equal(minute) {
+			continue
+		}
+		schedule, err := parseCronSchedule(job.Expression)
+		if err != nil {
+			log.Printf("scheduler.tick(): job %s has an invalid expression: %s\n", job.ID, err.Error())
+			continue
+		}
+		location, err := time.LoadLocation(job.Timezone)
+		if err != nil {
+			log.Printf("scheduler.tick(): job %s has an invalid timezone: %s\n", job.ID, err.Error())
+			continue
+		}
+		if schedule.matches(now.In(location)) {
+			s.lastRun[job.ID] = minute
+			due = append(due, job)
+		}
+	}
+	s.mutex.Unlock()
+	if clt == nil {
+		return
+	}
+	for _, job := range due {
+		if job.isReminder() {
+			s.remind(clt, job)
+			continue
+		}
+		_, err := clt.CreatePost(&model.Post{
+			ChannelId: job.ChannelID,
+			Message:   renderMarkdown(assetContext, weatherReport(job.Location)),
+		})
+		if err != nil {
+			log.Printf("scheduler.tick(): error running job %s: %s\n", job.ID, err.Error())
+		}
+	}
+}
+
+// remind sends a reminder and deletes it from the KV store
+func (s *scheduler) remind(clt mattermostClient, job scheduledJob) {
+	_, err := clt.DM(job.UserID, "%s", job.Message)
+	if err != nil {
+		job.Attempts++
+		if job.Attempts < reminderMaxAttempts {
+			log.Printf("scheduler.remind(): error sending reminder %s, attempt %d: %s\n", job.ID, job.Attempts, err.Error())
+			s.put(job)
+			return
+		}
+		log.Printf("scheduler.remind(): dropping reminder %s after %d attempts: %s\n", job.ID, job.Attempts, err.Error())
+	}
+	_, err = removeFromKVIndex(clt, reminderKVPrefix, job.ID)
+	if err == nil {
+		err = clt.KVDelete(reminderKVPrefix, job.ID)
+	}
+	if err != nil {
+		log.Printf("scheduler.remind(): error deleting reminder %s: %s\n", job.ID, err.Error())
+	}
+}
+
+// weatherReport returns the Markdown posted by a scheduled weather report
+func weatherReport(location string) string {
+	return fmt.Sprintf("#### Scheduled weather report for %s\n%s%s", location, weatherDayResponseData.Text,
+		weatherSampleNote(location))
+}
+
+// scheduleExpression builds a cron expression from a frequency and a time of day
+func scheduleExpression(frequency string, timeOfDay string) (string, error) {
+	dayFields, ok := scheduleFrequencies[strings.ToLower(frequency)]
+	if !ok {
+		dayFields = frequency
+	}
+	if len(strings.Fields(dayFields)) != 3 {
+		return "", newUserInputError("unknown frequency %q", frequency)
+	}
+	pieces := strings.Split(timeOfDay, ":")
+	if len(pieces) != 2 {
+		return "", newUserInputError("invalid time %q; expected HH:MM", timeOfDay)
+	}
+	hour := pieces[0]
+	if hour != "*" {
+		parsedHour, err := strconv.Atoi(hour)
+		if err != nil || parsedHour < 0 || parsedHour > 23 {
+			return "", newUserInputError("invalid hour in %q", timeOfDay)
+		}
+		hour = strconv.Itoa(parsedHour)
+	}
+	minute, err := strconv.Atoi(pieces[1])
+	if err != nil || minute < 0 || minute > 59 {
+		return "", newUserInputError("invalid minute in %q", timeOfDay)
+	}
+	expression := fmt.Sprintf("%d %s %s", minute, hour, dayFields)
+	_, err = parseCronSchedule(expression)
+	if err != nil {
+		return "", err
+	}
+	return expression, nil
+}
+
+// channelTimezone returns the timezone configured for the channel, falling back to the acting user's timezone and then UTC
+func channelTimezone(clt mattermostClient, appContext apps.Context) (string, error) {
+	timezone := ""
+	err := clt.KVGet(scheduleTimezoneKVPrefix, appContext.Channel.Id, &timezone)
+	if err != nil {
+		return "", err
+	}
+	if timezone == "" && appContext.ActingUser != nil {
+		timezone = appContext.ActingUser.GetPreferredTimezone()
+	}
+	if timezone == "" {
+		timezone = "UTC"
+	}
+	return timezone, nil
+}
+
+// getChannelJob reads a job from the KV store and makes sure it belongs to the supplied channel
+func getChannelJob(clt mattermostClient, id string, channelID string) (*scheduledJob, error) {
+	if id == "" || id == kvIndexKey {
+		return nil, newUserInputError("invalid schedule id")
+	}
+	job := scheduledJob{}
+	err := clt.KVGet(scheduleKVPrefix, id, &job)
+	if err != nil {
+		return nil, newUpstreamError(err, "error reading schedule")
+	}
+	if job.ID == "" || job.ChannelID != channelID {
+		return nil, newNotFoundError("no schedule with that id in this channel")
+	}
+	return &job, nil
+}
+
+// setChannelScheduleOption adds the job's select option to the options of its channel, or removes it
+func setChannelScheduleOption(clt mattermostClient, job scheduledJob, present bool) error {
+	kvUpdateMutex.Lock()
+	defer kvUpdateMutex.Unlock()
+	options := make([]apps.SelectOption, 0)
+	err := clt.KVGet(scheduleOptionsKVPrefix, job.ChannelID, &options)
+	if err != nil {
+		return err
+	}
+	newOptions := make([]apps.SelectOption, 0, len(options)+1)
+	for _, option := range options {
+		if option.Value != job.ID {
+			newOptions = append(newOptions, option)
+		}
+	}
+	if present {
+		newOptions = append(newOptions, apps.SelectOption{
+			Label: job.Location,
+			Value: job.ID,
+		})
+	}
+	_, err = clt.KVSet(scheduleOptionsKVPrefix, job.ChannelID, newOptions)
+	return err
+}
+
+// getChannelJobs reads every job belonging to the supplied channel from the KV store
+func getChannelJobs(clt mattermostClient, channelID string) ([]scheduledJob, error) {
+	index, err := getKVIndex(clt, scheduleKVPrefix)
+	if err != nil {
+		return nil, newUpstreamError(err, "error reading schedule index")
+	}
+	jobs := make([]scheduledJob, 0)
+	for _, id := range index {
+		job := scheduledJob{}
+		err = clt.KVGet(scheduleKVPrefix, id, &job)
+		if err != nil {
+			return nil, newUpstreamError(err, "error reading schedule %s", id)
+		}
+		if job.ID != "" && job.ChannelID == channelID {
+			jobs = append(jobs, job)
+		}
+	}
+	return jobs, nil
+}
+
+func weatherSchedule(w http.ResponseWriter, r *http.Request) {
+	callRequest, err := getCallRequest(r)
+	if err != nil {
+		log.Printf("weatherSchedule(): %s\n", err.Error())
+		sendErrorResponse(w, err)
+		return
+	}
+	if callRequest.Context.Channel == nil {
+		sendErrorResponse(w, newUserInputError("channel not expanded"))
+		return
+	}
+	expression, err := scheduleExpression(callRequest.GetValue("frequency", ""), callRequest.GetValue("time", ""))
+	if err != nil {
+		sendErrorResponse(w, err)
+		return
+	}
+	location := callRequest.GetValue("location", "")
+	if location == "" {
+		sendErrorResponse(w, newUserInputError("location not specified"))
+		return
+	}
+	clt := asBot(r.Context(), callRequest.Context)
+	timezone, err := channelTimezone(clt, callRequest.Context)
+	if err != nil {
+		err = newUpstreamError(err, "error reading channel timezone")
+		sendErrorResponse(w, err)
+		return
+	}
+	job := scheduledJob{
+		ID:         model.NewId(),
+		ChannelID:  callRequest.Context.Channel.Id,
+		Expression: expression,
+		Timezone:   timezone,
+		Location:   location,
+	}
+	if callRequest.Context.ActingUser != nil {
+		job.CreatedBy = callRequest.Context.ActingUser.Id
+	}
+	err = addBotToChannel(clt, callRequest.Context, job.ChannelID)
+	if err != nil {
+		sendErrorResponse(w, err)
+		return
+	}
+	_, err = clt.KVSet(scheduleKVPrefix, job.ID, job)
+	if err != nil {
+		err = newUpstreamError(err, "error storing schedule")
+		sendErrorResponse(w, err)
+		return
+	}
+	err = addToKVIndex(clt, scheduleKVPrefix, job.ID)
+	if err != nil {
+		err = newUpstreamError(err, "error storing schedule index")
+		sendErrorResponse(w, err)
+		return
+	}
+	err = setChannelScheduleOption(clt, job, true)
+	if err != nil {
+		err = newUpstreamError(err, "error storing channel schedules")
+		sendErrorResponse(w, err)
+		return
+	}
+	jobScheduler.put(job)
+	sendCallResponse(w, apps.NewTextResponse("%s", renderMessage(r.Context(), callRequest.Context, "schedule_created", messageData{
+		"ID":         job.ID,
+		"Location":   job.Location,
+		"Expression": job.Expression,
+		"Timezone":   job.Timezone,
+	})))
+}
+
+func weatherSchedules(w http.ResponseWriter, r *http.Request) {
+	callRequest, err := getCallRequest(r)
+	if err != nil {
+		log.Printf("weatherSchedules(): %s\n", err.Error())
+		sendErrorResponse(w, err)
+		return
+	}
+	if callRequest.Context.Channel == nil {
+		sendErrorResponse(w, newUserInputError("channel not expanded"))
+		return
+	}
+	clt := asBot(r.Context(), callRequest.Context)
+	jobs, err := getChannelJobs(clt, callRequest.Context.Channel.Id)
+	if err != nil {
+		sendErrorResponse(w, err)
+		return
+	}
+	if len(jobs) == 0 {
+		sendCallResponse(w, apps.NewTextResponse("there are no weather report schedules in this channel"))
+		return
+	}
+	responseText := "## Weather report schedules in this channel\n"
+	for _, job := range jobs {
+		status := "active"
+		if job.Paused {
+			status = "paused"
+		}
+		responseText += fmt.Sprintf("- `%s`: %s at `%s` (%s), %s\n", job.ID, job.Location, job.Expression, job.Timezone, status)
+	}
+	sendCallResponse(w, apps.NewTextResponse("%s", responseText))
+}
+
+func weatherPauseSchedule(w http.ResponseWriter, r *http.Request) {
+	callRequest, err := getCallRequest(r)
+	if err != nil {
+		log.Printf("weatherPauseSchedule(): %s\n", err.Error())
+		sendErrorResponse(w, err)
+		return
+	}
+	if callRequest.Context.Channel == nil {
+		sendErrorResponse(w, newUserInputError("channel not expanded"))
+		return
+	}
+	paused := strings.HasSuffix(callRequest.Path, "pause")
+	clt := asBot(r.Context(), callRequest.Context)
+	job, err := getChannelJob(clt, callRequest.GetValue("id", ""), callRequest.Context.Channel.Id)
+	if err != nil {
+		sendErrorResponse(w, err)
+		return
+	}
+	job.Paused = paused
+	_, err = clt.KVSet(scheduleKVPrefix, job.ID, job)
+	if err != nil {
+		err = newUpstreamError(err, "error storing schedule")
+		sendErrorResponse(w, err)
+		return
+	}
+	jobScheduler.put(*job)
+	if paused {
+		sendCallResponse(w, apps.NewTextResponse("%s", renderMessage(r.Context(), callRequest.Context, "schedule_paused", messageData{
+			"ID": job.ID,
+		})))
+		return
+	}
+	sendCallResponse(w, apps.NewTextResponse("%s", renderMessage(r.Context(), callRequest.Context, "schedule_resumed", messageData{
+		"ID": job.ID,
+	})))
+}
+
+func weatherUnschedule(w http.ResponseWriter, r *http.Request) {
+	callRequest, err := getCallRequest(r)
+	if err != nil {
+		log.Printf("weatherUnschedule(): %s\n", err.Error())
+		sendErrorResponse(w, err)
+		return
+	}
+	if callRequest.Context.Channel == nil {
+		sendErrorResponse(w, newUserInputError("channel not expanded"))
+		return
+	}
+	clt := asBot(r.Context(), callRequest.Context)
+	job, err := getChannelJob(clt, callRequest.GetValue("id", ""), callRequest.Context.Channel.Id)
+	if err != nil {
+		sendErrorResponse(w, err)
+		return
+	}
+	_, err = removeFromKVIndex(clt, scheduleKVPrefix, job.ID)
+	if err != nil {
+		err = newUpstreamError(err, "error storing schedule index")
+		sendErrorResponse(w, err)
+		return
+	}
+	err = setChannelScheduleOption(clt, *job, false)
+	if err != nil {
+		err = newUpstreamError(err, "error storing channel schedules")
+		sendErrorResponse(w, err)
+		return
+	}
+	err = clt.KVDelete(scheduleKVPrefix, job.ID)
+	if err != nil {
+		err = newUpstreamError(err, "error deleting schedule")
+		sendErrorResponse(w, err)
+		return
+	}
+	jobScheduler.remove(job.ID)
+	sendCallResponse(w, apps.NewTextResponse("%s", renderMessage(r.Context(), callRequest.Context, "schedule_deleted", messageData{
+		"ID": job.ID,
+	})))
+}
+
+func weatherTimezone(w http.ResponseWriter, r *http.Request) {
+	callRequest, err := getCallRequest(r)
+	if err != nil {
+		log.Printf("weatherTimezone(): %s\n", err.Error())
+		sendErrorResponse(w, err)
+		return
+	}
+	if callRequest.Context.Channel == nil {
+		sendErrorResponse(w, newUserInputError("channel not expanded"))
+		return
+	}
+	timezone := callRequest.GetValue("timezone", "")
+	_, err = time.LoadLocation(timezone)
+	if timezone == "" || err != nil {
+		sendErrorResponse(w, newUserInputError("unknown timezone %q", timezone))
+		return
+	}
+	clt := asBot(r.Context(), callRequest.Context)
+	_, err = clt.KVSet(scheduleTimezoneKVPrefix, callRequest.Context.Channel.Id, timezone)
+	if err != nil {
+		err = newUpstreamError(err, "error storing channel timezone")
+		sendErrorResponse(w, err)
+		return
+	}
+	// existing schedules follow the channel's timezone
+	jobs, err := getChannelJobs(clt, callRequest.Context.Channel.Id)
+	if err != nil {
+		sendErrorResponse(w, err)
+		return
+	}
+	for _, job := range jobs {
+		job.Timezone = timezone
+		_, err = clt.KVSet(scheduleKVPrefix, job.ID, job)
+		if err != nil {
+			err = newUpstreamError(err, "error storing schedule")
+			sendErrorResponse(w, err)
+			return
+		}
+		jobScheduler.put(job)
+	}
+	sendCallResponse(w, apps.NewTextResponse("%s", renderMessage(r.Context(), callRequest.Context, "timezone_set", messageData{
+		"Timezone": timezone,
+	})))
+}
@@ -0,0 +1,149 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/mattermost/mattermost-plugin-apps/apps"
+	"github.com/mattermost/mattermost-plugin-apps/apps/appclient"
+)
+
+// errRequestTooLarge is returned when reading a request body that exceeds the configured limit
+var errRequestTooLarge = errors.New("request body too large")
+
+// serverConfig holds the limits applied to incoming requests; each value can be overridden by an environment
+// variable
+type serverConfig struct {
+	// MaxBodyBytes is the largest request body accepted, from MAX_BODY_BYTES
+	MaxBodyBytes int64
+	// ReadTimeout is the time allowed to read a whole request, from READ_TIMEOUT
+	ReadTimeout time.Duration
+	// WriteTimeout is the time allowed to write a response, from WRITE_TIMEOUT
+	WriteTimeout time.Duration
+	// IdleTimeout is how long a keep-alive connection may stay idle, from IDLE_TIMEOUT
+	IdleTimeout time.Duration
+	// CallTimeout is the deadline of a handler and the outbound calls it makes, from CALL_TIMEOUT
+	CallTimeout time.Duration
+}
+
+var defaultServerConfig = serverConfig{
+	MaxBodyBytes: 1 << 20,
+	ReadTimeout:  15 * time.Second,
+	WriteTimeout: 30 * time.Second,
+	IdleTimeout:  60 * time.Second,
+	CallTimeout:  20 * time.Second,
+}
+
+// loadServerConfig returns the default configuration with any environment overrides applied
+func loadServerConfig() (serverConfig, error) {
+	config := defaultServerConfig
+	envBytes, ok := os.LookupEnv("MAX_BODY_BYTES")
+	if ok && envBytes != "" {
+		maxBodyBytes, err := strconv.ParseInt(envBytes, 10, 64)
+		if err != nil || maxBodyBytes <= 0 {
+			return config, fmt.Errorf("invalid MAX_BODY_BYTES %q: must be a positive number of bytes", envBytes)
+		}
+		config.MaxBodyBytes = maxBodyBytes
+	}
+	durations := map[string]*time.Duration{
+		"READ_TIMEOUT":  &config.ReadTimeout,
+		"WRITE_TIMEOUT": &config.WriteTimeout,
+		"IDLE_TIMEOUT":  &config.IdleTimeout,
+		"CALL_TIMEOUT":  &config.CallTimeout,
+	}
+	for name, target := range durations {
+		envDuration, ok := os.LookupEnv(name)
+		if !ok || envDuration == "" {
+			continue
+		}
+		duration, err := time.ParseDuration(envDuration)
+		if err != nil || duration <= 0 {
+			return config, fmt.Errorf("invalid %s %q: must be a positive duration such as 30s", name, envDuration)
+		}
+		*target = duration
+	}
+	if config.WriteTimeout < config.CallTimeout {
+		return config, fmt.Errorf("WRITE_TIMEOUT (%s) must not be shorter than CALL_TIMEOUT (%s)", config.WriteTimeout, config.CallTimeout)
+	}
+	return config, nil
+}
+
+// limitedBody fails with errRequestTooLarge once more than the allowed number of bytes has been read
+type limitedBody struct {
+	io.ReadCloser
+	remaining int64
+}
+
+func (lb *limitedBody) Read(data []byte) (int, error) {
+	if lb.remaining <= 0 {
+		// the limit has been reached; any further byte makes the body too large
+		var probe [1]byte
+		n, err := lb.ReadCloser.Read(probe[:])
+		if n > 0 {
+			return 0, errRequestTooLarge
+		}
+		return 0, err
+	}
+	if int64(len(data)) > lb.remaining {
+		data = data[:lb.remaining]
+	}
+	n, err := lb.ReadCloser.Read(data)
+	lb.remaining -= int64(n)
+	return n, err
+}
+
+// limitMiddleware bounds the size of request bodies and gives every request a deadline that is passed on to
+// the Mattermost clients created for it
+func limitMiddleware(config serverConfig) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if r.ContentLength > config.MaxBodyBytes {
+				sendErrorResponse(w, errRequestTooLarge)
+				return
+			}
+			r.Body = &limitedBody{ReadCloser: r.Body, remaining: config.MaxBodyBytes}
+			ctx, cancel := context.WithTimeout(r.Context(), config.CallTimeout)
+			defer cancel()
+			next.ServeHTTP(w, r.WithContext(ctx))
+		})
+	}
+}
+
+// contextTransport sends every request with the supplied context so that it is cancelled with the call
+type contextTransport struct {
+	ctx  context.Context
+	base http.RoundTripper
+}
+
+func (ct *contextTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	return ct.base.RoundTrip(req.WithContext(ct.ctx))
+}
+
+// withContext binds the client's outbound requests to the context and records them in its trace
+func withContext(ctx context.Context, clt *appclient.Client) *appclient.Client {
+	httpClient := &http.Client{
+		Transport: &contextTransport{
+			ctx:  ctx,
+			base: &tracedTransport{base: http.DefaultTransport},
+		},
+	}
+	clt.Client4.HTTPClient = httpClient
+	clt.ClientPP.HTTPClient = httpClient
+	return clt
+}
+
+// asBot returns a bot client whose requests end with the context
+func asBot(ctx context.Context, appContext apps.Context) mattermostClient {
+	return newMattermostClient(ctx, withContext(ctx, appclient.AsBot(appContext)))
+}
+
+// asActingUser returns an acting user client whose requests end with the context
+func asActingUser(ctx context.Context, appContext apps.Context) mattermostClient {
+	return newMattermostClient(ctx, withContext(ctx, appclient.AsActingUser(appContext)))
+}
@@ -0,0 +1,118 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/mattermost/mattermost-plugin-apps/apps"
+)
+
+// useServerConfig serves the app with the configuration for the rest of the test
+func (at *appTest) useServerConfig(config serverConfig) {
+	at.t.Helper()
+	at.app.Close()
+	at.app = httptest.NewServer(newServerHandler(config))
+	at.t.Cleanup(at.app.Close)
+}
+
+func TestLimitedBodyFailsPastTheLimit(t *testing.T) {
+	tests := []struct {
+		size    int
+		limit   int64
+		wantErr error
+	}{
+		{size: 0, limit: 4},
+		{size: 3, limit: 4},
+		{size: 4, limit: 4},
+		{size: 5, limit: 4, wantErr: errRequestTooLarge},
+		{size: 1 << 16, limit: 1024, wantErr: errRequestTooLarge},
+	}
+	for _, test := range tests {
+		body := &limitedBody{
+			ReadCloser: io.NopCloser(bytes.NewReader(make([]byte, test.size))),
+			remaining:  test.limit,
+		}
+		data, err := io.ReadAll(body)
+		if !errors.Is(err, test.wantErr) {
+			t.Errorf("reading %d bytes with a limit of %d returned %v, want %v", test.size, test.limit, err, test.wantErr)
+		}
+		if test.wantErr == nil && len(data) != test.size {
+			t.Errorf("reading %d bytes with a limit of %d returned %d bytes", test.size, test.limit, len(data))
+		}
+	}
+}
+
+func TestOversizedCallsAreRejected(t *testing.T) {
+	at := newAppTest(t)
+	config := defaultServerConfig
+	config.MaxBodyBytes = 512
+	at.useServerConfig(config)
+	body, err := json.Marshal(apps.CallRequest{
+		Call:   *apps.NewCall("/info"),
+		Values: map[string]interface{}{"padding": strings.Repeat("x", 1024)},
+	})
+	if err != nil {
+		t.Fatalf("error encoding call request: %s", err.Error())
+	}
+	header := http.Header{
+		"Content-Type":          []string{"application/json"},
+		apps.OutgoingAuthHeader: []string{at.signCall(testAppSecret)},
+	}
+	// a declared length is rejected before the body is read, and a chunked body once the limit has been read
+	for _, contentLength := range []int64{int64(len(body)), -1} {
+		request, err := http.NewRequest(http.MethodPost, at.app.URL+"/info", io.NopCloser(bytes.NewReader(body)))
+		if err != nil {
+			t.Fatalf("error creating request: %s", err.Error())
+		}
+		request.Header = header
+		request.ContentLength = contentLength
+		response, err := http.DefaultClient.Do(request)
+		if err != nil {
+			t.Fatalf("error posting the call: %s", err.Error())
+		}
+		callResponse := apps.CallResponse{}
+		err = json.NewDecoder(response.Body).Decode(&callResponse)
+		_ = response.Body.Close()
+		if err != nil {
+			t.Fatalf("error decoding the response: %s", err.Error())
+		}
+		if response.StatusCode != http.StatusOK || callResponse.Type != apps.CallResponseTypeError ||
+			!strings.Contains(callResponse.Text, "the request is too large") {
+			t.Errorf("content length %d: got status %d and %s response %q, want a too large error",
+				contentLength, response.StatusCode, callResponse.Type, callResponse.Text)
+		}
+	}
+}
+
+func TestCallsAreCancelledAtTheirDeadline(t *testing.T) {
+	at := newAppTest(t)
+	config := defaultServerConfig
+	config.CallTimeout = 100 * time.Millisecond
+	at.useServerConfig(config)
+	released := make(chan struct{})
+	slowMattermost := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case <-r.Context().Done():
+		case <-released:
+		}
+	}))
+	defer slowMattermost.Close()
+	defer close(released)
+	appContext := at.context()
+	appContext.MattermostSiteURL = slowMattermost.URL
+	start := time.Now()
+	at.mustFailCall(apps.CallRequest{
+		Call:    *apps.NewCall("/notes"),
+		Context: appContext,
+	}, "the request took too long to complete")
+	if elapsed := time.Since(start); elapsed > 2*time.Second {
+		t.Errorf("the call took %s, want it cancelled after %s", elapsed, config.CallTimeout)
+	}
+}
@@ -0,0 +1,115 @@
+package main
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"embed"
+	"encoding/hex"
+	"fmt"
+	"io/fs"
+	"log"
+	"mime"
+	"net/http"
+	"os"
+	"path"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+const (
+	// staticPath is the path that the files of the static/ directory are served under
+	staticPath = "/static"
+	// staticCacheControl lets clients reuse an asset for an hour before revalidating it with its ETag
+	staticCacheControl = "public, max-age=3600"
+)
+
+//go:embed static
+var embeddedStatic embed.FS
+
+// staticFS holds the files of the static/ directory, named relative to it
+var staticFS = newStaticFS()
+
+func newStaticFS() fs.FS {
+	staticFiles, err := fs.Sub(embeddedStatic, "static")
+	if err != nil {
+		// fs.Sub only fails for an invalid directory name
+		panic(err)
+	}
+	return staticFiles
+}
+
+// staticAsset is a static file with the headers it is served with
+type staticAsset struct {
+	data        []byte
+	contentType string
+	etag        string
+}
+
+// staticHandler serves the files of a file system with their content types, a strong ETag derived from the
+// content and a Last-Modified time, and answers conditional and range requests
+type staticHandler struct {
+	assets  map[string]staticAsset
+	modTime time.Time
+}
+
+// newStaticHandler reads every file of the file system; embedded files have no modification time, so they are
+// reported as last modified when the executable was
+func newStaticHandler(fsys fs.FS) (*staticHandler, error) {
+	handler := &staticHandler{
+		assets:  make(map[string]staticAsset),
+		modTime: executableModTime(),
+	}
+	err := fs.WalkDir(fsys, ".", func(name string, entry fs.DirEntry, err error) error {
+		if err != nil || entry.IsDir() {
+			return err
+		}
+		data, err := fs.ReadFile(fsys, name)
+		if err != nil {
+			return err
+		}
+		contentType := mime.TypeByExtension(path.Ext(name))
+		if contentType == "" {
+			contentType = http.DetectContentType(data)
+		}
+		sum := sha256.Sum256(data)
+		handler.assets[name] = staticAsset{
+			data:        data,
+			contentType: contentType,
+			etag:        fmt.Sprintf("%q", hex.EncodeToString(sum[:16])),
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("error reading static files: %w", err)
+	}
+	return handler, nil
+}
+
+// executableModTime returns the modification time of the running executable, or the current time if it is not
+// known
+func executableModTime() time.Time {
+	executable, err := os.Executable()
+	if err == nil {
+		var info os.FileInfo
+		info, err = os.Stat(executable)
+		if err == nil {
+			return info.ModTime().UTC().Truncate(time.Second)
+		}
+	}
+	log.Printf("executableModTime(): %s\n", err.Error())
+	return time.Now().UTC().Truncate(time.Second)
+}
+
+func (h *staticHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	name := mux.Vars(r)["path"]
+	asset, ok := h.assets[name]
+	if !ok {
+		http.NotFound(w, r)
+		return
+	}
+	w.Header().Set("Content-Type", asset.contentType)
+	w.Header().Set("ETag", asset.etag)
+	w.Header().Set("Cache-Control", staticCacheControl)
+	http.ServeContent(w, r, name, h.modTime, bytes.NewReader(asset.data))
+}
@@ -0,0 +1,96 @@
+package main
+
+import (
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// getStatic fetches a path from the app with the request headers
+func (at *appTest) getStatic(method string, path string, header map[string]string) *http.Response {
+	at.t.Helper()
+	request, err := http.NewRequest(method, at.app.URL+path, nil)
+	if err != nil {
+		at.t.Fatalf("error creating request: %s", err.Error())
+	}
+	for name, value := range header {
+		request.Header.Set(name, value)
+	}
+	response, err := http.DefaultClient.Do(request)
+	if err != nil {
+		at.t.Fatalf("error requesting %s: %s", path, err.Error())
+	}
+	_ = response.Body.Close()
+	return response
+}
+
+func TestStaticFilesAreEmbeddedAndServed(t *testing.T) {
+	paths, err := filepath.Glob(filepath.Join("static", "*"))
+	if err != nil || len(paths) == 0 {
+		t.Fatalf("error listing static/: %v", err)
+	}
+	at := newAppTest(t)
+	for _, path := range paths {
+		name := filepath.Base(path)
+		data, err := os.ReadFile(path)
+		if err != nil {
+			t.Fatalf("error reading %s: %s", path, err.Error())
+		}
+		response := at.getStatic(http.MethodGet, staticPath+"/"+name, nil)
+		if response.StatusCode != http.StatusOK {
+			t.Errorf("%s returned status %d", name, response.StatusCode)
+			continue
+		}
+		if response.ContentLength != int64(len(data)) {
+			t.Errorf("%s has length %d, want %d", name, response.ContentLength, len(data))
+		}
+		if contentType := response.Header.Get("Content-Type"); contentType != "image/png" {
+			t.Errorf("%s has content type %q", name, contentType)
+		}
+		if response.Header.Get("ETag") == "" || response.Header.Get("Last-Modified") == "" {
+			t.Errorf("%s has no validators: %v", name, response.Header)
+		}
+		if cacheControl := response.Header.Get("Cache-Control"); cacheControl != staticCacheControl {
+			t.Errorf("%s has cache control %q", name, cacheControl)
+		}
+	}
+}
+
+func TestStaticFilesAnswerConditionalRequests(t *testing.T) {
+	at := newAppTest(t)
+	path := staticPath + "/icon.png"
+	response := at.getStatic(http.MethodHead, path, nil)
+	etag, lastModified := response.Header.Get("ETag"), response.Header.Get("Last-Modified")
+	if response.StatusCode != http.StatusOK || etag == "" {
+		t.Fatalf("HEAD returned status %d and ETag %q", response.StatusCode, etag)
+	}
+	response = at.getStatic(http.MethodGet, path, map[string]string{"If-None-Match": etag})
+	if response.StatusCode != http.StatusNotModified {
+		t.Errorf("a matching ETag returned status %d", response.StatusCode)
+	}
+	response = at.getStatic(http.MethodGet, path, map[string]string{"If-None-Match": `"stale"`})
+	if response.StatusCode != http.StatusOK {
+		t.Errorf("a stale ETag returned status %d", response.StatusCode)
+	}
+	response = at.getStatic(http.MethodGet, path, map[string]string{"If-Modified-Since": lastModified})
+	if response.StatusCode != http.StatusNotModified {
+		t.Errorf("an unchanged asset returned status %d", response.StatusCode)
+	}
+	before := time.Now().Add(-24 * 365 * time.Hour).UTC().Format(http.TimeFormat)
+	response = at.getStatic(http.MethodGet, path, map[string]string{"If-Modified-Since": before})
+	if response.StatusCode != http.StatusOK {
+		t.Errorf("a changed asset returned status %d", response.StatusCode)
+	}
+}
+
+func TestStaticMissingFilesAreNotFound(t *testing.T) {
+	at := newAppTest(t)
+	for _, path := range []string{staticPath + "/missing.png", staticPath + "/", staticPath + "/../main.go"} {
+		response := at.getStatic(http.MethodGet, path, nil)
+		if response.StatusCode != http.StatusNotFound {
+			t.Errorf("%s returned status %d, want %d", path, response.StatusCode, http.StatusNotFound)
+		}
+	}
+}
@@ -0,0 +1,215 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"regexp"
+	"sync"
+	"time"
+)
+
+const (
+	// traceparentHeader propagates the trace context in the W3C Trace Context format used by OpenTelemetry
+	traceparentHeader = "traceparent"
+	// traceIDHeader returns the trace ID of a call to its caller
+	traceIDHeader = "X-Trace-Id"
+)
+
+var traceparentPattern = regexp.MustCompile(`^00-([0-9a-f]{32})-([0-9a-f]{16})-[0-9a-f]{2}$`)
+
+type spanContextKey struct{}
+
+// traceSpan is one timed operation of a trace, exported as a JSON line with its IDs, its start and end in Unix
+// nanoseconds, its attributes and a status of "OK" or "ERROR"
+type traceSpan struct {
+	TraceID      string            `json:"traceId"`
+	SpanID       string            `json:"spanId"`
+	ParentSpanID string            `json:"parentSpanId,omitempty"`
+	Name         string            `json:"name"`
+	Kind         string            `json:"kind"`
+	StartTime    int64             `json:"startTimeUnixNano"`
+	EndTime      int64             `json:"endTimeUnixNano"`
+	Attributes   map[string]string `json:"attributes,omitempty"`
+	Status       traceSpanStatus   `json:"status"`
+
+	start time.Time
+}
+
+type traceSpanStatus struct {
+	Code    string `json:"code"`
+	Message string `json:"message,omitempty"`
+}
+
+// traceExporter writes finished spans as JSON lines
+type traceExporter struct {
+	mutex  sync.Mutex
+	writer io.Writer
+}
+
+// spanExporter is nil when tracing is disabled; spans are then still created so that trace IDs appear in logs
+var spanExporter *traceExporter
+
+// setupTracing configures the span exporter from TRACE_EXPORTER, which is "stdout", "file" or unset, and
+// TRACE_FILE, the file that the "file" exporter appends to
+func setupTracing() error {
+	exporter, ok := os.LookupEnv("TRACE_EXPORTER")
+	if !ok || exporter == "" || exporter == "none" {
+		return nil
+	}
+	switch exporter {
+	case "stdout":
+		spanExporter = &traceExporter{writer: os.Stdout}
+	case "file":
+		path := os.Getenv("TRACE_FILE")
+		if path == "" {
+			path = "traces.jsonl"
+		}
+		file, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o644)
+		if err != nil {
+			return fmt.Errorf("error opening trace file: %w", err)
+		}
+		spanExporter = &traceExporter{writer: file}
+	default:
+		return fmt.Errorf("invalid TRACE_EXPORTER %q: must be stdout or file", exporter)
+	}
+	return nil
+}
+
+func (te *traceExporter) export(span *traceSpan) {
+	encodedSpan, err := json.Marshal(span)
+	if err != nil {
+		log.Printf("traceExporter.export(): error encoding span: %s\n", err.Error())
+		return
+	}
+	te.mutex.Lock()
+	defer te.mutex.Unlock()
+	_, err = te.writer.Write(append(encodedSpan, '\n'))
+	if err != nil {
+		log.Printf("traceExporter.export(): error writing span: %s\n", err.Error())
+	}
+}
+
+func newTraceID(size int) string {
+	id := make([]byte, size)
+	_, err := rand.Read(id)
+	if err != nil {
+		log.Printf("newTraceID(): error generating ID: %s\n", err.Error())
+	}
+	return hex.EncodeToString(id)
+}
+
+// spanFromContext returns the current span, or nil outside of a trace
+func spanFromContext(ctx context.Context) *traceSpan {
+	span, _ := ctx.Value(spanContextKey{}).(*traceSpan)
+	return span
+}
+
+// traceID returns the trace ID of the context, or an empty string outside of a trace
+func traceID(ctx context.Context) string {
+	if span := spanFromContext(ctx); span != nil {
+		return span.TraceID
+	}
+	return ""
+}
+
+// startSpan starts a span that is a child of the context's current span, or the root of a new trace
+func startSpan(ctx context.Context, name string, kind string) (context.Context, *traceSpan) {
+	span := &traceSpan{
+		SpanID:     newTraceID(8),
+		Name:       name,
+		Kind:       kind,
+		Attributes: make(map[string]string),
+		start:      time.Now(),
+	}
+	if parent := spanFromContext(ctx); parent != nil {
+		span.TraceID = parent.TraceID
+		span.ParentSpanID = parent.SpanID
+	} else {
+		span.TraceID = newTraceID(16)
+	}
+	return context.WithValue(ctx, spanContextKey{}, span), span
+}
+
+// fail marks the span as failed even if the response that ends it looks successful
+func (ts *traceSpan) fail(err error) {
+	ts.Status = traceSpanStatus{Code: "ERROR", Message: err.Error()}
+}
+
+// end records the outcome of the span and exports it
+func (ts *traceSpan) end(err error) {
+	ts.StartTime = ts.start.UnixNano()
+	ts.EndTime = time.Now().UnixNano()
+	if err != nil {
+		ts.fail(err)
+	} else if ts.Status.Code == "" {
+		ts.Status.Code = "OK"
+	}
+	if spanExporter != nil {
+		spanExporter.export(ts)
+	}
+}
+
+// traceparent returns the header value that continues the span's trace in another service
+func (ts *traceSpan) traceparent() string {
+	return fmt.Sprintf("00-%s-%s-01", ts.TraceID, ts.SpanID)
+}
+
+// tracingMiddleware starts the server span of every request, continuing the caller's trace if it sent one, and
+// returns the trace ID in a response header
+func tracingMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ctx := r.Context()
+		if matches := traceparentPattern.FindStringSubmatch(r.Header.Get(traceparentHeader)); matches != nil {
+			ctx = context.WithValue(ctx, spanContextKey{}, &traceSpan{TraceID: matches[1], SpanID: matches[2]})
+		}
+		ctx, span := startSpan(ctx, fmt.Sprintf("%s %s", r.Method, r.URL.Path), "SERVER")
+		span.Attributes["http.method"] = r.Method
+		span.Attributes["http.target"] = r.URL.Path
+		w.Header().Set(traceIDHeader, span.TraceID)
+		recorder := &statusRecorder{ResponseWriter: w}
+		next.ServeHTTP(recorder, r.WithContext(ctx))
+		statusCode := recorder.statusCode
+		if statusCode == 0 {
+			statusCode = http.StatusOK
+		}
+		span.Attributes["http.status_code"] = fmt.Sprint(statusCode)
+		log.Printf("tracingMiddleware(): trace %s: %s %s returned %d in %s\n",
+			span.TraceID, r.Method, r.URL.Path, statusCode, time.Since(span.start))
+		var err error
+		if statusCode >= http.StatusBadRequest {
+			err = fmt.Errorf("status %d", statusCode)
+		}
+		span.end(err)
+	})
+}
+
+// tracedTransport records a client span for every outbound request and propagates the trace to the server
+type tracedTransport struct {
+	base http.RoundTripper
+}
+
+func (tt *tracedTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	ctx, span := startSpan(req.Context(), fmt.Sprintf("%s %s", req.Method, req.URL.Path), "CLIENT")
+	span.Attributes["http.method"] = req.Method
+	span.Attributes["http.url"] = req.URL.Redacted()
+	req = req.Clone(ctx)
+	req.Header.Set(traceparentHeader, span.traceparent())
+	resp, err := tt.base.RoundTrip(req)
+	if err != nil {
+		span.end(err)
+		return nil, err
+	}
+	span.Attributes["http.status_code"] = fmt.Sprint(resp.StatusCode)
+	if resp.StatusCode >= http.StatusBadRequest {
+		err = fmt.Errorf("status %d", resp.StatusCode)
+	}
+	span.end(err)
+	return resp, nil
+}
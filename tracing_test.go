@@ -0,0 +1,134 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/mattermost/mattermost-plugin-apps/apps"
+)
+
+// exportSpans replaces the span exporter for the test and returns a function that decodes the exported spans
+func exportSpans(t *testing.T) func() []traceSpan {
+	t.Helper()
+	previous := spanExporter
+	buffer := &bytes.Buffer{}
+	spanExporter = &traceExporter{writer: buffer}
+	t.Cleanup(func() {
+		spanExporter = previous
+	})
+	return func() []traceSpan {
+		spans := make([]traceSpan, 0)
+		scanner := bufio.NewScanner(bytes.NewReader(buffer.Bytes()))
+		for scanner.Scan() {
+			span := traceSpan{}
+			err := json.Unmarshal(scanner.Bytes(), &span)
+			if err != nil {
+				t.Fatalf("error decoding span %s: %s", scanner.Text(), err.Error())
+			}
+			spans = append(spans, span)
+		}
+		return spans
+	}
+}
+
+func TestTracingContinuesTheCallersTrace(t *testing.T) {
+	spans := exportSpans(t)
+	upstreamTraceparent := ""
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		upstreamTraceparent = r.Header.Get(traceparentHeader)
+	}))
+	defer upstream.Close()
+	handler := tracingMiddleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		request, err := http.NewRequestWithContext(r.Context(), http.MethodGet, upstream.URL+"/api/v4/users/me", nil)
+		if err != nil {
+			t.Fatalf("error creating request: %s", err.Error())
+		}
+		client := &http.Client{Transport: &tracedTransport{base: http.DefaultTransport}}
+		response, err := client.Do(request)
+		if err != nil {
+			t.Fatalf("error requesting upstream: %s", err.Error())
+		}
+		_ = response.Body.Close()
+	}))
+	callerTraceID, callerSpanID := strings.Repeat("a", 32), strings.Repeat("b", 16)
+	request := httptest.NewRequest(http.MethodPost, "/info", nil)
+	request.Header.Set(traceparentHeader, "00-"+callerTraceID+"-"+callerSpanID+"-01")
+	recorder := httptest.NewRecorder()
+	handler.ServeHTTP(recorder, request)
+
+	if id := recorder.Header().Get(traceIDHeader); id != callerTraceID {
+		t.Errorf("response has trace ID %q, want the caller's %q", id, callerTraceID)
+	}
+	exported := spans()
+	if len(exported) != 2 {
+		t.Fatalf("exported %d spans, want a client and a server span: %+v", len(exported), exported)
+	}
+	client, server := exported[0], exported[1]
+	if server.Kind != "SERVER" || server.TraceID != callerTraceID || server.ParentSpanID != callerSpanID {
+		t.Errorf("server span %+v does not continue the caller's span", server)
+	}
+	if client.Kind != "CLIENT" || client.TraceID != callerTraceID || client.ParentSpanID != server.SpanID {
+		t.Errorf("client span %+v is not a child of the server span", client)
+	}
+	if want := "00-" + callerTraceID + "-" + client.SpanID + "-01"; upstreamTraceparent != want {
+		t.Errorf("upstream received traceparent %q, want %q", upstreamTraceparent, want)
+	}
+	if server.Status.Code != "OK" || client.Status.Code != "OK" || client.Attributes["http.status_code"] != "200" {
+		t.Errorf("unexpected statuses: server %+v, client %+v", server.Status, client.Status)
+	}
+	if server.StartTime == 0 || server.EndTime < server.StartTime {
+		t.Errorf("server span has start %d and end %d", server.StartTime, server.EndTime)
+	}
+}
+
+func TestTracingStartsATraceWithoutACaller(t *testing.T) {
+	spans := exportSpans(t)
+	handler := tracingMiddleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if traceID(r.Context()) == "" {
+			t.Errorf("the handler has no trace ID")
+		}
+		w.WriteHeader(http.StatusMethodNotAllowed)
+	}))
+	recorder := httptest.NewRecorder()
+	handler.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/info", nil))
+	exported := spans()
+	if len(exported) != 1 {
+		t.Fatalf("exported %d spans, want 1", len(exported))
+	}
+	if !traceparentPattern.MatchString(exported[0].traceparent()) || exported[0].ParentSpanID != "" {
+		t.Errorf("span %+v is not the root of a new trace", exported[0])
+	}
+	if exported[0].TraceID != recorder.Header().Get(traceIDHeader) {
+		t.Errorf("span trace ID %q differs from the response's %q", exported[0].TraceID, recorder.Header().Get(traceIDHeader))
+	}
+	if exported[0].Status.Code != "ERROR" {
+		t.Errorf("a 405 response was recorded with status %+v", exported[0].Status)
+	}
+}
+
+func TestTracingRecordsPanicsAsErrors(t *testing.T) {
+	spans := exportSpans(t)
+	handler := tracingMiddleware(recoverMiddleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		panic("boom")
+	})))
+	recorder := httptest.NewRecorder()
+	handler.ServeHTTP(recorder, httptest.NewRequest(http.MethodPost, "/info", nil))
+	callResponse := apps.CallResponse{}
+	err := json.Unmarshal(recorder.Body.Bytes(), &callResponse)
+	if err != nil {
+		t.Fatalf("error decoding response %s: %s", recorder.Body.String(), err.Error())
+	}
+	id := recorder.Header().Get(traceIDHeader)
+	if recorder.Code != http.StatusOK || callResponse.Type != apps.CallResponseTypeError || !strings.HasSuffix(callResponse.Text, "[trace ID "+id+"]") {
+		t.Errorf("got status %d and %s response %q, want an error naming trace %s", recorder.Code, callResponse.Type, callResponse.Text, id)
+	}
+	exported := spans()
+	if len(exported) != 1 || exported[0].Status.Code != "ERROR" || !strings.Contains(exported[0].Status.Message, "boom") {
+		t.Errorf("the panic was not recorded as an error: %+v", exported)
+	}
+}
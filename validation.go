@@ -0,0 +1,169 @@
+package main
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"unicode/utf8"
+
+	"github.com/mattermost/mattermost-plugin-apps/apps"
+)
+
+// mattermostIDPattern matches the 26 character IDs used for Mattermost teams, channels, users and posts
+var mattermostIDPattern = regexp.MustCompile(`^[a-z0-9]{26}$`)
+
+// fieldRule declares the constraints on a single submitted form value
+type fieldRule struct {
+	Required bool
+	// Pattern, if set, must match the whole value; PatternMessage is shown when it does not
+	Pattern        *regexp.Regexp
+	PatternMessage string
+	MinLength      int
+	MaxLength      int
+	// AllowedOptions, if set, lists the only values that may be submitted
+	AllowedOptions []string
+}
+
+// formRules maps a field name to the rule that its value must satisfy
+type formRules map[string]fieldRule
+
+// formRulesFor derives rules from a form's field definitions: IsRequired, the text length limits, the static
+// select options and the ID format of user and channel selects
+func formRulesFor(form apps.Form) formRules {
+	rules := make(formRules)
+	for _, field := range form.Fields {
+		rule := fieldRule{
+			Required:  field.IsRequired,
+			MinLength: field.TextMinLength,
+			MaxLength: field.TextMaxLength,
+		}
+		switch field.Type {
+		case apps.FieldTypeStaticSelect:
+			for _, option := range field.SelectStaticOptions {
+				rule.AllowedOptions = append(rule.AllowedOptions, option.Value)
+			}
+		case apps.FieldTypeUser:
+			rule.Pattern = mattermostIDPattern
+			rule.PatternMessage = "Must be a user."
+		case apps.FieldTypeChannel:
+			rule.Pattern = mattermostIDPattern
+			rule.PatternMessage = "Must be a channel."
+		}
+		rules[field.Name] = rule
+	}
+	return rules
+}
+
+// submittedValues returns the string forms of a submitted value; select options contribute their value,
+// multiselects contribute one string per option and an empty value contributes nothing
+func submittedValues(value interface{}) []string {
+	switch typedValue := value.(type) {
+	case nil:
+		return nil
+	case string:
+		if typedValue == "" {
+			return nil
+		}
+		return []string{typedValue}
+	case bool:
+		return []string{strconv.FormatBool(typedValue)}
+	case float64:
+		return []string{strconv.FormatFloat(typedValue, 'f', -1, 64)}
+	case map[string]interface{}:
+		return submittedValues(typedValue["value"])
+	case []interface{}:
+		values := make([]string, 0, len(typedValue))
+		for _, item := range typedValue {
+			values = append(values, submittedValues(item)...)
+		}
+		return values
+	default:
+		return []string{fmt.Sprintf("%v", typedValue)}
+	}
+}
+
+// isSelectedOption reports whether every object in the value is a select option with a value, so that a
+// malformed object is not mistaken for a missing value
+func isSelectedOption(value interface{}) bool {
+	switch typedValue := value.(type) {
+	case map[string]interface{}:
+		switch typedValue["value"].(type) {
+		case string, bool, float64:
+			return true
+		default:
+			return false
+		}
+	case []interface{}:
+		for _, item := range typedValue {
+			if !isSelectedOption(item) {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+// check returns the error message for the value, or an empty string if the value is valid
+func (rule fieldRule) check(value interface{}) string {
+	if !isSelectedOption(value) {
+		return "Must be a selected option."
+	}
+	values := submittedValues(value)
+	if len(values) == 0 {
+		if rule.Required {
+			return "This field is required."
+		}
+		return ""
+	}
+	for _, submitted := range values {
+		length := utf8.RuneCountInString(submitted)
+		if rule.MinLength > 0 && length < rule.MinLength {
+			return fmt.Sprintf("Must be at least %d characters.", rule.MinLength)
+		}
+		if rule.MaxLength > 0 && length > rule.MaxLength {
+			return fmt.Sprintf("Must be at most %d characters.", rule.MaxLength)
+		}
+		if rule.Pattern != nil && !rule.Pattern.MatchString(submitted) {
+			if rule.PatternMessage != "" {
+				return rule.PatternMessage
+			}
+			return "Has an invalid format."
+		}
+		if len(rule.AllowedOptions) > 0 && !containsString(rule.AllowedOptions, submitted) {
+			return fmt.Sprintf("%q is not one of the available options.", submitted)
+		}
+	}
+	return ""
+}
+
+// validate checks the submitted values and returns a map of field name to error message
+func (rules formRules) validate(values map[string]interface{}) map[string]string {
+	fieldErrors := make(map[string]string)
+	for name, rule := range rules {
+		message := rule.check(values[name])
+		if message != "" {
+			fieldErrors[name] = message
+		}
+	}
+	return fieldErrors
+}
+
+// newFieldErrorsResponse returns an error response that keeps the form open and highlights the invalid fields
+func newFieldErrorsResponse(fieldErrors map[string]string) apps.CallResponse {
+	return apps.CallResponse{
+		Type: apps.CallResponseTypeError,
+		Text: "Please correct the highlighted fields.",
+		Data: map[string]interface{}{
+			"errors": fieldErrors,
+		},
+	}
+}
+
+func containsString(list []string, value string) bool {
+	for _, item := range list {
+		if item == value {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,125 @@
+package main
+
+import (
+	"reflect"
+	"regexp"
+	"strings"
+	"testing"
+
+	"github.com/mattermost/mattermost-plugin-apps/apps"
+)
+
+func TestSubmittedValues(t *testing.T) {
+	tests := []struct {
+		name  string
+		value interface{}
+		want  []string
+	}{
+		{"nil", nil, nil},
+		{"empty text", "", nil},
+		{"text", "hello", []string{"hello"}},
+		{"bool", true, []string{"true"}},
+		{"integer", float64(42), []string{"42"}},
+		{"fraction", 2.5, []string{"2.5"}},
+		{"select option", map[string]interface{}{"label": "Button", "value": "button"}, []string{"button"}},
+		{"select option without a value", map[string]interface{}{"label": "Button"}, nil},
+		{"multiselect", []interface{}{
+			map[string]interface{}{"label": "One", "value": "1"},
+			map[string]interface{}{"label": "Two", "value": "2"},
+		}, []string{"1", "2"}},
+		{"empty multiselect", []interface{}{}, []string{}},
+	}
+	for _, test := range tests {
+		if got := submittedValues(test.value); !reflect.DeepEqual(got, test.want) {
+			t.Errorf("%s: got %#v, want %#v", test.name, got, test.want)
+		}
+	}
+}
+
+func TestFieldRuleCheck(t *testing.T) {
+	tests := []struct {
+		name  string
+		rule  fieldRule
+		value interface{}
+		// want is a part of the error message, or empty if the value is valid
+		want string
+	}{
+		{"missing optional value", fieldRule{}, nil, ""},
+		{"missing required value", fieldRule{Required: true}, nil, "This field is required."},
+		{"empty required text", fieldRule{Required: true}, "", "This field is required."},
+		{"empty required multiselect", fieldRule{Required: true}, []interface{}{}, "This field is required."},
+		{"required value", fieldRule{Required: true}, "x", ""},
+		{"too short", fieldRule{MinLength: 3}, "ab", "at least 3 characters"},
+		{"long enough", fieldRule{MinLength: 3}, "abc", ""},
+		{"too long", fieldRule{MaxLength: 3}, "abcd", "at most 3 characters"},
+		{"length in characters", fieldRule{MaxLength: 3}, "äöü", ""},
+		{"pattern mismatch", fieldRule{Pattern: regexp.MustCompile(`^[0-9]+$`)}, "12a", "Has an invalid format."},
+		{"pattern message", fieldRule{Pattern: regexp.MustCompile(`^[0-9]+$`), PatternMessage: "Must be a number."}, "12a", "Must be a number."},
+		{"pattern match", fieldRule{Pattern: regexp.MustCompile(`^[0-9]+$`)}, "12", ""},
+		{"allowed option", fieldRule{AllowedOptions: []string{"a", "b"}}, map[string]interface{}{"value": "b"}, ""},
+		{"unknown option", fieldRule{AllowedOptions: []string{"a", "b"}}, map[string]interface{}{"value": "c"}, `"c" is not one of the available options.`},
+		{"option without a value", fieldRule{Required: true}, map[string]interface{}{"label": "Button"}, "Must be a selected option."},
+		{"option with an object value", fieldRule{}, map[string]interface{}{"value": map[string]interface{}{}}, "Must be a selected option."},
+		{"multiselect with a malformed option", fieldRule{}, []interface{}{
+			map[string]interface{}{"value": "a"},
+			map[string]interface{}{"label": "b"},
+		}, "Must be a selected option."},
+		{"unknown option in a multiselect", fieldRule{AllowedOptions: []string{"a", "b"}}, []interface{}{
+			map[string]interface{}{"value": "a"},
+			map[string]interface{}{"value": "c"},
+		}, `"c" is not one`},
+	}
+	for _, test := range tests {
+		got := test.rule.check(test.value)
+		if test.want == "" && got != "" || !strings.Contains(got, test.want) {
+			t.Errorf("%s: got %q, want %q", test.name, got, test.want)
+		}
+	}
+}
+
+func TestFormRulesForDerivesRulesFromFields(t *testing.T) {
+	rules := formRulesFor(apps.Form{
+		Fields: []apps.Field{
+			{Type: apps.FieldTypeText, Name: "message", IsRequired: true, TextMinLength: 2, TextMaxLength: 10},
+			{Type: apps.FieldTypeStaticSelect, Name: "option", SelectStaticOptions: []apps.SelectOption{
+				{Label: "Button", Value: "button"},
+				{Label: "Link", Value: "link"},
+			}},
+			{Type: apps.FieldTypeUser, Name: "user"},
+			{Type: apps.FieldTypeChannel, Name: "channel"},
+		},
+	})
+	if rule := rules["message"]; !rule.Required || rule.MinLength != 2 || rule.MaxLength != 10 {
+		t.Errorf("unexpected text rule %+v", rule)
+	}
+	if rule := rules["option"]; rule.Required || !reflect.DeepEqual(rule.AllowedOptions, []string{"button", "link"}) {
+		t.Errorf("unexpected select rule %+v", rule)
+	}
+	valid := map[string]interface{}{
+		"message": "hi",
+		"option":  map[string]interface{}{"value": "link"},
+		"user":    map[string]interface{}{"value": strings.Repeat("u", 26)},
+		"channel": map[string]interface{}{"value": strings.Repeat("c", 26)},
+	}
+	if fieldErrors := rules.validate(valid); len(fieldErrors) != 0 {
+		t.Errorf("valid values returned errors %v", fieldErrors)
+	}
+	want := map[string]string{
+		"message": "This field is required.",
+		"option":  `"other" is not one of the available options.`,
+		"user":    "Must be a user.",
+		"channel": "Must be a channel.",
+	}
+	fieldErrors := rules.validate(map[string]interface{}{
+		"option":  map[string]interface{}{"value": "other"},
+		"user":    map[string]interface{}{"value": "someone"},
+		"channel": "town-square",
+	})
+	if !reflect.DeepEqual(fieldErrors, want) {
+		t.Errorf("got errors %v, want %v", fieldErrors, want)
+	}
+	response := newFieldErrorsResponse(fieldErrors)
+	if response.Type != apps.CallResponseTypeError || !reflect.DeepEqual(response.Data, map[string]interface{}{"errors": fieldErrors}) {
+		t.Errorf("unexpected field errors response %+v", response)
+	}
+}
@@ -0,0 +1,332 @@
+package main
+
+import (
+	"bytes"
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"path"
+	"strings"
+	"text/template"
+
+	"github.com/mattermost/mattermost-plugin-apps/apps"
+	"github.com/mattermost/mattermost-server/v6/model"
+)
+
+const (
+	webhookKVPrefix      = "webhook"
+	webhookCallPath      = "/webhook"
+	webhookDefaultFormat = "#### Incoming webhook\n```json\n{{ json . }}\n```"
+)
+
+// webhookRoute maps an incoming webhook to the channel it posts to
+type webhookRoute struct {
+	ID        string `json:"id"`
+	ChannelID string `json:"channel_id"`
+	Secret    string `json:"secret"`
+	Template  string `json:"template"`
+	CreatedBy string `json:"created_by"`
+}
+
+var (
+	webhookTemplateFuncs = template.FuncMap{
+		"json": func(v interface{}) (string, error) {
+			encoded, err := json.MarshalIndent(v, "", "  ")
+			return string(encoded), err
+		},
+	}
+
+	webhookCommandExpand = apps.Expand{
+		ActingUser: apps.ExpandSummary,
+		Channel:    apps.ExpandID,
+		Team:       apps.ExpandID,
+	}
+
+	webhookBinding = apps.Binding{
+		Location:    "webhook",
+		Label:       "webhook",
+		Description: "Manage incoming webhooks that post to this channel (system administrators only)",
+		Bindings: []apps.Binding{
+			{
+				Location:    "create",
+				Label:       "create",
+				Description: "Create an incoming webhook that posts to this channel",
+				Form: &apps.Form{
+					Title: "Create an incoming webhook",
+					Icon:  "icon.png",
+					Fields: []apps.Field{
+						{
+							Name:        "template",
+							Label:       "template",
+							Type:        apps.FieldTypeText,
+							TextSubtype: apps.TextFieldSubtypeTextarea,
+							Description: "A text/template used to render the JSON payload as Markdown",
+						},
+					},
+					Submit: apps.NewCall("/webhook-create").WithExpand(webhookCommandExpand),
+				},
+			},
+			{
+				Location:    "list",
+				Label:       "list",
+				Description: "List the incoming webhooks that post to this channel",
+				Submit:      apps.NewCall("/webhook-list").WithExpand(webhookCommandExpand),
+			},
+			{
+				Location:    "delete",
+				Label:       "delete",
+				Description: "Delete an incoming webhook",
+				Form: &apps.Form{
+					Fields: []apps.Field{
+						{
+							Name:                 "id",
+							Label:                "id",
+							Type:                 apps.FieldTypeText,
+							TextSubtype:          apps.TextFieldSubtypeInput,
+							Description:          "The ID of the webhook to delete",
+							IsRequired:           true,
+							AutocompletePosition: 1,
+						},
+					},
+					Submit: apps.NewCall("/webhook-delete").WithExpand(webhookCommandExpand),
+				},
+			},
+		},
+	}
+)
+
+func newWebhookSecret() (string, error) {
+	buf := make([]byte, 16)
+	_, err := rand.Read(buf)
+	if err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(buf), nil
+}
+
+// webhookURL returns the URL that a remote system uses to deliver payloads to the route
+func webhookURL(appContext apps.Context, route webhookRoute) string {
+	return fmt.Sprintf(
+		"%s%s%s/%s?secret=%s",
+		appContext.MattermostSiteURL,
+		appContext.AppPath,
+		webhookCallPath,
+		route.ID,
+		url.QueryEscape(route.Secret),
+	)
+}
+
+func parseWebhookTemplate(text string) (*template.Template, error) {
+	return template.New("webhook").Funcs(webhookTemplateFuncs).Parse(text)
+}
+
+func requireSystemAdmin(appContext apps.Context) error {
+	if appContext.ActingUser == nil || !appContext.ActingUser.IsSystemAdmin() {
+		return newForbiddenError("this command is restricted to system administrators")
+	}
+	return nil
+}
+
+func getWebhookRoute(clt mattermostClient, id string) (*webhookRoute, error) {
+	route := webhookRoute{}
+	err := clt.KVGet(webhookKVPrefix, id, &route)
+	if err != nil {
+		return nil, err
+	}
+	if route.ID == "" {
+		return nil, nil
+	}
+	return &route, nil
+}
+
+func webhookCreate(w http.ResponseWriter, r *http.Request) {
+	callRequest, err := getCallRequest(r)
+	if err != nil {
+		log.Printf("webhookCreate(): %s\n", err.Error())
+		sendErrorResponse(w, err)
+		return
+	}
+	err = requireSystemAdmin(callRequest.Context)
+	if err != nil {
+		sendErrorResponse(w, err)
+		return
+	}
+	templateText := callRequest.GetValue("template", webhookDefaultFormat)
+	_, err = parseWebhookTemplate(templateText)
+	if err != nil {
+		err = newUserInputError("invalid template: %s", err.Error())
+		sendErrorResponse(w, err)
+		return
+	}
+	secret, err := newWebhookSecret()
+	if err != nil {
+		sendErrorResponse(w, err)
+		return
+	}
+	route := webhookRoute{
+		ID:        model.NewId(),
+		ChannelID: callRequest.Context.Channel.Id,
+		Secret:    secret,
+		Template:  templateText,
+		CreatedBy: callRequest.Context.ActingUser.Id,
+	}
+	clt := asBot(r.Context(), callRequest.Context)
+	err = addBotToChannel(clt, callRequest.Context, route.ChannelID)
+	if err != nil {
+		sendErrorResponse(w, err)
+		return
+	}
+	_, err = clt.KVSet(webhookKVPrefix, route.ID, route)
+	if err != nil {
+		err = newUpstreamError(err, "error storing webhook")
+		sendErrorResponse(w, err)
+		return
+	}
+	err = addToKVIndex(clt, webhookKVPrefix, route.ID)
+	if err != nil {
+		err = newUpstreamError(err, "error storing webhook index")
+		sendErrorResponse(w, err)
+		return
+	}
+	sendCallResponse(w, apps.NewTextResponse("%s", renderMessage(r.Context(), callRequest.Context, "webhook_created", messageData{
+		"ID":  route.ID,
+		"URL": webhookURL(callRequest.Context, route),
+	})))
+}
+
+func webhookList(w http.ResponseWriter, r *http.Request) {
+	callRequest, err := getCallRequest(r)
+	if err != nil {
+		log.Printf("webhookList(): %s\n", err.Error())
+		sendErrorResponse(w, err)
+		return
+	}
+	err = requireSystemAdmin(callRequest.Context)
+	if err != nil {
+		sendErrorResponse(w, err)
+		return
+	}
+	clt := asBot(r.Context(), callRequest.Context)
+	index, err := getKVIndex(clt, webhookKVPrefix)
+	if err != nil {
+		err = newUpstreamError(err, "error reading webhook index")
+		sendErrorResponse(w, err)
+		return
+	}
+	responseText := "## Webhooks in this channel\n"
+	count := 0
+	for _, id := range index {
+		route, err := getWebhookRoute(clt, id)
+		if err != nil {
+			err = newUpstreamError(err, "error reading webhook %s", id)
+			sendErrorResponse(w, err)
+			return
+		}
+		if route == nil || route.ChannelID != callRequest.Context.Channel.Id {
+			continue
+		}
+		responseText += fmt.Sprintf("- `%s`: %s\n", route.ID, webhookURL(callRequest.Context, *route))
+		count++
+	}
+	if count == 0 {
+		responseText = "there are no webhooks in this channel"
+	}
+	sendCallResponse(w, apps.NewTextResponse("%s", responseText))
+}
+
+func webhookDelete(w http.ResponseWriter, r *http.Request) {
+	callRequest, err := getCallRequest(r)
+	if err != nil {
+		log.Printf("webhookDelete(): %s\n", err.Error())
+		sendErrorResponse(w, err)
+		return
+	}
+	err = requireSystemAdmin(callRequest.Context)
+	if err != nil {
+		sendErrorResponse(w, err)
+		return
+	}
+	id := callRequest.GetValue("id", "")
+	if id == "" || id == kvIndexKey {
+		sendErrorResponse(w, newUserInputError("invalid webhook id"))
+		return
+	}
+	clt := asBot(r.Context(), callRequest.Context)
+	removed, err := removeFromKVIndex(clt, webhookKVPrefix, id)
+	if err != nil {
+		err = newUpstreamError(err, "error storing webhook index")
+		sendErrorResponse(w, err)
+		return
+	}
+	if !removed {
+		sendErrorResponse(w, newNotFoundError("no webhook with that id"))
+		return
+	}
+	err = clt.KVDelete(webhookKVPrefix, id)
+	if err != nil {
+		err = newUpstreamError(err, "error deleting webhook")
+		sendErrorResponse(w, err)
+		return
+	}
+	sendCallResponse(w, apps.NewTextResponse("%s", renderMessage(r.Context(), callRequest.Context, "webhook_deleted", messageData{
+		"ID": id,
+	})))
+}
+
+// handleWebhook renders an incoming remote webhook payload and posts it to the route's channel
+func handleWebhook(w http.ResponseWriter, r *http.Request) {
+	callRequest, err := getCallRequest(r)
+	if err != nil {
+		log.Printf("handleWebhook(): %s\n", err.Error())
+		sendErrorResponse(w, err)
+		return
+	}
+	id := path.Base(callRequest.Path)
+	if id == "" || id == kvIndexKey || !strings.HasPrefix(callRequest.Path, webhookCallPath+"/") {
+		sendErrorResponse(w, newUserInputError("invalid webhook id"))
+		return
+	}
+	clt := asBot(r.Context(), callRequest.Context)
+	route, err := getWebhookRoute(clt, id)
+	if err != nil {
+		err = newUpstreamError(err, "error reading webhook")
+		sendErrorResponse(w, err)
+		return
+	}
+	// the apps plugin does not authenticate the request, so the route's secret must be checked here
+	query, err := url.ParseQuery(callRequest.GetValue("rawQuery", ""))
+	if err != nil || route == nil || subtle.ConstantTimeCompare([]byte(query.Get("secret")), []byte(route.Secret)) != 1 {
+		sendErrorResponse(w, newForbiddenError("webhook secret mismatched"))
+		return
+	}
+	tmpl, err := parseWebhookTemplate(route.Template)
+	if err != nil {
+		err = newInternalError(err, "the webhook has an invalid template")
+		sendErrorResponse(w, err)
+		return
+	}
+	message := new(bytes.Buffer)
+	err = tmpl.Execute(message, callRequest.Values["data"])
+	if err != nil {
+		err = newUserInputError("error rendering template: %s", err.Error())
+		sendErrorResponse(w, err)
+		return
+	}
+	_, err = clt.CreatePost(&model.Post{
+		ChannelId: route.ChannelID,
+		Message:   message.String(),
+	})
+	if err != nil {
+		err = newUpstreamError(err, "error posting webhook message")
+		sendErrorResponse(w, err)
+		return
+	}
+	sendCallResponse(w, apps.CallResponse{
+		Type: apps.CallResponseTypeOK,
+	})
+}
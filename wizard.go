@@ -0,0 +1,294 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+
+	"github.com/mattermost/mattermost-plugin-apps/apps"
+	"github.com/mattermost/mattermost-plugin-apps/utils"
+	"github.com/mattermost/mattermost-plugin-apps/utils/httputils"
+)
+
+const (
+	// wizardActionField is the submit buttons field added to every wizard form
+	wizardActionField = "wizard_action"
+
+	wizardActionBack    = "back"
+	wizardActionNext    = "next"
+	wizardActionCancel  = "cancel"
+	wizardActionConfirm = "confirm"
+)
+
+var wizardActionLabels = map[string]string{
+	wizardActionBack:    "Back",
+	wizardActionNext:    "Next",
+	wizardActionCancel:  "Cancel",
+	wizardActionConfirm: "Confirm",
+}
+
+// wizardStep is one form of a wizard
+type wizardStep struct {
+	Title  string
+	Header string
+	Fields []apps.Field
+}
+
+// wizard is a sequence of forms followed by a summary step. The answers to previous steps are carried in
+// the State of each form's submit call, so the wizard itself keeps no server-side state.
+type wizard struct {
+	// Path is the call path that opens the wizard; steps are submitted to Path + "/submit"
+	Path   string
+	Title  string
+	Icon   string
+	Expand *apps.Expand
+	Steps  []wizardStep
+	// OnComplete is called with every answer when the user confirms the summary step
+	OnComplete func(ctx context.Context, callRequest *apps.CallRequest, answers map[string]interface{}) (apps.CallResponse, error)
+}
+
+// wizardState is the State of a wizard form's submit call
+type wizardState struct {
+	Step    int                    `json:"step"`
+	Answers map[string]interface{} `json:"answers"`
+}
+
+// register adds the wizard's routes to the mux
+func (wz *wizard) register(mux *httputils.Handler) {
+	handleCall(mux, wz.Path, wz.start)
+	handleCall(mux, wz.Path+"/submit", wz.submit)
+}
+
+// binding returns a command binding that opens the wizard
+func (wz *wizard) binding(location apps.Location, description string) apps.Binding {
+	return apps.Binding{
+		Location:    location,
+		Label:       string(location),
+		Description: description,
+		Submit:      wz.startCall(),
+	}
+}
+
+func (wz *wizard) startCall() *apps.Call {
+	call := apps.NewCall(wz.Path)
+	if wz.Expand != nil {
+		call = call.WithExpand(*wz.Expand)
+	}
+	return call
+}
+
+func (wz *wizard) submitCall(state wizardState) *apps.Call {
+	call := wz.startCall()
+	call.Path += "/submit"
+	return call.WithState(state)
+}
+
+func wizardActions(actions ...string) apps.Field {
+	field := apps.Field{
+		Name: wizardActionField,
+		Type: apps.FieldTypeStaticSelect,
+	}
+	for _, action := range actions {
+		field.SelectStaticOptions = append(field.SelectStaticOptions, apps.SelectOption{
+			Label: wizardActionLabels[action],
+			Value: action,
+		})
+	}
+	return field
+}
+
+// form returns the form for the step in the state, prefilled with any previous answers
+func (wz *wizard) form(state wizardState) *apps.Form {
+	if state.Step >= len(wz.Steps) {
+		return wz.summaryForm(state)
+	}
+	step := wz.Steps[state.Step]
+	form := &apps.Form{
+		Title:         wz.Title,
+		Header:        fmt.Sprintf("**Step %d of %d: %s**", state.Step+1, len(wz.Steps), step.Title),
+		Icon:          wz.Icon,
+		Submit:        wz.submitCall(state),
+		SubmitButtons: wizardActionField,
+	}
+	if step.Header != "" {
+		form.Header = fmt.Sprintf("%s\n\n%s", form.Header, step.Header)
+	}
+	for _, field := range step.Fields {
+		fieldClone := field.PartialCopy()
+		if answer, ok := state.Answers[field.Name]; ok {
+			fieldClone.Value = answer
+		}
+		form.Fields = append(form.Fields, *fieldClone)
+	}
+	actions := []string{wizardActionNext, wizardActionCancel}
+	if state.Step > 0 {
+		actions = []string{wizardActionBack, wizardActionNext, wizardActionCancel}
+	}
+	form.Fields = append(form.Fields, wizardActions(actions...))
+	return form
+}
+
+func (wz *wizard) summaryForm(state wizardState) *apps.Form {
+	summary := ""
+	for _, step := range wz.Steps {
+		summary += fmt.Sprintf("##### %s\n", step.Title)
+		for _, field := range step.Fields {
+			label := field.Label
+			if field.ModalLabel != "" {
+				label = field.ModalLabel
+			}
+			summary += fmt.Sprintf("- %s: %s\n", label, strings.Join(wizardAnswerLabels(state.Answers[field.Name]), ", "))
+		}
+	}
+	return &apps.Form{
+		Title:  wz.Title,
+		Header: "**Summary**\n\nPlease review your answers.",
+		Icon:   wz.Icon,
+		Fields: []apps.Field{
+			{
+				Name:        "summary",
+				Type:        apps.FieldTypeMarkdown,
+				Description: summary,
+			},
+			wizardActions(wizardActionBack, wizardActionConfirm, wizardActionCancel),
+		},
+		Submit:        wz.submitCall(state),
+		SubmitButtons: wizardActionField,
+	}
+}
+
+// wizardAnswerLabels returns the displayable form of an answer
+func wizardAnswerLabels(answer interface{}) []string {
+	switch typedAnswer := answer.(type) {
+	case nil:
+		return []string{"(none)"}
+	case map[string]interface{}:
+		if label, ok := typedAnswer["label"].(string); ok && label != "" {
+			return []string{label}
+		}
+		return submittedValues(typedAnswer)
+	case []interface{}:
+		labels := make([]string, 0, len(typedAnswer))
+		for _, item := range typedAnswer {
+			labels = append(labels, wizardAnswerLabels(item)...)
+		}
+		return labels
+	default:
+		values := submittedValues(typedAnswer)
+		if len(values) == 0 {
+			return []string{"(none)"}
+		}
+		return values
+	}
+}
+
+// record copies the values of the current step's fields into the answers
+func (wz *wizard) record(state *wizardState, values map[string]interface{}) {
+	if state.Step >= len(wz.Steps) {
+		return
+	}
+	for _, field := range wz.Steps[state.Step].Fields {
+		if value, ok := values[field.Name]; ok && value != nil {
+			state.Answers[field.Name] = value
+			continue
+		}
+		delete(state.Answers, field.Name)
+	}
+}
+
+func (wz *wizard) start(w http.ResponseWriter, r *http.Request) {
+	_, err := getCallRequest(r)
+	if err != nil {
+		log.Printf("wizard.start(): %s\n", err.Error())
+		sendErrorResponse(w, err)
+		return
+	}
+	sendCallResponse(w, apps.NewFormResponse(*wz.form(wizardState{
+		Answers: make(map[string]interface{}),
+	})))
+}
+
+func (wz *wizard) submit(w http.ResponseWriter, r *http.Request) {
+	callRequest, err := getCallRequest(r)
+	if err != nil {
+		log.Printf("wizard.submit(): %s\n", err.Error())
+		sendErrorResponse(w, err)
+		return
+	}
+	state := wizardState{}
+	utils.Remarshal(&state, callRequest.State)
+	if state.Answers == nil {
+		state.Answers = make(map[string]interface{})
+	}
+	if state.Step < 0 || state.Step > len(wz.Steps) {
+		sendErrorResponse(w, newUserInputError("invalid wizard step"))
+		return
+	}
+	action := callRequest.GetValue(wizardActionField, "")
+	switch action {
+	case wizardActionCancel:
+		sendCallResponse(w, apps.NewTextResponse("%s was cancelled", wz.Title))
+	case wizardActionBack:
+		wz.record(&state, callRequest.Values)
+		if state.Step > 0 {
+			state.Step--
+		}
+		sendCallResponse(w, apps.NewFormResponse(*wz.form(state)))
+	case wizardActionNext:
+		if state.Step >= len(wz.Steps) {
+			sendErrorResponse(w, newUserInputError("the wizard has no more steps"))
+			return
+		}
+		fieldErrors := formRulesFor(apps.Form{Fields: wz.Steps[state.Step].Fields}).validate(callRequest.Values)
+		if len(fieldErrors) > 0 {
+			sendCallResponse(w, newFieldErrorsResponse(fieldErrors))
+			return
+		}
+		wz.record(&state, callRequest.Values)
+		state.Step++
+		sendCallResponse(w, apps.NewFormResponse(*wz.form(state)))
+	case wizardActionConfirm:
+		if state.Step != len(wz.Steps) {
+			sendErrorResponse(w, newUserInputError("the wizard is not complete"))
+			return
+		}
+		err = wz.validate(state.Answers)
+		if err != nil {
+			sendErrorResponse(w, err)
+			return
+		}
+		callResponse, err := wz.OnComplete(r.Context(), callRequest, state.Answers)
+		if err != nil {
+			sendErrorResponse(w, err)
+			return
+		}
+		sendCallResponse(w, callResponse)
+	default:
+		sendErrorResponse(w, newUserInputError("unknown wizard action %q", action))
+	}
+}
+
+// validate checks the answers against the rules of every step before they are completed. The answers come
+// back from the client in the call's State, so they are not trusted to be the ones each step accepted.
+// Answers to fields that no step has are removed.
+func (wz *wizard) validate(answers map[string]interface{}) error {
+	fields := make(map[string]bool)
+	for i, step := range wz.Steps {
+		fieldErrors := formRulesFor(apps.Form{Fields: step.Fields}).validate(answers)
+		for _, field := range step.Fields {
+			fields[field.Name] = true
+			if message, ok := fieldErrors[field.Name]; ok {
+				return newUserInputError("the answer to %q in step %d is invalid: %s Please go back and correct it.",
+					field.Name, i+1, message)
+			}
+		}
+	}
+	for name := range answers {
+		if !fields[name] {
+			delete(answers, name)
+		}
+	}
+	return nil
+}